@@ -0,0 +1,91 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/edgelesssys/edgelessdb/edb/core"
+	"github.com/edgelesssys/edgelessdb/edb/rt"
+)
+
+// loadedConfig is the config as loaded at startup before any paths are adjusted for the enclave.
+var loadedConfig core.Config
+
+// loadConfig applies the config file and the environment variables to the defaults and validates the result.
+func loadConfig(config core.Config, configFile string) core.Config {
+	if configFile == "" {
+		configFile = os.Getenv(core.EnvConfigFile)
+	}
+	if configFile != "" {
+		data, err := ioutil.ReadFile(hostPath(configFile))
+		if err != nil {
+			panic(err)
+		}
+		config, err = core.FillConfigFromFile(config, data)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Load config parameters from environment variables
+	config = core.FillConfigFromEnvironment(config)
+
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+	// Validate has checked the log settings.
+	logLevel, _ := rt.ParseLevel(config.LogLevel)
+	if err := rt.Log.Configure(config.LogFormat, logLevel); err != nil {
+		panic(err)
+	}
+	if err := checkWritable(hostPath(config.DataPath)); err != nil {
+		panic(err)
+	}
+	if config.LogDir != "" {
+		if err := checkWritable(hostPath(config.LogDir)); err != nil {
+			panic(err)
+		}
+	}
+	if config.SnapshotDir != "" {
+		if err := checkWritable(hostPath(config.SnapshotDir)); err != nil {
+			panic(err)
+		}
+	}
+	if config.BinlogDir != "" {
+		if err := checkWritable(hostPath(config.BinlogDir)); err != nil {
+			panic(err)
+		}
+	}
+
+	mainLog.Info("effective config", "config", config)
+	loadedConfig = config
+	return config
+}
+
+// checkWritable creates the directory if it doesn't exist and checks that files can be created in it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".edb-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
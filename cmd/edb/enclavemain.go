@@ -18,15 +18,15 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"syscall"
 
 	"github.com/edgelesssys/edgelessdb/edb/core"
-	"github.com/edgelesssys/edgelessdb/edb/rt"
 	"github.com/edgelesssys/ego/enclave"
 	"github.com/edgelesssys/marblerun/marble/premain"
 )
@@ -38,12 +38,8 @@ var gitCommit = "0000000000000000000000000000000000000000"
 const internalPath = "/tmp/edb" // supposed to be mounted in emain.cpp
 
 func main() {
-	// The Go portions of EDB aren't performance-critical, so limit max procs to have more threads available for MariaDB.
-	runtime.GOMAXPROCS(2)
-
-	rt.Log.Printf("EdgelessDB v%v (%v)\n", version, gitCommit)
-
 	runAsMarble := flag.Bool("marble", false, "Run edb with Marblerun")
+	configFile := flag.String("config", "", "Path to a JSON or YAML config file (overrides "+core.EnvConfigFile+")")
 	flag.Parse()
 
 	if *runAsMarble {
@@ -55,14 +51,17 @@ func main() {
 
 	config := core.Config{
 		DataPath:           "data",
-		APIAddress:         ":8080",
+		APIAddresses:       []string{":8080"},
 		CertificateDNSName: "localhost",
 		Debug:              false,
 		LogDir:             "",
+		// The Go portions of EDB aren't performance-critical, so limit max procs to have more threads available for MariaDB.
+		GoMaxProcs: 2,
 	}
 
-	// Load config parameters from environment variables
-	config = core.FillConfigFromEnvironment(config)
+	// Load config parameters from the config file and environment variables
+	config = loadConfig(config, *configFile)
+	mainLog.Info("EdgelessDB", "version", version, "commit", gitCommit)
 
 	if err := os.Mkdir(internalPath, 0); err != nil {
 		panic(err)
@@ -80,6 +79,22 @@ func main() {
 		config.LogDir = "/log"
 	}
 
+	// mount snapshot dir from hostfs if set
+	if len(config.SnapshotDir) > 0 {
+		if err := syscall.Mount(enclaveAbsPath(config.SnapshotDir), "/snapshots", "oe_host_file_system", 0, ""); err != nil {
+			panic(err)
+		}
+		config.SnapshotDir = "/snapshots"
+	}
+
+	// mount binlog dir from hostfs if set
+	if len(config.BinlogDir) > 0 {
+		if err := syscall.Mount(enclaveAbsPath(config.BinlogDir), "/binlogs", "oe_host_file_system", 0, ""); err != nil {
+			panic(err)
+		}
+		config.BinlogDir = "/binlogs"
+	}
+
 	// mount rocksdb dir from hostfs
 	absDataPath := enclaveAbsPath(config.DataPath)
 	if err := os.MkdirAll(hostPath(absDataPath), 0700); err != nil {
@@ -126,6 +141,24 @@ func (executionEnv) GetRemoteReport(reportData []byte) ([]byte, error) {
 	return enclave.GetRemoteReport(reportData)
 }
 
+func (executionEnv) VerifyPeerReport(report, reportData []byte) error {
+	remote, err := enclave.VerifyRemoteReport(report)
+	if err != nil {
+		return err
+	}
+	self, err := enclave.GetSelfReport()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(remote.UniqueID, self.UniqueID) || remote.Debug != self.Debug {
+		return errors.New("report has been created by a different enclave")
+	}
+	if len(remote.Data) < len(reportData) || !bytes.Equal(remote.Data[:len(reportData)], reportData) {
+		return errors.New("report data doesn't match")
+	}
+	return nil
+}
+
 func (executionEnv) GetProductSealKey() ([]byte, error) {
 	key, _, err := enclave.GetProductSealKey()
 	return key, err
@@ -16,41 +16,60 @@
 package main
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync/atomic"
 
-	"github.com/edgelesssys/edgelessdb/edb/core"
 	"github.com/edgelesssys/edgelessdb/edb/db"
-	"github.com/fatih/color"
-)
-
-const (
-	errRocksDBInit = "Plugin 'ROCKSDB' registration as a STORAGE ENGINE failed."
-	errBind        = "Can't start server: Bind on TCP/IP port. Got error:"
 )
 
 func exit(status int) {
-	determineError() // Print more specific error whenever we can detect one
-	color.Red("edb has exited unexpectedly (exit code: %d).", status)
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		// MariaDB exits after a requested shutdown. Let run finish the shutdown and terminate the process.
+		mariadbdExited <- status
+		select {}
+	}
+
+	events := determineError() // Print more specific error whenever we can detect one
+	if crashHandler != nil {
+		crashHandler(classifyError(events))
+	}
+	mainLog.Error("edb has exited unexpectedly", "exit_code", status)
 	os.Exit(status)
 }
 
-func determineError() {
+// crashHandler handles an unexpected exit of MariaDB. It doesn't return if it restarts edb.
+var crashHandler func(reason string, restartable bool)
+
+// classifyError returns a short reason for MariaDB's exit and whether a restart may help.
+func classifyError(events []db.Event) (reason string, restartable bool) {
+	if len(events) == 0 {
+		return "unknown", true
+	}
+	// the first event is the most likely cause
+	switch kind := events[0].Kind; kind {
+	case db.EventWrongKeyOrCorruption, db.EventTLSFile:
+		return string(kind), false
+	default:
+		return string(kind), true
+	}
+}
+
+// determineError prints a more specific error if it can detect one and returns the diagnosed events.
+func determineError() []db.Event {
 	// Try to read error log either from internal memfs (debug = off or no path specified) or from a specified path (debug = on + path specified)
 	var errorLogBasePath string
 	var pointUserToDebugLog bool
 
-	if os.Getenv(core.EnvDebug) == "" {
+	if !loadedConfig.Debug {
 		errorLogBasePath = internalPath
 	} else {
-		logDir := os.Getenv(core.EnvLogDir)
+		logDir := loadedConfig.LogDir
 		if logDir == "" {
 			// Cannot determine, as this was printed to stderr. User needs to look into the terminal by themself.
-			return
+			return nil
 		}
 		errorLogBasePath = hostPath(logDir)
 		pointUserToDebugLog = true
@@ -59,26 +78,27 @@ func determineError() {
 	errorLogPath := filepath.Join(errorLogBasePath, db.FilenameErrorLog)
 	errorLogBytes, err := ioutil.ReadFile(errorLogPath)
 	if err != nil {
-		return
+		return nil
 	}
 	errorLog := string(errorLogBytes)
 
-	if strings.Contains(errorLog, errRocksDBInit) {
-		fmt.Fprint(os.Stderr, errorLog) // Always print error log in this case, as we expect that a failed initialization should not leak any sensitive data
-		color.Red("eRocksDB failed to initialize correctly.")
-		color.Red("This likely failed due to an incorrect key being used to decrypt the database or the database being corrupted.")
-		color.Red("Make sure you run edb on the same machine as it was initialized on.")
+	events := db.Diagnose(errorLog)
+	for _, event := range events {
+		if event.Kind == db.EventWrongKeyOrCorruption {
+			mainLog.Error("MariaDB error log", "log", errorLog) // Always print error log in this case, as we expect that a failed initialization should not leak any sensitive data
+			break
+		}
 	}
-	if strings.Contains(errorLog, errBind) {
-		matches := regexp.MustCompile(`running on port: (\d+) \?`).FindStringSubmatch(errorLog)
-		if len(matches) == 2 {
-			color.Red("Bind on TCP/IP port %v failed.", matches[1])
-		} else {
-			color.Red("Bind on TCP/IP port failed.")
+	for _, event := range events {
+		fields := []interface{}{"event", event.Kind}
+		if event.Kind == db.EventWrongKeyOrCorruption {
+			fields = append(fields, "hint", "Make sure you run edb on the same machine as it was initialized on.")
 		}
+		mainLog.Error(event.Description(), fields...)
 	}
 
 	if pointUserToDebugLog {
-		color.Red("You can find the error log at: %s", strings.TrimPrefix(errorLogPath, "/edg/hostfs"))
+		mainLog.Error("you can find the error log in the log directory", "path", strings.TrimPrefix(errorLogPath, "/edg/hostfs"))
 	}
+	return events
 }
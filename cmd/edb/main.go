@@ -20,7 +20,6 @@ package main
 import (
 	"errors"
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -34,6 +33,7 @@ var internalPath string
 
 func main() {
 	runAsMarble := flag.Bool("marble", false, "Run edb with Marblerun")
+	configFile := flag.String("config", "", "Path to a JSON or YAML config file (overrides "+core.EnvConfigFile+")")
 	flag.Parse()
 
 	if *runAsMarble {
@@ -45,15 +45,15 @@ func main() {
 
 	config := core.Config{
 		DataPath:           "data",
-		DatabaseAddress:    "127.0.0.1",
-		APIAddress:         "127.0.0.1:8080",
+		DatabaseAddresses:  []string{"127.0.0.1"},
+		APIAddresses:       []string{"127.0.0.1:8080"},
 		CertificateDNSName: "localhost",
 		Debug:              false,
 		LogDir:             "",
 	}
 
-	// Load config parameters from environment variables
-	config = core.FillConfigFromEnvironment(config)
+	// Load config parameters from the config file and environment variables
+	config = loadConfig(config, *configFile)
 
 	var err error
 	internalPath, err = ioutil.TempDir("", "")
@@ -65,10 +65,7 @@ func main() {
 	config.DataPath = hostPath(config.DataPath)
 
 	// Warn user this is not a trustful setup at all!
-	fmt.Println("edb is running in non-enclave mode without Marblerun.")
-	fmt.Println("This means edb will save the encryption key used IN PLAINTEXT on the disk.")
-	fmt.Println("THIS IS OBVIOUSLY NOT SECURE AT ALL FOR PRODUCTION!")
-	fmt.Println("Only ever use non-enclave mode for testing, please...")
+	mainLog.Warn("edb is running in non-enclave mode without Marblerun; this means edb will save the encryption key used IN PLAINTEXT on the disk, THIS IS OBVIOUSLY NOT SECURE AT ALL FOR PRODUCTION! Only ever use non-enclave mode for testing, please ...")
 	if err := os.MkdirAll(path.Join(hostPath(config.DataPath), core.PersistenceDir), 0o700); err != nil && !os.IsExist(err) {
 		panic(err)
 	}
@@ -90,6 +87,10 @@ func (executionEnv) GetRemoteReport(reportData []byte) ([]byte, error) {
 	return nil, errors.New("GetRemoteReport: not running in an enclave")
 }
 
+func (executionEnv) VerifyPeerReport(report, reportData []byte) error {
+	return errors.New("VerifyPeerReport: not running in an enclave")
+}
+
 func (executionEnv) GetProductSealKey() ([]byte, error) {
 	return make([]byte, 16), nil
 }
@@ -17,8 +17,10 @@ package main
 
 /*
 #cgo LDFLAGS: -Wl,-unresolved-symbols=ignore-in-object-files
+#include <stdlib.h>
 #include <unistd.h>
 int edgeless_mysqld_main(int argc, char** argv);
+void edgeless_set_ssl_crl(const char* path);
 
 static void waitUntilSet(volatile int* p) {
 	do {
@@ -38,6 +40,17 @@ static void waitUntilListenInternalReady() {
 */
 import "C"
 
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// shuttingDown is set once a shutdown of mariadbd has been requested. exit then sends the status to mariadbdExited.
+var (
+	shuttingDown   int32
+	mariadbdExited = make(chan int, 1)
+)
+
 type mariadbd struct{}
 
 func (mariadbd) Main(cnfPath string) int {
@@ -52,3 +65,14 @@ func (mariadbd) WaitUntilStarted() {
 func (mariadbd) WaitUntilListenInternalReady() {
 	C.waitUntilListenInternalReady()
 }
+
+func (mariadbd) SetSSLCRL(path string) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	C.edgeless_set_ssl_crl(cPath)
+}
+
+func (mariadbd) PrepareShutdown() <-chan int {
+	atomic.StoreInt32(&shuttingDown, 1)
+	return mariadbdExited
+}
@@ -16,26 +16,55 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
 	"github.com/edgelesssys/edgelessdb/edb/core"
 	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/edgelesssys/edgelessdb/edb/rt"
 	"github.com/edgelesssys/edgelessdb/edb/server"
-	"github.com/fatih/color"
 	"github.com/spf13/afero"
 )
 
+// mainLog is the logger of the edb process.
+var mainLog = rt.Log.With("component", "main")
+
 func run(cfg core.Config, isMarble bool, internalPath string, internalAddress string) {
-	var rt executionEnv
+	var env executionEnv
+
+	threadPool, err := cfg.ThreadPool(env.GetNumTCS())
+	if err != nil {
+		panic(err)
+	}
+	if cfg.GoMaxProcs > 0 {
+		runtime.GOMAXPROCS(cfg.GoMaxProcs)
+	}
 
-	// There are quite a few MariaDB and RocksDB helper threads in addition to pool threads. Let's be rather generous here.
-	maxPoolThreads := rt.GetNumTCS() - 32
+	tlsPolicy, err := cfg.TLSPolicy()
+	if err != nil {
+		panic(err)
+	}
 
-	db, err := db.NewMariadb(internalPath, cfg.DataPath, internalAddress, cfg.DatabaseAddress, cfg.CertificateDNSName, cfg.LogDir, cfg.Debug, isMarble, mariadbd{}, maxPoolThreads)
+	db, err := db.NewMariadb(internalPath, cfg.DataPath, internalAddress, cfg.DatabaseAddresses, cfg.CertificateDNSName, cfg.LogDir, cfg.Debug, isMarble, mariadbd{}, threadPool, tlsPolicy)
 	if err != nil {
 		panic(err)
 	}
 
+	// Replicas follow the binary log of the primary.
+	if cfg.BinlogDir != "" || cfg.Replication {
+		db.EnableBinlog()
+	}
+
 	fs := afero.Afero{Fs: afero.NewOsFs()}
-	core := core.NewCore(cfg, rt, db, fs, isMarble)
+	core := core.NewCore(cfg, env, db, fs, isMarble)
+
+	// MariaDB usually exits through the exit hook, but handle a return from its main function the same way
+	db.SetExitHandler(exit)
+	crashHandler = core.HandleCrash
 
 	mux := server.CreateServeMux(core)
 	if !core.IsRecovering() {
@@ -43,9 +72,7 @@ func run(cfg core.Config, isMarble bool, internalPath string, internalAddress st
 			panic(err)
 		}
 	} else {
-		color.Red("edb failed to retrieve the database encryption key and has entered recovery mode.")
-		color.Red("You can use the /recover API endpoint to upload the recovery data which was generated when the manifest has been initialized originally.")
-		color.Red("For more information, visit: https://edglss.cc/doc-edb-recovery")
+		mainLog.Error("edb failed to retrieve the database encryption key and has entered recovery mode; you can use the /recover API endpoint to upload the recovery data which was generated when the manifest has been initialized originally, for more information visit https://edglss.cc/doc-edb-recovery", "phase", "startup")
 
 		// Generate quote for temporary certificate in recovery mode
 		// Should not be able to panic as GenerateReport only returns errors in Marble mode, however recovery is not available in Marble mode.
@@ -53,5 +80,30 @@ func run(cfg core.Config, isMarble bool, internalPath string, internalAddress st
 			panic(err)
 		}
 	}
-	server.RunServer(mux, cfg.APIAddress, core.GetTLSConfig())
+
+	// Shut down cleanly on SIGTERM, e.g., during a rolling update in Kubernetes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	srv := server.NewServer(mux, core.GetTLSConfig())
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe(cfg.APIAddresses) }()
+
+	select {
+	case err := <-errs:
+		mainLog.Fatal("HTTP REST API failed", "error", err)
+	case <-ctx.Done():
+	}
+
+	shutdownLog := mainLog.With("phase", "shutdown")
+	shutdownLog.Info("received signal, shutting down ...")
+	deadline := time.Now().Add(cfg.GetShutdownTimeout())
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		shutdownLog.Error("HTTP REST API shutdown failed", "error", err)
+	}
+	if err := core.StopDatabase(time.Until(deadline)); err != nil {
+		shutdownLog.Fatal("stopping the database failed", "error", err)
+	}
 }
@@ -74,6 +74,31 @@ const sidebars = {
           label: 'MarbleRun',
           id: 'advanced/marblerun',
         },
+        {
+          type: 'doc',
+          label: 'Root certificate',
+          id: 'advanced/certificates',
+        },
+        {
+          type: 'doc',
+          label: 'Backup and snapshots',
+          id: 'advanced/backup',
+        },
+        {
+          type: 'doc',
+          label: 'Replication',
+          id: 'advanced/replication',
+        },
+        {
+          type: 'doc',
+          label: 'Audit log',
+          id: 'advanced/audit',
+        },
+        {
+          type: 'doc',
+          label: 'Query digests',
+          id: 'advanced/query-digests',
+        },
       ],
     },
     {
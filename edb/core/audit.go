@@ -0,0 +1,220 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+)
+
+// Operations that are recorded in the audit log in addition to the privileged operations.
+const (
+	operationManifest = "manifest"
+	operationRecover  = "recover"
+	operationRestore  = "restore"
+	operationReplica  = "replica"
+	operationRenew    = "renew"
+	operationCSR      = "csr"
+	operationUnlock   = "unlock"
+	// operationDropped records how many operations before the initialization didn't fit into memory.
+	operationDropped = "dropped"
+)
+
+// maxAuditPending is the number of records that are kept in memory until the database has been initialized.
+const maxAuditPending = 1000
+
+// auditOutcomeSuccess is the outcome of a successful operation. The outcome of a failed operation is the error message.
+const auditOutcomeSuccess = "success"
+
+// auditRecord is the content of an audit log entry.
+// Each entry is signed with the root key and contains the hash of the previous entry, so entries can't be removed or reordered unnoticed.
+type auditRecord struct {
+	Seq       uint64
+	Time      time.Time
+	Operation string
+	// Admin is the name of the admin that has been authorized for the operation.
+	Admin string `json:",omitempty"`
+	// Client is the SHA-256 hash of the client certificate.
+	Client []byte `json:",omitempty"`
+	// Manifest is the SHA-256 hash of the manifest of the database.
+	Manifest []byte `json:",omitempty"`
+	Outcome  string
+	// Previous is the SHA-256 hash of the content of the previous entry.
+	Previous []byte `json:",omitempty"`
+	// Signer is the SHA-256 hash of the root certificate whose key has signed the entry.
+	Signer []byte
+}
+
+// auditHead is the content of the signed head of the audit log. It proves that the log is complete.
+type auditHead struct {
+	// Count is the number of entries.
+	Count uint64
+	// Hash is the SHA-256 hash of the content of the last entry.
+	Hash []byte `json:",omitempty"`
+	Time time.Time
+	// Certificate is the DER-encoded root certificate whose key has signed the head.
+	Certificate []byte
+}
+
+// AuditLog is the audit log of administrative operations. Contents and signatures are JSON-encoded auditRecords and auditHead.
+type AuditLog struct {
+	Entries []db.AuditEntry
+	Head    db.AuditEntry
+}
+
+// GetAuditLog returns the audit log and a signed head. cert must be the client certificate of an admin.
+func (c *Core) GetAuditLog(cert *x509.Certificate) (AuditLog, error) {
+	if _, _, err := c.authorizeAdmin(cert, operationAudit); err != nil {
+		return AuditLog{}, err
+	}
+
+	c.auditMutex.Lock()
+	defer c.auditMutex.Unlock()
+	entries, err := c.db.GetAuditEntries()
+	if err != nil {
+		return AuditLog{}, err
+	}
+	rootCert, _ := c.db.GetCertificate()
+	head := auditHead{Count: uint64(len(entries)), Time: time.Now().UTC(), Certificate: rootCert}
+	if len(entries) > 0 {
+		hash := sha256.Sum256(entries[len(entries)-1].Content)
+		head.Hash = hash[:]
+	}
+	signedHead, err := c.signAuditContent(head)
+	if err != nil {
+		return AuditLog{}, err
+	}
+	return AuditLog{Entries: entries, Head: signedHead}, nil
+}
+
+// audit records the outcome of an operation. admin is empty if no admin has been authorized.
+// Entries are kept in memory until the database has been initialized.
+// Rejections of unauthenticated clients aren't recorded, so that anyone who can reach the API can't fill the log.
+func (c *Core) audit(operation string, cert *x509.Certificate, admin string, err error) {
+	if isUnauthenticatedRejection(err) {
+		return
+	}
+	record := auditRecord{Time: time.Now().UTC(), Operation: operation, Admin: admin, Outcome: auditOutcomeSuccess}
+	if cert != nil {
+		hash := sha256.Sum256(cert.Raw)
+		record.Client = hash[:]
+	}
+	if err != nil {
+		record.Outcome = err.Error()
+	}
+	if jsonManifest := c.db.GetManifest(); jsonManifest != nil {
+		hash := sha256.Sum256(jsonManifest)
+		record.Manifest = hash[:]
+	}
+
+	c.auditMutex.Lock()
+	defer c.auditMutex.Unlock()
+	if len(c.auditPending) < maxAuditPending || c.db.GetManifest() != nil {
+		c.auditPending = append(c.auditPending, record)
+	} else {
+		c.auditDropped++
+	}
+	if err := c.flushAuditLog(); err != nil {
+		c.log.Error("writing audit log failed", "error", err)
+	}
+}
+
+// flushAuditLog appends the pending records to the audit log. The caller must hold auditMutex.
+func (c *Core) flushAuditLog() error {
+	if len(c.auditPending) == 0 || c.db.GetManifest() == nil {
+		return nil
+	}
+	if c.auditDropped > 0 {
+		dropped := auditRecord{
+			Time:      time.Now().UTC(),
+			Operation: operationDropped,
+			Outcome:   fmt.Sprintf("%v operations before the initialization weren't recorded", c.auditDropped),
+		}
+		c.auditPending = append([]auditRecord{dropped}, c.auditPending...)
+		c.auditDropped = 0
+	}
+
+	// Only the last entry is needed to continue the hash chain.
+	last, err := c.db.GetLastAuditEntry()
+	if err != nil {
+		return err
+	}
+	var seq uint64
+	var previous []byte
+	if last.Content != nil {
+		var lastRecord auditRecord
+		if err := json.Unmarshal(last.Content, &lastRecord); err != nil {
+			return err
+		}
+		seq = lastRecord.Seq
+		hash := sha256.Sum256(last.Content)
+		previous = hash[:]
+	}
+	rootCert, _ := c.db.GetCertificate()
+	signer := sha256.Sum256(rootCert)
+
+	for len(c.auditPending) > 0 {
+		record := c.auditPending[0]
+		seq++
+		record.Seq = seq
+		record.Previous = previous
+		record.Signer = signer[:]
+		entry, err := c.signAuditContent(record)
+		if err != nil {
+			return err
+		}
+		if err := c.db.AppendAuditEntry(entry); err != nil {
+			return err
+		}
+		hash := sha256.Sum256(entry.Content)
+		previous = hash[:]
+		c.auditPending = c.auditPending[1:]
+	}
+	return nil
+}
+
+// isUnauthenticatedRejection returns if err rejects a client before it has been authenticated,
+// e.g., because it isn't an admin or the database hasn't been initialized yet.
+func isUnauthenticatedRejection(err error) bool {
+	return errors.Is(err, ErrNotAdmin) || errors.Is(err, ErrNotAttested) || errors.Is(err, errNotInitialized) || errors.Is(err, ErrRecoveryLocked)
+}
+
+// signAuditContent encodes v and signs it with the root key.
+func (c *Core) signAuditContent(v interface{}) (db.AuditEntry, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return db.AuditEntry{}, err
+	}
+	_, key := c.db.GetCertificate()
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return db.AuditEntry{}, errors.New("root key can't sign")
+	}
+	hash := sha256.Sum256(content)
+	signature, err := signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return db.AuditEntry{}, err
+	}
+	return db.AuditEntry{Content: content, Signature: signature}, nil
+}
@@ -0,0 +1,180 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	core, _ := newCoreWithMocks()
+	mockDB := core.db.(*db.DatabaseMock)
+	require.NoError(core.StartDatabase())
+
+	// recorded once the database has been initialized
+	_, err := core.Initialize([]byte("invalid"))
+	require.Error(err)
+	assert.Empty(mockDB.AuditEntries)
+
+	jsonManifest := createBackupManifest("", adminCertPEM)
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(err)
+	require.NoError(core.SetReadOnly(adminCert, true, false))
+	// rejections of unauthenticated clients aren't recorded
+	assert.ErrorIs(core.SetReadOnly(nil, false, false), ErrNotAdmin)
+	require.NoError(core.SetReadOnly(adminCert, false, false))
+
+	_, err = core.GetAuditLog(nil)
+	assert.ErrorIs(err, ErrNotAdmin)
+	auditLog, err := core.GetAuditLog(adminCert)
+	require.NoError(err)
+
+	rootCertDER, _ := mockDB.GetCertificate()
+	rootCert, err := x509.ParseCertificate(rootCertDER)
+	require.NoError(err)
+	records, err := verifyAuditLog(auditLog, rootCert)
+	require.NoError(err)
+	require.Len(records, 4)
+
+	manifestHash := sha256.Sum256(jsonManifest)
+	clientHash := sha256.Sum256(adminCert.Raw)
+	assert.Equal(operationManifest, records[0].Operation)
+	assert.NotEqual(auditOutcomeSuccess, records[0].Outcome)
+	assert.Nil(records[0].Manifest)
+	assert.Equal(operationManifest, records[1].Operation)
+	assert.Equal(auditOutcomeSuccess, records[1].Outcome)
+	assert.Equal(manifestHash[:], records[1].Manifest)
+	assert.Equal(operationReadOnly, records[2].Operation)
+	assert.Equal("alice", records[2].Admin)
+	assert.Equal(clientHash[:], records[2].Client)
+	assert.Equal(auditOutcomeSuccess, records[2].Outcome)
+	assert.Equal(operationReadOnly, records[3].Operation)
+	assert.Equal("alice", records[3].Admin)
+	assert.Equal(uint64(4), records[3].Seq)
+
+	// removing or modifying an entry is detected
+	removed := auditLog
+	removed.Entries = append([]db.AuditEntry{}, auditLog.Entries[:2]...)
+	removed.Entries = append(removed.Entries, auditLog.Entries[3])
+	_, err = verifyAuditLog(removed, rootCert)
+	assert.Error(err)
+
+	truncated := auditLog
+	truncated.Entries = auditLog.Entries[:3]
+	_, err = verifyAuditLog(truncated, rootCert)
+	assert.Error(err)
+
+	modified := auditLog
+	modified.Entries = append([]db.AuditEntry{}, auditLog.Entries...)
+	modified.Entries[2].Content = bytes.Replace(modified.Entries[2].Content, []byte("alice"), []byte("bob"), 1)
+	_, err = verifyAuditLog(modified, rootCert)
+	assert.Error(err)
+}
+
+func TestAuditLogPendingLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, adminCertPEM := createMockClientCertificate(t)
+	core, _ := newCoreWithMocks()
+	mockDB := core.db.(*db.DatabaseMock)
+	require.NoError(core.StartDatabase())
+
+	for i := 0; i < maxAuditPending+5; i++ {
+		_, err := core.Initialize([]byte("invalid"))
+		require.Error(err)
+	}
+	_, err := core.GetAuditLog(nil)
+	assert.ErrorIs(err, errNotInitialized)
+	assert.Len(core.auditPending, maxAuditPending)
+
+	_, err = core.Initialize(createBackupManifest("", adminCertPEM))
+	require.NoError(err)
+	require.Len(mockDB.AuditEntries, maxAuditPending+2)
+	var record auditRecord
+	require.NoError(json.Unmarshal(mockDB.AuditEntries[0].Content, &record))
+	assert.Equal(operationDropped, record.Operation)
+	assert.Contains(record.Outcome, "5 operations")
+	require.NoError(json.Unmarshal(mockDB.AuditEntries[maxAuditPending+1].Content, &record))
+	assert.Equal(operationManifest, record.Operation)
+	assert.Equal(auditOutcomeSuccess, record.Outcome)
+	assert.Equal(uint64(maxAuditPending+2), record.Seq)
+	assert.Empty(core.auditPending)
+}
+
+// verifyAuditLog verifies the audit log like an auditor who trusts rootCert and returns its records.
+func verifyAuditLog(auditLog AuditLog, rootCert *x509.Certificate) ([]auditRecord, error) {
+	key := rootCert.PublicKey.(*ecdsa.PublicKey)
+	verify := func(entry db.AuditEntry) error {
+		hash := sha256.Sum256(entry.Content)
+		if !ecdsa.VerifyASN1(key, hash[:], entry.Signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	if err := verify(auditLog.Head); err != nil {
+		return nil, fmt.Errorf("head: %w", err)
+	}
+	var head auditHead
+	if err := json.Unmarshal(auditLog.Head.Content, &head); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head.Certificate, rootCert.Raw) {
+		return nil, errors.New("head has been signed by another root certificate")
+	}
+	if head.Count != uint64(len(auditLog.Entries)) {
+		return nil, errors.New("number of entries doesn't match the head")
+	}
+
+	signer := sha256.Sum256(rootCert.Raw)
+	var records []auditRecord
+	var previous []byte
+	for i, entry := range auditLog.Entries {
+		if err := verify(entry); err != nil {
+			return nil, fmt.Errorf("entry %v: %w", i, err)
+		}
+		var record auditRecord
+		if err := json.Unmarshal(entry.Content, &record); err != nil {
+			return nil, err
+		}
+		if record.Seq != uint64(i)+1 || !bytes.Equal(record.Previous, previous) || !bytes.Equal(record.Signer, signer[:]) {
+			return nil, fmt.Errorf("entry %v doesn't continue the chain", i)
+		}
+		hash := sha256.Sum256(entry.Content)
+		previous = hash[:]
+		records = append(records, record)
+	}
+	if !bytes.Equal(head.Hash, previous) {
+		return nil, errors.New("last entry doesn't match the head")
+	}
+	return records, nil
+}
@@ -0,0 +1,272 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	backupVersion   = 1
+	backupChunkSize = 64 * 1024
+	backupKeySize   = 32
+)
+
+// backupHeader is the first line of a backup. Each chunk of the backup authenticates it.
+type backupHeader struct {
+	Version int
+	// Key is the data key encrypted with the manifest's backup key.
+	Key      []byte
+	Manifest []byte
+	// Signature of the manifest. It isn't verified because anyone who knows the backup key can create a backup with any manifest.
+	// Instead, the operator provides the expected signature on restore.
+	Signature []byte
+}
+
+// Backup writes an encrypted logical dump of the database to w. cert must be the client certificate of an admin.
+// The dump is encrypted with a random data key, which is encrypted with the backup key of the manifest.
+func (c *Core) Backup(w io.Writer, cert *x509.Certificate) (err error) {
+	var admin string
+	defer func() { c.audit(operationBackup, cert, admin, err) }()
+	man, admin, err := c.authorizeAdmin(cert, operationBackup)
+	if err != nil {
+		return err
+	}
+	if man.Backup == "" {
+		return errors.New("the manifest doesn't declare a backup key")
+	}
+	backupKey, err := parseRSAPublicKey(man.Backup)
+	if err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, backupKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, backupKey, dataKey, nil)
+	if err != nil {
+		return err
+	}
+	signature := sha256.Sum256(man.raw)
+	header, err := json.Marshal(backupHeader{Version: backupVersion, Key: encryptedKey, Manifest: man.raw, Signature: signature[:]})
+	if err != nil {
+		return err
+	}
+
+	c.log.Info("creating backup", "admin", admin)
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return err
+	}
+	writer, err := newBackupWriter(w, dataKey, header)
+	if err != nil {
+		return err
+	}
+	if err := c.db.Dump(writer); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	c.log.Info("backup completed")
+	return nil
+}
+
+// Restore initializes the database with the manifest of a backup and imports the backup's dump.
+// key is the data key of the backup, decrypted with the private backup key. manifestSignature is the signature of the manifest
+// that the operator expects. Like Initialize, it returns the encrypted recovery key. If the import fails, the database stays uninitialized.
+func (c *Core) Restore(r io.Reader, key, manifestSignature []byte) (recoveryKey []byte, err error) {
+	defer func() { c.audit(operationRestore, nil, "", err) }()
+	if c.db.GetManifest() != nil {
+		return nil, errors.New("database has already been initialized")
+	}
+
+	br := bufio.NewReader(r)
+	header, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading backup header: %w", err)
+	}
+	header = header[:len(header)-1]
+	var parsedHeader backupHeader
+	if err := json.Unmarshal(header, &parsedHeader); err != nil {
+		return nil, fmt.Errorf("parsing backup header: %w", err)
+	}
+	if parsedHeader.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version: %v", parsedHeader.Version)
+	}
+	signature := sha256.Sum256(parsedHeader.Manifest)
+	if !bytes.Equal(signature[:], manifestSignature) {
+		return nil, errors.New("the manifest of the backup doesn't have the expected signature")
+	}
+
+	// This verifies the data key before the database is initialized.
+	reader, err := newBackupReader(br, key, header)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryKey, err = c.initialize(parsedHeader.Manifest, reader)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Info("restored backup")
+	return recoveryKey, nil
+}
+
+// backupWriter encrypts a stream in chunks with AES-GCM. Each chunk is prefixed by its length.
+// The nonce is the index of the chunk, and the last chunk is marked so that a truncated backup is detected.
+type backupWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	ad    []byte
+	buf   []byte
+	index uint64
+}
+
+func newBackupWriter(w io.Writer, key, ad []byte) (*backupWriter, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &backupWriter{w: w, aead: aead, ad: ad}, nil
+}
+
+func (b *backupWriter) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	// keep the remainder for the last chunk, which is written by Close
+	for len(b.buf) > backupChunkSize {
+		if err := b.writeChunk(b.buf[:backupChunkSize], false); err != nil {
+			return 0, err
+		}
+		b.buf = b.buf[backupChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close writes the last chunk.
+func (b *backupWriter) Close() error {
+	return b.writeChunk(b.buf, true)
+}
+
+func (b *backupWriter) writeChunk(p []byte, last bool) error {
+	sealed := b.aead.Seal(nil, backupNonce(b.index, last), p, b.ad)
+	b.index++
+	if err := binary.Write(b.w, binary.BigEndian, uint32(len(sealed))); err != nil {
+		return err
+	}
+	_, err := b.w.Write(sealed)
+	return err
+}
+
+// backupReader decrypts a stream that has been written by backupWriter.
+type backupReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	ad    []byte
+	buf   []byte
+	index uint64
+	done  bool
+}
+
+// newBackupReader returns a reader for the stream r. It decrypts the first chunk, which verifies the key and ad.
+func newBackupReader(r io.Reader, key, ad []byte) (*backupReader, error) {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	b := &backupReader{r: r, aead: aead, ad: ad}
+	if err := b.readChunk(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *backupReader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *backupReader) readChunk() error {
+	var length uint32
+	if err := binary.Read(b.r, binary.BigEndian, &length); err != nil {
+		if err == io.EOF {
+			return errors.New("backup is truncated")
+		}
+		return err
+	}
+	if length > backupChunkSize+uint32(b.aead.Overhead()) {
+		return errors.New("backup is corrupted")
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(b.r, sealed); err != nil {
+		return fmt.Errorf("backup is truncated: %w", err)
+	}
+
+	plaintext, err := b.aead.Open(nil, backupNonce(b.index, false), sealed, b.ad)
+	if err != nil {
+		if plaintext, err = b.aead.Open(nil, backupNonce(b.index, true), sealed, b.ad); err != nil {
+			return errors.New("cannot decrypt backup: wrong key or corrupted backup")
+		}
+		if n, _ := b.r.Read(make([]byte, 1)); n > 0 {
+			return errors.New("backup is corrupted: unexpected data after the last chunk")
+		}
+		b.done = true
+	}
+	b.index++
+	b.buf = plaintext
+	return nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != backupKeySize {
+		return nil, fmt.Errorf("backup key must be %v bytes", backupKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func backupNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
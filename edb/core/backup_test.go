@@ -0,0 +1,223 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	backupKeyPEM, backupKey, err := createMockRecoveryKey()
+	require.NoError(err)
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	otherCert, _ := createMockClientCertificate(t)
+	jsonManifest := createBackupManifest(backupKeyPEM, adminCertPEM)
+
+	core, _ := newCoreWithMocks()
+	require.NoError(core.StartDatabase())
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(err)
+
+	// a dump larger than a chunk
+	statements := []string{"CREATE TABLE t (i INT)", strings.Repeat("x", 3*backupChunkSize)}
+	core.db.(*db.DatabaseMock).DumpStatements = statements
+
+	var backup bytes.Buffer
+	assert.ErrorIs(core.Backup(&backup, nil), ErrNotAdmin)
+	assert.ErrorIs(core.Backup(&backup, otherCert), ErrNotAdmin)
+	require.NoError(core.Backup(&backup, adminCert))
+
+	// the data key is encrypted with the backup key
+	var header backupHeader
+	line, _, _ := bytes.Cut(backup.Bytes(), []byte("\n"))
+	require.NoError(json.Unmarshal(line, &header))
+	assert.Equal(jsonManifest, header.Manifest)
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, backupKey, header.Key, nil)
+	require.NoError(err)
+	signature := sha256.Sum256(jsonManifest)
+
+	// an initialized database can't be restored
+	_, err = core.Restore(bytes.NewReader(backup.Bytes()), key, signature[:])
+	assert.Error(err)
+
+	newCore, _ := newCoreWithMocks()
+	require.NoError(newCore.StartDatabase())
+	_, err = newCore.Restore(bytes.NewReader(backup.Bytes()), key, signature[:])
+	require.NoError(err)
+	assert.Equal(jsonManifest, newCore.db.GetManifest())
+	assert.Equal(statements, newCore.db.(*db.DatabaseMock).Imported)
+}
+
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	require := require.New(t)
+
+	backupKeyPEM, backupKey, err := createMockRecoveryKey()
+	require.NoError(err)
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+
+	jsonManifest := createBackupManifest(backupKeyPEM, adminCertPEM)
+	signature := sha256.Sum256(jsonManifest)
+	backup, key := createMockBackup(t, jsonManifest, adminCert, backupKey)
+	line, _, _ := bytes.Cut(backup, []byte("\n"))
+
+	// replacing the manifest, including its signature, must not be possible without the data key
+	otherManifest := append(jsonManifest[:len(jsonManifest)-1:len(jsonManifest)-1], []byte(`,"sql":["DROP DATABASE d"]}`)...)
+	otherSignature := sha256.Sum256(otherManifest)
+	var header backupHeader
+	require.NoError(json.Unmarshal(line, &header))
+	header.Manifest = otherManifest
+	header.Signature = otherSignature[:]
+	tamperedHeader, err := json.Marshal(header)
+	require.NoError(err)
+	tampered := append(append(tamperedHeader, '\n'), backup[len(line)+1:]...)
+
+	// anyone who knows the public backup key can create a backup with another manifest
+	forged, forgedKey := createMockBackup(t, otherManifest, adminCert, backupKey)
+
+	wrongKey := make([]byte, len(key))
+
+	testCases := map[string]struct {
+		backup    []byte
+		key       []byte
+		signature []byte
+	}{
+		"wrong key":            {backup: backup, key: wrongKey, signature: signature[:]},
+		"tampered header":      {backup: tampered, key: key, signature: otherSignature[:]},
+		"forged backup":        {backup: forged, key: forgedKey, signature: signature[:]},
+		"no signature":         {backup: backup, key: key},
+		"truncated":            {backup: backup[:len(backup)-10], key: key, signature: signature[:]},
+		"missing header":       {backup: backup[len(line)+1:], key: key, signature: signature[:]},
+		"short data key":       {backup: backup, key: key[:16], signature: signature[:]},
+		"appended garbage":     {backup: append(append([]byte{}, backup...), 0, 0, 0, 1, 0), key: key, signature: signature[:]},
+		"corrupted last chunk": {backup: append(append([]byte{}, backup[:len(backup)-1]...), backup[len(backup)-1]^1), key: key, signature: signature[:]},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			core, _ := newCoreWithMocks()
+			assert.NoError(t, core.StartDatabase())
+			_, err := core.Restore(bytes.NewReader(tc.backup), tc.key, tc.signature)
+			assert.Error(t, err)
+			// a corrupted end of the backup is detected while importing, which leaves the database uninitialized
+			assert.Nil(t, core.db.GetManifest())
+			assert.Empty(t, core.db.(*db.DatabaseMock).Imported)
+		})
+	}
+}
+
+// createMockBackup creates a backup of a database initialized with jsonManifest and returns it with its decrypted data key.
+func createMockBackup(t *testing.T, jsonManifest []byte, adminCert *x509.Certificate, backupKey *rsa.PrivateKey) ([]byte, []byte) {
+	require := require.New(t)
+	core, _ := newCoreWithMocks()
+	require.NoError(core.StartDatabase())
+	_, err := core.Initialize(jsonManifest)
+	require.NoError(err)
+	core.db.(*db.DatabaseMock).DumpStatements = []string{"CREATE TABLE t (i INT)", strings.Repeat("x", 2*backupChunkSize)}
+
+	var buf bytes.Buffer
+	require.NoError(core.Backup(&buf, adminCert))
+	line, _, _ := bytes.Cut(buf.Bytes(), []byte("\n"))
+	var header backupHeader
+	require.NoError(json.Unmarshal(line, &header))
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, backupKey, header.Key, nil)
+	require.NoError(err)
+	return buf.Bytes(), key
+}
+
+func TestBackupStream(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key := make([]byte, backupKeySize)
+	ad := []byte("header")
+
+	for _, size := range []int{0, 1, backupChunkSize, backupChunkSize + 1, 2 * backupChunkSize} {
+		data := bytes.Repeat([]byte{2}, size)
+		var buf bytes.Buffer
+		writer, err := newBackupWriter(&buf, key, ad)
+		require.NoError(err)
+		_, err = writer.Write(data)
+		require.NoError(err)
+		require.NoError(writer.Close())
+
+		reader, err := newBackupReader(&buf, key, ad)
+		require.NoError(err)
+		var result bytes.Buffer
+		_, err = result.ReadFrom(reader)
+		require.NoError(err)
+		assert.Equal(data, result.Bytes())
+	}
+}
+
+func TestManifestAdmin(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	otherCert, _ := createMockClientCertificate(t)
+
+	man := manifest{Admins: map[string]adminEntry{"alice": {Certificate: adminCertPEM}}}
+	require.NoError(man.validate())
+	name, err := man.admin(adminCert, operationBackup)
+	assert.NoError(err)
+	assert.Equal("alice", name)
+	_, err = man.admin(otherCert, operationBackup)
+	assert.ErrorIs(err, ErrNotAdmin)
+
+	assert.Error(manifest{Admins: map[string]adminEntry{"bob": {Certificate: "invalid"}}}.validate())
+	assert.Error(manifest{Backup: "invalid"}.validate())
+}
+
+func createBackupManifest(backupKeyPEM, adminCertPEM string) []byte {
+	jsonManifest, err := json.Marshal(map[string]interface{}{
+		"backup": backupKeyPEM,
+		"admins": map[string]string{"alice": adminCertPEM},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return jsonManifest
+}
+
+func createMockClientCertificate(t *testing.T) (*x509.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: serial}, &x509.Certificate{SerialNumber: serial}, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+}
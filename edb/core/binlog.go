@@ -0,0 +1,501 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	binlogVersion          = 1
+	binlogTimelineFilename = "timeline.json"
+	// binlogCompleteFilename marks a timeline whose binary logs have all been shipped after the database has been stopped.
+	binlogCompleteFilename = "complete.json"
+)
+
+// binlogHeader is the first line of a shipped binary log. Each chunk of the encrypted binary log authenticates it.
+type binlogHeader struct {
+	Version  int
+	Timeline string
+	Name     string
+}
+
+// binlogTimeline describes the binary logs written by one run of the database. It's stored in the directory of the timeline.
+type binlogTimeline struct {
+	Timeline string
+	// Previous is the timeline that this one seamlessly continues. It's empty if the database has been initialized, restored, or has crashed.
+	Previous string
+	Created  time.Time
+	// MAC authenticates the timeline with the binlog key.
+	MAC []byte
+}
+
+// binlogComplete is stored in the directory of a timeline after all of its binary logs have been shipped.
+type binlogComplete struct {
+	Timeline string
+	// MAC authenticates the marker with the binlog key.
+	MAC []byte
+}
+
+// BinlogStatus describes the shipping of binary logs.
+type BinlogStatus struct {
+	Unshipped int64  // size of the closed binary logs that haven't been shipped yet
+	LastError string `json:",omitempty"` // error of the last shipping, if it failed
+	// Dropped is set if binary logs have been dropped because they exceeded the limit of unshipped binary logs.
+	// Point-in-time recovery isn't possible past the dropped binary logs of the current timeline.
+	Dropped bool
+}
+
+// startBinlogShipping ships the binary logs periodically until the database is stopped.
+// If no binlog dir is configured, the binary logs that replicas don't read anymore are only purged.
+func (c *Core) startBinlogShipping() {
+	if (c.cfg.BinlogDir == "" && !c.cfg.Replication) || c.binlogStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	c.binlogStop, c.binlogDone = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(c.cfg.GetBinlogInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := c.shipBinlogs(); err != nil {
+					c.log.Error("shipping binary logs failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBinlogShipping stops the periodic shipping. It returns false if shipping hasn't been started.
+func (c *Core) stopBinlogShipping() bool {
+	if c.binlogStop == nil {
+		return false
+	}
+	close(c.binlogStop)
+	<-c.binlogDone
+	c.binlogStop, c.binlogDone = nil, nil
+	return true
+}
+
+// shipBinlogs closes the current binary log and ships the closed ones to the binlog directory.
+func (c *Core) shipBinlogs() error {
+	c.binlogMutex.Lock()
+	defer c.binlogMutex.Unlock()
+	closed, current, err := c.db.RotateBinlog()
+	if err != nil {
+		return err
+	}
+	if err := c.shipBinlogFiles(closed); err != nil {
+		return c.limitUnshippedBinlogs(closed, current, err)
+	}
+	c.setBinlogStatus(0, nil, false)
+	return c.db.PurgeBinlogs(current)
+}
+
+// limitUnshippedBinlogs keeps the binary logs that couldn't be shipped in enclave memory for the next attempt.
+// If they exceed the configured limit, it purges them so that they don't exhaust enclave memory.
+func (c *Core) limitUnshippedBinlogs(closed []string, current string, shipErr error) error {
+	var size int64
+	for _, path := range closed {
+		info, err := c.fs.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%w; getting the size of %v: %v", shipErr, filepath.Base(path), err)
+		}
+		size += info.Size()
+	}
+	if size <= c.cfg.GetBinlogMaxUnshipped() {
+		c.setBinlogStatus(size, shipErr, false)
+		return shipErr
+	}
+	c.log.Error("dropping binary logs that couldn't be shipped because they exceed the limit; create a new snapshot for point-in-time recovery", "size", size)
+	if err := c.db.PurgeBinlogs(current); err != nil {
+		c.setBinlogStatus(size, shipErr, false)
+		return fmt.Errorf("%w; purging the binary logs failed: %v", shipErr, err)
+	}
+	c.setBinlogStatus(0, shipErr, true)
+	return fmt.Errorf("%w; dropped %v bytes of binary logs", shipErr, size)
+}
+
+func (c *Core) setBinlogStatus(unshipped int64, err error, dropped bool) {
+	c.binlogStatusMutex.Lock()
+	defer c.binlogStatusMutex.Unlock()
+	c.binlogStatus.Unshipped = unshipped
+	c.binlogStatus.LastError = ""
+	if err != nil {
+		c.binlogStatus.LastError = err.Error()
+	}
+	// The binary logs of the timeline stay incomplete.
+	c.binlogStatus.Dropped = c.binlogStatus.Dropped || dropped
+}
+
+// getBinlogStatus returns nil if binary logs aren't shipped.
+func (c *Core) getBinlogStatus() *BinlogStatus {
+	if c.cfg.BinlogDir == "" {
+		return nil
+	}
+	c.binlogStatusMutex.Lock()
+	defer c.binlogStatusMutex.Unlock()
+	status := c.binlogStatus
+	return &status
+}
+
+// shipRemainingBinlogs ships all binary logs after the database has been stopped.
+// Only then the timeline is marked as complete, so that the timeline of the next run can continue it.
+func (c *Core) shipRemainingBinlogs() error {
+	c.binlogMutex.Lock()
+	defer c.binlogMutex.Unlock()
+	files, err := c.db.BinlogFiles()
+	if err != nil {
+		return err
+	}
+	if err := c.shipBinlogFiles(files); err != nil {
+		return err
+	}
+	if c.cfg.BinlogDir == "" || c.getBinlogStatus().Dropped {
+		return nil
+	}
+	timeline, _ := c.db.GetBinlogTimeline()
+	marker := binlogComplete{Timeline: timeline}
+	if marker.MAC, err = macJSON(c.binlogKey(), marker); err != nil {
+		return err
+	}
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	return c.fs.WriteFile(filepath.Join(c.cfg.BinlogDir, timeline, binlogCompleteFilename), data, 0o600)
+}
+
+// isBinlogTimelineComplete reports whether all binary logs of the timeline have been shipped.
+func (c *Core) isBinlogTimelineComplete(timeline string) bool {
+	data, err := c.fs.ReadFile(filepath.Join(c.cfg.BinlogDir, timeline, binlogCompleteFilename))
+	if err != nil {
+		return false
+	}
+	var marker binlogComplete
+	if json.Unmarshal(data, &marker) != nil || marker.Timeline != timeline {
+		return false
+	}
+	mac := marker.MAC
+	marker.MAC = nil
+	return validMACJSON(c.binlogKey(), marker, mac)
+}
+
+func (c *Core) shipBinlogFiles(paths []string) error {
+	if c.cfg.BinlogDir == "" {
+		return nil
+	}
+	timeline, previous := c.db.GetBinlogTimeline()
+	dir := filepath.Join(c.cfg.BinlogDir, timeline)
+	if err := c.writeBinlogTimeline(dir, timeline, previous); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := c.shipBinlogFile(path, dir, timeline); err != nil {
+			return fmt.Errorf("shipping %v: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+func (c *Core) shipBinlogFile(path, dir, timeline string) error {
+	name := filepath.Base(path)
+	dst := filepath.Join(dir, name)
+	// MariaDB keeps binary logs that are still needed for its crash recovery, so they may be returned again.
+	if exists, err := c.fs.Exists(dst); err != nil || exists {
+		return err
+	}
+
+	in, err := c.fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	header, err := json.Marshal(binlogHeader{Version: binlogVersion, Timeline: timeline, Name: name})
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	out, err := c.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer c.fs.Remove(tmp)
+	if err := c.writeEncryptedBinlog(out, in, header); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return c.fs.Rename(tmp, dst)
+}
+
+func (c *Core) writeEncryptedBinlog(w io.Writer, r io.Reader, header []byte) error {
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return err
+	}
+	writer, err := newBackupWriter(w, c.binlogKey(), header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// writeBinlogTimeline writes the timeline file once. The timeline only continues the previous one if that one is complete.
+func (c *Core) writeBinlogTimeline(dir, name, previous string) error {
+	path := filepath.Join(dir, binlogTimelineFilename)
+	if exists, err := c.fs.Exists(path); err != nil || exists {
+		return err
+	}
+	if previous != "" && !c.isBinlogTimelineComplete(previous) {
+		c.log.Warn("the binary logs of the previous run haven't all been shipped; create a new snapshot for point-in-time recovery", "previous", previous)
+		previous = ""
+	}
+	timeline := binlogTimeline{Timeline: name, Previous: previous, Created: time.Now().UTC()}
+	var err error
+	if timeline.MAC, err = macJSON(c.binlogKey(), timeline); err != nil {
+		return err
+	}
+	data, err := json.Marshal(timeline)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return c.fs.WriteFile(path, data, 0o600)
+}
+
+// ReplayBinlogs replays the shipped binary logs that follow the snapshot up to the transactions that started at until.
+// The database must have been restored from the snapshot. cert must be the client certificate of an admin.
+// It returns the start time of the last replayed transaction.
+func (c *Core) ReplayBinlogs(cert *x509.Certificate, snapshot string, until time.Time) (_ time.Time, err error) {
+	var admin string
+	defer func() { c.audit(operationPITR, cert, admin, err) }()
+	if c.cfg.BinlogDir == "" || c.cfg.SnapshotDir == "" {
+		return time.Time{}, errors.New("point-in-time recovery requires a snapshot and a binlog directory")
+	}
+	_, admin, err = c.authorizeAdmin(cert, operationPITR)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !isPlainFilename(snapshot) {
+		return time.Time{}, fmt.Errorf("invalid snapshot name: %q", snapshot)
+	}
+	metadata, err := c.readSnapshotMetadata(filepath.Join(c.cfg.SnapshotDir, snapshot, snapshotMetadataFilename))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The snapshot must belong to this database, so the binary logs can be decrypted.
+	if err := metadata.verify(c.masterKey); err != nil {
+		return time.Time{}, err
+	}
+	if metadata.Binlog == nil {
+		return time.Time{}, fmt.Errorf("snapshot %v has been created without binary log", snapshot)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.log.Info("replaying binary logs", "admin", admin, "snapshot", snapshot, "until", until.UTC().Format(time.RFC3339))
+	var last time.Time
+	timeline, first := metadata.Binlog.Timeline, metadata.Binlog.File
+	for timeline != "" {
+		names, err := c.listBinlogs(timeline, first)
+		if err != nil {
+			return last, err
+		}
+		for _, name := range names {
+			replayed, complete, err := c.replayBinlog(timeline, name, until)
+			if replayed.After(last) {
+				last = replayed
+			}
+			if err != nil {
+				return last, fmt.Errorf("replaying %v/%v: %w", timeline, name, err)
+			}
+			if !complete {
+				c.log.Info("point-in-time recovery completed")
+				return last, nil
+			}
+		}
+		if timeline, err = c.nextBinlogTimeline(timeline); err != nil {
+			return last, err
+		}
+		first = ""
+	}
+	c.log.Info("point-in-time recovery completed, all binary logs have been replayed")
+	return last, nil
+}
+
+// listBinlogs returns the names of the shipped binary logs of the timeline, starting with first if it's set.
+// It fails if a binary log is missing.
+func (c *Core) listBinlogs(timeline, first string) ([]string, error) {
+	entries, err := c.fs.ReadDir(filepath.Join(c.cfg.BinlogDir, timeline))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	firstNumber := 0
+	if first != "" {
+		if firstNumber, err = binlogNumber(first); err != nil {
+			return nil, err
+		}
+	}
+
+	numbers := map[int]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == binlogTimelineFilename || name == binlogCompleteFilename || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		number, err := binlogNumber(name)
+		if err != nil {
+			return nil, err
+		}
+		if number >= firstNumber {
+			numbers[number] = name
+		}
+	}
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]int, 0, len(numbers))
+	for number := range numbers {
+		sorted = append(sorted, number)
+	}
+	sort.Ints(sorted)
+	if first != "" && sorted[0] != firstNumber {
+		return nil, fmt.Errorf("binary log %v of timeline %v is missing", first, timeline)
+	}
+	names := make([]string, 0, len(sorted))
+	for i, number := range sorted {
+		if i > 0 && number != sorted[i-1]+1 {
+			return nil, fmt.Errorf("binary log %v of timeline %v is missing", sorted[i-1]+1, timeline)
+		}
+		names = append(names, numbers[number])
+	}
+	return names, nil
+}
+
+// nextBinlogTimeline returns the timeline that seamlessly continues the given one, or "" if there is none.
+func (c *Core) nextBinlogTimeline(previous string) (string, error) {
+	entries, err := c.fs.ReadDir(c.cfg.BinlogDir)
+	if err != nil {
+		return "", err
+	}
+	key := c.binlogKey()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := c.fs.ReadFile(filepath.Join(c.cfg.BinlogDir, entry.Name(), binlogTimelineFilename))
+		if err != nil {
+			continue
+		}
+		var timeline binlogTimeline
+		if json.Unmarshal(data, &timeline) != nil || timeline.Previous != previous || timeline.Timeline != entry.Name() {
+			continue
+		}
+		if err := timeline.verify(key); err != nil {
+			return "", fmt.Errorf("timeline %v: %w", entry.Name(), err)
+		}
+		return timeline.Timeline, nil
+	}
+	return "", nil
+}
+
+func (c *Core) replayBinlog(timeline, name string, until time.Time) (time.Time, bool, error) {
+	file, err := c.fs.Open(filepath.Join(c.cfg.BinlogDir, timeline, name))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	header, err := br.ReadBytes('\n')
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("reading header: %w", err)
+	}
+	header = header[:len(header)-1]
+	var parsedHeader binlogHeader
+	if err := json.Unmarshal(header, &parsedHeader); err != nil {
+		return time.Time{}, false, fmt.Errorf("parsing header: %w", err)
+	}
+	if parsedHeader.Version != binlogVersion || parsedHeader.Timeline != timeline || parsedHeader.Name != name {
+		return time.Time{}, false, errors.New("header doesn't match the file")
+	}
+	reader, err := newBackupReader(br, c.binlogKey(), header)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return c.db.ReplayBinlog(reader, until)
+}
+
+// binlogKey derives the key that encrypts the shipped binary logs from the master key.
+func (c *Core) binlogKey() []byte {
+	mac := hmac.New(sha256.New, c.masterKey)
+	mac.Write([]byte("edb binlog"))
+	return mac.Sum(nil)
+}
+
+// binlogNumber returns the sequence number of a binary log name like binlog.000001.
+func binlogNumber(name string) (int, error) {
+	number, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(name), "."))
+	if err != nil {
+		return 0, fmt.Errorf("invalid binary log name: %v", name)
+	}
+	return number, nil
+}
+
+func (t binlogTimeline) verify(key []byte) error {
+	mac := t.MAC
+	t.MAC = nil
+	if !validMACJSON(key, t, mac) {
+		return errors.New("timeline has not been created with this key")
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinlogShippingAndReplay(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+
+	recoveryKeyPEM, _, err := createMockRecoveryKey()
+	require.NoError(err)
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	jsonManifest, err := json.Marshal(map[string]interface{}{"recovery": recoveryKeyPEM, "admins": map[string]string{"alice": adminCertPEM}})
+	require.NoError(err)
+	snapshotDir := t.TempDir()
+	binlogDir := t.TempDir()
+
+	core, mockDB := newCoreWithOsFs(Config{DataPath: t.TempDir(), SnapshotDir: snapshotDir, BinlogDir: binlogDir})
+	require.NoError(core.StartDatabase())
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(err)
+
+	// writeBinlogs creates binary logs like MariaDB does in its data directory
+	writeBinlogs := func(contents ...string) []string {
+		dir := t.TempDir()
+		var paths []string
+		for i, content := range contents {
+			path := filepath.Join(dir, fmt.Sprintf("binlog.%06d", i+1))
+			require.NoError(os.WriteFile(path, []byte(content), 0o600))
+			paths = append(paths, path)
+		}
+		return paths
+	}
+	binlogPath := func(timeline, name string) string {
+		return filepath.Join(binlogDir, timeline, name)
+	}
+
+	mockDB.BinlogTimeline = "0000000000000001"
+	mockDB.Binlogs = writeBinlogs("first")
+	mockDB.CurrentBinlog = "binlog.000002"
+	require.NoError(core.shipBinlogs())
+	assert.Equal(mockDB.CurrentBinlog, mockDB.PurgedTo)
+
+	// the shipped binary logs are encrypted
+	data, err := os.ReadFile(binlogPath(mockDB.BinlogTimeline, "binlog.000001"))
+	require.NoError(err)
+	assert.NotContains(string(data), "first")
+
+	// the timeline is authenticated
+	data, err = os.ReadFile(filepath.Join(binlogDir, mockDB.BinlogTimeline, binlogTimelineFilename))
+	require.NoError(err)
+	var timeline binlogTimeline
+	require.NoError(json.Unmarshal(data, &timeline))
+	assert.NoError(timeline.verify(core.binlogKey()))
+	assert.Error(timeline.verify(make([]byte, 32)))
+
+	mockDB.CheckpointFiles = map[string][]byte{"CURRENT": []byte("MANIFEST-1"), "MANIFEST-1": {1}}
+	snapshot, err := core.Snapshot(adminCert)
+	require.NoError(err)
+
+	// the binary logs that follow the snapshot
+	mockDB.Binlogs = writeBinlogs("first", "second", "third")
+	require.NoError(core.shipBinlogs())
+
+	// the first run is stopped and all of its binary logs are shipped
+	require.NoError(core.shipRemainingBinlogs())
+	assert.FileExists(binlogPath(mockDB.BinlogTimeline, binlogCompleteFilename))
+
+	// the next timeline continues the first one after a clean restart
+	mockDB.BinlogPrevious = mockDB.BinlogTimeline
+	mockDB.BinlogTimeline = "0000000000000002"
+	mockDB.Binlogs = writeBinlogs("fourth")
+	require.NoError(core.shipBinlogs())
+
+	// the second run crashes before its remaining binary logs are shipped, so the next timeline doesn't continue it
+	mockDB.BinlogPrevious = mockDB.BinlogTimeline
+	mockDB.BinlogTimeline = "0000000000000003"
+	mockDB.Binlogs = writeBinlogs("fifth")
+	require.NoError(core.shipRemainingBinlogs())
+	data, err = os.ReadFile(binlogPath(mockDB.BinlogTimeline, binlogTimelineFilename))
+	require.NoError(err)
+	require.NoError(json.Unmarshal(data, &timeline))
+	assert.Empty(timeline.Previous)
+
+	// the host can't mark a timeline as complete
+	assert.False(core.isBinlogTimelineComplete("0000000000000002"))
+	data, err = os.ReadFile(binlogPath("0000000000000001", binlogCompleteFilename))
+	require.NoError(err)
+	require.NoError(os.WriteFile(binlogPath("0000000000000002", binlogCompleteFilename), data, 0o600))
+	assert.False(core.isBinlogTimelineComplete("0000000000000002"))
+	assert.True(core.isBinlogTimelineComplete("0000000000000001"))
+
+	until := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = core.ReplayBinlogs(nil, snapshot, until)
+	assert.ErrorIs(err, ErrNotAdmin)
+	_, err = core.ReplayBinlogs(adminCert, "../"+snapshot, until)
+	assert.Error(err)
+
+	last, err := core.ReplayBinlogs(adminCert, snapshot, until)
+	require.NoError(err)
+	assert.Equal(until, last)
+	require.Len(mockDB.Replayed, 3)
+	assert.Equal("second", string(mockDB.Replayed[0]))
+	assert.Equal("third", string(mockDB.Replayed[1]))
+	assert.Equal("fourth", string(mockDB.Replayed[2]))
+
+	// replay fails if a binary log is missing
+	require.NoError(os.Remove(binlogPath("0000000000000001", "binlog.000002")))
+	mockDB.Replayed = nil
+	_, err = core.ReplayBinlogs(adminCert, snapshot, until)
+	assert.Error(err)
+	assert.Empty(mockDB.Replayed)
+
+	// replay fails if a binary log has been swapped
+	data, err = os.ReadFile(binlogPath("0000000000000001", "binlog.000003"))
+	require.NoError(err)
+	require.NoError(os.WriteFile(binlogPath("0000000000000001", "binlog.000002"), data, 0o600))
+	_, err = core.ReplayBinlogs(adminCert, snapshot, until)
+	assert.Error(err)
+	assert.Empty(mockDB.Replayed)
+}
+
+func TestListBinlogs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	binlogDir := t.TempDir()
+	core, _ := newCoreWithOsFs(Config{DataPath: t.TempDir(), BinlogDir: binlogDir})
+	dir := filepath.Join(binlogDir, "timeline")
+	require.NoError(os.Mkdir(dir, 0o700))
+	for _, name := range []string{binlogTimelineFilename, "binlog.000003", "binlog.000004", "binlog.000005", "binlog.000006.tmp"} {
+		require.NoError(os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+
+	names, err := core.listBinlogs("timeline", "")
+	require.NoError(err)
+	assert.Equal([]string{"binlog.000003", "binlog.000004", "binlog.000005"}, names)
+
+	names, err = core.listBinlogs("timeline", "binlog.000004")
+	require.NoError(err)
+	assert.Equal([]string{"binlog.000004", "binlog.000005"}, names)
+
+	_, err = core.listBinlogs("timeline", "binlog.000002")
+	assert.Error(err)
+
+	require.NoError(os.Remove(filepath.Join(dir, "binlog.000004")))
+	_, err = core.listBinlogs("timeline", "")
+	assert.Error(err)
+
+	names, err = core.listBinlogs("other", "")
+	require.NoError(err)
+	assert.Empty(names)
+}
+
+func TestBinlogUnshippedLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+
+	// the binlog dir can't be created because a file is in the way
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	require.NoError(os.WriteFile(blocked, nil, 0o600))
+	binlogDir := filepath.Join(blocked, "binlogs")
+	core, mockDB := newCoreWithOsFs(Config{DataPath: t.TempDir(), BinlogDir: binlogDir, BinlogMaxUnshipped: 1})
+	require.NoError(core.StartDatabase())
+	_, err := core.Initialize([]byte("{}"))
+	require.NoError(err)
+	assert.Equal(&BinlogStatus{}, core.GetStatus().Binlog)
+
+	dir := t.TempDir()
+	writeBinlog := func(name string, size int) string {
+		path := filepath.Join(dir, name)
+		require.NoError(os.WriteFile(path, make([]byte, size), 0o600))
+		return path
+	}
+	mockDB.BinlogTimeline = "0000000000000001"
+	mockDB.CurrentBinlog = "binlog.000003"
+
+	// binary logs below the limit are kept for the next attempt
+	mockDB.Binlogs = []string{writeBinlog("binlog.000001", 1000)}
+	assert.Error(core.shipBinlogs())
+	assert.Empty(mockDB.PurgedTo)
+	status := core.GetStatus().Binlog
+	assert.EqualValues(1000, status.Unshipped)
+	assert.NotEmpty(status.LastError)
+	assert.False(status.Dropped)
+
+	// binary logs above the limit are dropped
+	mockDB.Binlogs = append(mockDB.Binlogs, writeBinlog("binlog.000002", 1<<20))
+	assert.Error(core.shipBinlogs())
+	assert.Equal("binlog.000003", mockDB.PurgedTo)
+	status = core.GetStatus().Binlog
+	assert.Zero(status.Unshipped)
+	assert.True(status.Dropped)
+
+	// shipping recovers, but the timeline isn't complete
+	require.NoError(os.Remove(blocked))
+	mockDB.Binlogs = []string{writeBinlog("binlog.000003", 10)}
+	require.NoError(core.shipBinlogs())
+	require.NoError(core.shipRemainingBinlogs())
+	status = core.GetStatus().Binlog
+	assert.Empty(status.LastError)
+	assert.True(status.Dropped)
+	assert.NoFileExists(filepath.Join(binlogDir, mockDB.BinlogTimeline, binlogCompleteFilename))
+}
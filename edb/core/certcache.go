@@ -0,0 +1,117 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	// ephemeralCertificateValidity is the validity of the certificates generated for TLS connections.
+	ephemeralCertificateValidity = time.Hour
+	// ephemeralCertificateRenewal is the remaining validity below which a cached certificate won't be used anymore.
+	ephemeralCertificateRenewal = 10 * time.Minute
+	// certificateCacheSize is the maximum number of certificates in the cache.
+	certificateCacheSize = 1024
+)
+
+// CertificateCacheStats holds statistics about the certificate cache.
+type CertificateCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+// certificateCache caches the certificates generated for TLS connections, so they don't need to be generated on every handshake.
+type certificateCache struct {
+	mutex   sync.Mutex
+	entries map[certificateCacheKey]certificateCacheEntry
+	maxSize int
+	hits    uint64
+	misses  uint64
+}
+
+type certificateCacheKey struct {
+	hostname string
+	ip       string
+	signer   [sha256.Size]byte // cached certificates become invalid if the signer changes
+}
+
+type certificateCacheEntry struct {
+	cert     []byte
+	key      crypto.PrivateKey
+	notAfter time.Time
+}
+
+func newCertificateCache(maxSize int) *certificateCache {
+	return &certificateCache{entries: make(map[certificateCacheKey]certificateCacheEntry), maxSize: maxSize}
+}
+
+func newCertificateCacheKey(hostname, ip string, signerCert []byte) certificateCacheKey {
+	return certificateCacheKey{hostname: hostname, ip: ip, signer: sha256.Sum256(signerCert)}
+}
+
+// get returns a cached certificate if it's still valid for a reasonable time.
+func (c *certificateCache) get(key certificateCacheKey, now time.Time) ([]byte, crypto.PrivateKey, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.notAfter.Add(-ephemeralCertificateRenewal)) {
+		c.misses++
+		return nil, nil, false
+	}
+	c.hits++
+	return entry.cert, entry.key, true
+}
+
+func (c *certificateCache) put(key certificateCacheKey, cert []byte, privKey crypto.PrivateKey, notAfter time.Time, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxSize {
+		c.evict(now)
+	}
+	c.entries[key] = certificateCacheEntry{cert: cert, key: privKey, notAfter: notAfter}
+}
+
+// evict removes all entries that can't be used anymore or, if there are none, the entry that expires first.
+func (c *certificateCache) evict(now time.Time) {
+	var oldestKey certificateCacheKey
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if !now.Before(entry.notAfter.Add(-ephemeralCertificateRenewal)) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest.IsZero() || entry.notAfter.Before(oldest) {
+			oldestKey = key
+			oldest = entry.notAfter
+		}
+	}
+	if len(c.entries) >= c.maxSize {
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (c *certificateCache) stats() CertificateCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CertificateCacheStats{Hits: c.hits, Misses: c.misses, Size: len(c.entries)}
+}
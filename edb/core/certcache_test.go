@@ -0,0 +1,63 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateCache(t *testing.T) {
+	assert := assert.New(t)
+
+	cache := newCertificateCache(2)
+	now := time.Now()
+	keyA := newCertificateCacheKey("a", "1.2.3.4", []byte{1})
+	keyB := newCertificateCacheKey("b", "1.2.3.4", []byte{1})
+	keyC := newCertificateCacheKey("c", "1.2.3.4", []byte{1})
+
+	_, _, ok := cache.get(keyA, now)
+	assert.False(ok)
+
+	cache.put(keyA, []byte{2}, nil, now.Add(ephemeralCertificateValidity), now)
+	cert, _, ok := cache.get(keyA, now)
+	assert.True(ok)
+	assert.Equal([]byte{2}, cert)
+
+	// different IP or signer
+	_, _, ok = cache.get(newCertificateCacheKey("a", "1.2.3.5", []byte{1}), now)
+	assert.False(ok)
+	_, _, ok = cache.get(newCertificateCacheKey("a", "1.2.3.4", []byte{2}), now)
+	assert.False(ok)
+
+	// close to expiry
+	_, _, ok = cache.get(keyA, now.Add(ephemeralCertificateValidity-ephemeralCertificateRenewal))
+	assert.False(ok)
+
+	// evicts the entry that expires first
+	cache.put(keyB, []byte{3}, nil, now.Add(ephemeralCertificateValidity+time.Minute), now)
+	cache.put(keyC, []byte{4}, nil, now.Add(ephemeralCertificateValidity+2*time.Minute), now)
+	_, _, ok = cache.get(keyA, now)
+	assert.False(ok)
+	_, _, ok = cache.get(keyB, now)
+	assert.True(ok)
+	_, _, ok = cache.get(keyC, now)
+	assert.True(ok)
+
+	assert.Equal(CertificateCacheStats{Hits: 3, Misses: 5, Size: 2}, cache.stats())
+}
@@ -16,32 +16,90 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/edgelesssys/edgelessdb/edb/rt"
+	"github.com/edgelesssys/edgelessdb/edb/util"
+	"sigs.k8s.io/yaml"
 )
 
 // Config is an EDB config.
 type Config struct {
-	DataPath           string `json:",omitempty"`
-	DatabaseAddress    string `json:",omitempty"`
-	APIAddress         string `json:",omitempty"`
-	CertificateDNSName string `json:",omitempty"`
-	Debug              bool   `json:",omitempty"`
-	LogDir             string `json:",omitempty"`
-	ManifestFilePath   string `json:",omitempty"`
+	DataPath               string   `json:",omitempty"`
+	DatabaseAddresses      []string `json:",omitempty"`
+	APIAddresses           []string `json:",omitempty"`
+	CertificateDNSName     string   `json:",omitempty"`
+	Debug                  bool     `json:",omitempty"`
+	LogDir                 string   `json:",omitempty"`
+	ManifestFilePath       string   `json:",omitempty"`
+	CertificateHostnames   []string `json:",omitempty"`
+	RejectUnknownHostnames bool     `json:",omitempty"`
+	TLSVersion             string   `json:",omitempty"`
+	TLSCipherSuites        []string `json:",omitempty"`
+	ReservedThreads        int      `json:",omitempty"`
+	GoMaxProcs             int      `json:",omitempty"`
+	ThreadPoolSize         int      `json:",omitempty"`
+	ThreadPoolMaxThreads   int      `json:",omitempty"`
+	ThreadPoolStallLimit   int      `json:",omitempty"`
+	ShutdownTimeout        string   `json:",omitempty"`
+	Supervise              bool     `json:",omitempty"`
+	SnapshotDir            string   `json:",omitempty"`
+	RestoreSnapshot        string   `json:",omitempty"`
+	BinlogDir              string   `json:",omitempty"`
+	BinlogInterval         string   `json:",omitempty"`
+	BinlogMaxUnshipped     int      `json:",omitempty"`
+	Replication            bool     `json:",omitempty"`
+	ReplicationPrimary     string   `json:",omitempty"`
+	RecoveryLockout        int      `json:",omitempty"`
+	LogFormat              string   `json:",omitempty"`
+	LogLevel               string   `json:",omitempty"`
 }
 
+// DefaultShutdownTimeout is the time edb waits for the API server and the database to shut down if not configured otherwise.
+// It's a bit less than the default termination grace period of Kubernetes.
+const DefaultShutdownTimeout = 25 * time.Second
+
+// DefaultBinlogInterval is the interval at which binary logs are shipped if not configured otherwise.
+const DefaultBinlogInterval = time.Minute
+
+// DefaultBinlogMaxUnshipped is the size in MiB up to which binary logs that couldn't be shipped are kept in enclave memory if not configured otherwise.
+const DefaultBinlogMaxUnshipped = 64
+
+// DefaultReservedThreads is the number of enclave threads (TCS) that aren't available to MariaDB's thread pool if not configured otherwise.
+// There are quite a few MariaDB and RocksDB helper threads in addition to pool threads. Let's be rather generous here.
+const DefaultReservedThreads = 32
+
+// EnvConfigFile is the name of the optional environment variable holding the path to a JSON or YAML config file.
+// Values from the environment override those from the file.
+const EnvConfigFile = "EDG_EDB_CONFIG_FILE"
+
 // EnvDataPath is the name of the optional environment variable holding the data path for edb
 const EnvDataPath = "EDG_EDB_DATA_PATH"
 
-// EnvDatabaseAddress is the name of the optional environment variable holding the database address
+// EnvDatabaseAddress is the name of the optional environment variable holding a comma-separated list of database addresses
 const EnvDatabaseAddress = "EDG_EDB_DATABASE_ADDR"
 
-// EnvAPIAddress is the name of the optional environment variable holding the API address
+// EnvAPIAddress is the name of the optional environment variable holding a comma-separated list of API addresses
 const EnvAPIAddress = "EDG_EDB_API_ADDR"
 
 // EnvCertificateDNSName is the name of the optional environment variable holding the DNS Name used for the certificate generated by edb
 const EnvCertificateDNSName = "EDG_EDB_CERT_DNS"
 
+// EnvCertificateHostnames is the name of the optional environment variable holding a comma-separated list of the hostnames for which edb issues certificates.
+// A pattern like *.example.com matches a single label. Defaults to the DNS name in EnvCertificateDNSName.
+const EnvCertificateHostnames = "EDG_EDB_CERT_HOSTNAMES"
+
+// EnvRejectUnknownHostnames is a flag to reject TLS connections for hostnames that are not in EnvCertificateHostnames instead of using the DNS name in EnvCertificateDNSName
+const EnvRejectUnknownHostnames = "EDG_EDB_CERT_REJECT_UNKNOWN"
+
 // EnvDebug is a flag to enable debug logging for edb
 const EnvDebug = "EDG_EDB_DEBUG"
 
@@ -51,32 +109,118 @@ const EnvLogDir = "EDG_EDB_LOG_DIR"
 // EnvManifestFile holds the path to the manifest file in case we want edb to automatically deploy one
 const EnvManifestFile = "EDG_EDB_MANIFEST_FILE"
 
+// EnvTLSVersion is the name of the optional environment variable holding the minimum TLS version ("1.2" or "1.3") accepted by the SQL and REST endpoints
+const EnvTLSVersion = "EDG_EDB_TLS_VERSION"
+
+// EnvTLSCipherSuites is the name of the optional environment variable holding a comma-separated list of the TLS 1.2 cipher suites accepted by the SQL and REST endpoints
+const EnvTLSCipherSuites = "EDG_EDB_TLS_CIPHERS"
+
+// EnvReservedThreads is the name of the optional environment variable holding the number of enclave threads that aren't available to MariaDB's thread pool
+const EnvReservedThreads = "EDG_EDB_RESERVED_THREADS"
+
+// EnvGoMaxProcs is the name of the optional environment variable holding the value for Go's GOMAXPROCS
+const EnvGoMaxProcs = "EDG_EDB_GOMAXPROCS"
+
+// EnvThreadPoolSize is the name of the optional environment variable holding MariaDB's thread_pool_size
+const EnvThreadPoolSize = "EDG_EDB_THREAD_POOL_SIZE"
+
+// EnvThreadPoolMaxThreads is the name of the optional environment variable holding MariaDB's thread_pool_max_threads
+const EnvThreadPoolMaxThreads = "EDG_EDB_THREAD_POOL_MAX_THREADS"
+
+// EnvThreadPoolStallLimit is the name of the optional environment variable holding MariaDB's thread_pool_stall_limit in milliseconds
+const EnvThreadPoolStallLimit = "EDG_EDB_THREAD_POOL_STALL_LIMIT"
+
+// EnvShutdownTimeout is the name of the optional environment variable holding the time edb waits for a clean shutdown, e.g., "25s"
+const EnvShutdownTimeout = "EDG_EDB_SHUTDOWN_TIMEOUT"
+
+// EnvSupervise is a flag to restart the host process with exponential backoff if the database crashes instead of exiting
+const EnvSupervise = "EDG_EDB_SUPERVISE"
+
+// EnvSnapshotDir is the name of the optional environment variable holding the directory where physical snapshots are stored
+const EnvSnapshotDir = "EDG_EDB_SNAPSHOT_DIR"
+
+// EnvRestoreSnapshot is the name of the optional environment variable holding the name of a snapshot in EnvSnapshotDir that is restored if the data directory is empty
+const EnvRestoreSnapshot = "EDG_EDB_RESTORE_SNAPSHOT"
+
+// EnvBinlogDir is the name of the optional environment variable holding the directory where encrypted binary logs are shipped to
+const EnvBinlogDir = "EDG_EDB_BINLOG_DIR"
+
+// EnvBinlogInterval is the name of the optional environment variable holding the interval at which binary logs are shipped, e.g., "1m"
+const EnvBinlogInterval = "EDG_EDB_BINLOG_INTERVAL"
+
+// EnvBinlogMaxUnshipped is the name of the optional environment variable holding the size in MiB up to which binary logs that couldn't be shipped are kept in enclave memory
+const EnvBinlogMaxUnshipped = "EDG_EDB_BINLOG_MAX_UNSHIPPED"
+
+// EnvReplication is a flag to let attested replicas join and follow the binary log
+const EnvReplication = "EDG_EDB_REPLICATION"
+
+// EnvReplicationPrimary is the name of the optional environment variable holding the API address of the primary that edb joins as a replica if the data directory is empty
+const EnvReplicationPrimary = "EDG_EDB_REPLICATION_PRIMARY"
+
+// EnvRecoveryLockout is the name of the optional environment variable holding the number of consecutive failed recovery attempts after which recovery is locked
+const EnvRecoveryLockout = "EDG_EDB_RECOVERY_LOCKOUT"
+
+// EnvLogFormat is the name of the optional environment variable holding the format of edb's log ("text" or "json")
+const EnvLogFormat = "EDG_EDB_LOG_FORMAT"
+
+// EnvLogLevel is the name of the optional environment variable holding the minimum level of edb's log entries ("debug", "info", "warn", or "error")
+const EnvLogLevel = "EDG_EDB_LOG_LEVEL"
+
 // FillConfigFromEnvironment takes an existing config filled with defaults and replaces single values based on environment variables.
 func FillConfigFromEnvironment(config Config) Config {
 	envDataPath := os.Getenv(EnvDataPath)
 	envDatabaseAddress := os.Getenv(EnvDatabaseAddress)
 	envAPIAddress := os.Getenv(EnvAPIAddress)
 	envCertificateDNSName := os.Getenv(EnvCertificateDNSName)
+	envCertificateHostnames := os.Getenv(EnvCertificateHostnames)
+	envRejectUnknownHostnames := os.Getenv(EnvRejectUnknownHostnames)
 	envDebug := os.Getenv(EnvDebug)
 	envLogDir := os.Getenv(EnvLogDir)
 	envManifestFilePath := os.Getenv(EnvManifestFile)
+	envTLSVersion := os.Getenv(EnvTLSVersion)
+	envTLSCipherSuites := os.Getenv(EnvTLSCipherSuites)
+	envReservedThreads := os.Getenv(EnvReservedThreads)
+	envGoMaxProcs := os.Getenv(EnvGoMaxProcs)
+	envThreadPoolSize := os.Getenv(EnvThreadPoolSize)
+	envThreadPoolMaxThreads := os.Getenv(EnvThreadPoolMaxThreads)
+	envThreadPoolStallLimit := os.Getenv(EnvThreadPoolStallLimit)
+	envShutdownTimeout := os.Getenv(EnvShutdownTimeout)
+	envSupervise := os.Getenv(EnvSupervise)
+	envSnapshotDir := os.Getenv(EnvSnapshotDir)
+	envRestoreSnapshot := os.Getenv(EnvRestoreSnapshot)
+	envBinlogDir := os.Getenv(EnvBinlogDir)
+	envBinlogInterval := os.Getenv(EnvBinlogInterval)
+	envBinlogMaxUnshipped := os.Getenv(EnvBinlogMaxUnshipped)
+	envReplication := os.Getenv(EnvReplication)
+	envReplicationPrimary := os.Getenv(EnvReplicationPrimary)
+	envRecoveryLockout := os.Getenv(EnvRecoveryLockout)
+	envLogFormat := os.Getenv(EnvLogFormat)
+	envLogLevel := os.Getenv(EnvLogLevel)
 
 	if envDataPath != "" {
 		config.DataPath = envDataPath
 	}
 
 	if envDatabaseAddress != "" {
-		config.DatabaseAddress = envDatabaseAddress
+		config.DatabaseAddresses = strings.Split(envDatabaseAddress, ",")
 	}
 
 	if envAPIAddress != "" {
-		config.APIAddress = envAPIAddress
+		config.APIAddresses = strings.Split(envAPIAddress, ",")
 	}
 
 	if envCertificateDNSName != "" {
 		config.CertificateDNSName = envCertificateDNSName
 	}
 
+	if envCertificateHostnames != "" {
+		config.CertificateHostnames = strings.Split(envCertificateHostnames, ",")
+	}
+
+	if envRejectUnknownHostnames != "" {
+		config.RejectUnknownHostnames = true
+	}
+
 	if envDebug != "" {
 		config.Debug = true
 	}
@@ -90,5 +234,309 @@ func FillConfigFromEnvironment(config Config) Config {
 		config.ManifestFilePath = envManifestFilePath
 	}
 
+	if envTLSVersion != "" {
+		config.TLSVersion = envTLSVersion
+	}
+
+	if envTLSCipherSuites != "" {
+		config.TLSCipherSuites = strings.Split(envTLSCipherSuites, ",")
+	}
+
+	// Invalid numbers become -1 so that Validate reports them.
+	if envReservedThreads != "" {
+		config.ReservedThreads = atoi(envReservedThreads)
+	}
+
+	if envGoMaxProcs != "" {
+		config.GoMaxProcs = atoi(envGoMaxProcs)
+	}
+
+	if envThreadPoolSize != "" {
+		config.ThreadPoolSize = atoi(envThreadPoolSize)
+	}
+
+	if envThreadPoolMaxThreads != "" {
+		config.ThreadPoolMaxThreads = atoi(envThreadPoolMaxThreads)
+	}
+
+	if envThreadPoolStallLimit != "" {
+		config.ThreadPoolStallLimit = atoi(envThreadPoolStallLimit)
+	}
+
+	if envShutdownTimeout != "" {
+		config.ShutdownTimeout = envShutdownTimeout
+	}
+
+	if envSupervise != "" {
+		config.Supervise = true
+	}
+
+	if envSnapshotDir != "" {
+		config.SnapshotDir = envSnapshotDir
+	}
+
+	if envRestoreSnapshot != "" {
+		config.RestoreSnapshot = envRestoreSnapshot
+	}
+
+	if envBinlogDir != "" {
+		config.BinlogDir = envBinlogDir
+	}
+
+	if envBinlogInterval != "" {
+		config.BinlogInterval = envBinlogInterval
+	}
+
+	if envBinlogMaxUnshipped != "" {
+		config.BinlogMaxUnshipped = atoi(envBinlogMaxUnshipped)
+	}
+
+	if envReplication != "" {
+		config.Replication = true
+	}
+
+	if envReplicationPrimary != "" {
+		config.ReplicationPrimary = envReplicationPrimary
+	}
+
+	if envRecoveryLockout != "" {
+		config.RecoveryLockout = atoi(envRecoveryLockout)
+	}
+
+	if envLogFormat != "" {
+		config.LogFormat = envLogFormat
+	}
+
+	if envLogLevel != "" {
+		config.LogLevel = envLogLevel
+	}
+
 	return config
 }
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// FillConfigFromFile takes an existing config filled with defaults and replaces the values set in the JSON or YAML config file.
+func FillConfigFromFile(config Config, data []byte) (Config, error) {
+	// Unmarshaling reuses the backing arrays of slices, so start from a deep copy to not modify the caller's config.
+	// Fields not present in the file keep their current values.
+	defaults, err := json.Marshal(config)
+	if err != nil {
+		return Config{}, err
+	}
+	var result Config
+	if err := json.Unmarshal(defaults, &result); err != nil {
+		return Config{}, err
+	}
+	if err := yaml.UnmarshalStrict(data, &result); err != nil {
+		return Config{}, fmt.Errorf("parsing config file: %w", err)
+	}
+	return result, nil
+}
+
+// Validate checks that the config is consistent.
+func (c Config) Validate() error {
+	if c.DataPath == "" {
+		return errors.New("DataPath must not be empty")
+	}
+	for _, address := range c.DatabaseAddresses {
+		if err := validateAddress(address, false); err != nil {
+			return fmt.Errorf("invalid database address: %w", err)
+		}
+	}
+	for _, address := range c.APIAddresses {
+		if err := validateAddress(address, true); err != nil {
+			return fmt.Errorf("invalid API address: %w", err)
+		}
+	}
+	for _, pattern := range c.CertificateHostnames {
+		if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return fmt.Errorf("invalid hostname pattern: %q", pattern)
+		}
+	}
+	if c.LogDir != "" && !c.Debug {
+		return errors.New("LogDir requires Debug")
+	}
+	if err := rt.ValidateFormat(c.LogFormat); err != nil {
+		return err
+	}
+	if _, err := rt.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if _, err := c.TLSPolicy(); err != nil {
+		return err
+	}
+	for name, value := range map[string]int{
+		"ReservedThreads":      c.ReservedThreads,
+		"GoMaxProcs":           c.GoMaxProcs,
+		"ThreadPoolSize":       c.ThreadPoolSize,
+		"ThreadPoolMaxThreads": c.ThreadPoolMaxThreads,
+		"ThreadPoolStallLimit": c.ThreadPoolStallLimit,
+		"RecoveryLockout":      c.RecoveryLockout,
+		"BinlogMaxUnshipped":   c.BinlogMaxUnshipped,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%v must not be negative", name)
+		}
+	}
+	if c.RestoreSnapshot != "" {
+		if c.SnapshotDir == "" {
+			return errors.New("RestoreSnapshot requires SnapshotDir")
+		}
+		if !isPlainFilename(c.RestoreSnapshot) {
+			return fmt.Errorf("invalid RestoreSnapshot: %q is not the name of a snapshot", c.RestoreSnapshot)
+		}
+	}
+	if c.ReplicationPrimary != "" {
+		if err := validateAddress(c.ReplicationPrimary, true); err != nil {
+			return fmt.Errorf("invalid ReplicationPrimary: %w", err)
+		}
+		if host, _, _ := net.SplitHostPort(c.ReplicationPrimary); host == "" {
+			return fmt.Errorf("invalid ReplicationPrimary: %q has no host", c.ReplicationPrimary)
+		}
+		// The replica only joins a primary that has been initialized with the same manifest.
+		if c.ManifestFilePath == "" {
+			return errors.New("ReplicationPrimary requires ManifestFilePath")
+		}
+		if c.RestoreSnapshot != "" {
+			return errors.New("ReplicationPrimary can't be combined with RestoreSnapshot")
+		}
+	}
+	if c.ShutdownTimeout != "" {
+		if timeout, err := time.ParseDuration(c.ShutdownTimeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid ShutdownTimeout: %q", c.ShutdownTimeout)
+		}
+	}
+	if c.BinlogInterval != "" {
+		if interval, err := time.ParseDuration(c.BinlogInterval); err != nil || interval <= 0 {
+			return fmt.Errorf("invalid BinlogInterval: %q", c.BinlogInterval)
+		}
+	}
+	return nil
+}
+
+// GetBinlogInterval returns the interval at which binary logs are shipped.
+func (c Config) GetBinlogInterval() time.Duration {
+	interval, err := time.ParseDuration(c.BinlogInterval)
+	if err != nil || interval <= 0 {
+		return DefaultBinlogInterval
+	}
+	return interval
+}
+
+// GetBinlogMaxUnshipped returns the size in bytes up to which binary logs that couldn't be shipped are kept in enclave memory.
+func (c Config) GetBinlogMaxUnshipped() int64 {
+	if c.BinlogMaxUnshipped <= 0 {
+		return DefaultBinlogMaxUnshipped << 20
+	}
+	return int64(c.BinlogMaxUnshipped) << 20
+}
+
+// GetShutdownTimeout returns the time edb waits for a clean shutdown.
+func (c Config) GetShutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil || timeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return timeout
+}
+
+// ThreadPool returns the thread pool config for an enclave with numTCS threads.
+func (c Config) ThreadPool(numTCS int) (db.ThreadPoolConfig, error) {
+	reserved := c.ReservedThreads
+	if reserved == 0 {
+		reserved = DefaultReservedThreads
+	}
+	available := numTCS - reserved
+	if available < 1 {
+		return db.ThreadPoolConfig{}, fmt.Errorf("%v reserved threads leave no threads for the thread pool, the enclave has %v threads (NumTCS)", reserved, numTCS)
+	}
+	if c.GoMaxProcs > reserved {
+		return db.ThreadPoolConfig{}, fmt.Errorf("GoMaxProcs (%v) exceeds the reserved threads (%v)", c.GoMaxProcs, reserved)
+	}
+
+	maxThreads := c.ThreadPoolMaxThreads
+	if maxThreads == 0 {
+		maxThreads = available
+	} else if maxThreads > available {
+		return db.ThreadPoolConfig{}, fmt.Errorf("ThreadPoolMaxThreads (%v) exceeds the available threads (%v = %v NumTCS - %v reserved)", maxThreads, available, numTCS, reserved)
+	}
+	if c.ThreadPoolSize > maxThreads {
+		return db.ThreadPoolConfig{}, fmt.Errorf("ThreadPoolSize (%v) exceeds ThreadPoolMaxThreads (%v)", c.ThreadPoolSize, maxThreads)
+	}
+
+	return db.ThreadPoolConfig{Size: c.ThreadPoolSize, MaxThreads: maxThreads, StallLimit: c.ThreadPoolStallLimit}, nil
+}
+
+// String returns the config as JSON for logging. The config doesn't contain secrets, so nothing needs to be redacted.
+func (c Config) String() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// validateAddress checks that the address is a host, [host], or host:port. The port is required for API addresses.
+func validateAddress(address string, requirePort bool) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		if requirePort {
+			return err
+		}
+		// address is a host without port, e.g., "1.2.3.4", "::1", or "[::1]"
+		host = address
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	} else if _, err := strconv.ParseUint(port, 10, 16); port != "" && err != nil {
+		return fmt.Errorf("invalid port: %q", port)
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+	// hostname
+	if strings.ContainsAny(host, "[]:/ ") {
+		return fmt.Errorf("invalid host: %q", host)
+	}
+	return nil
+}
+
+// TLSPolicy returns the TLS policy defined by the config.
+func (c Config) TLSPolicy() (util.TLSPolicy, error) {
+	return util.ParseTLSPolicy(c.TLSVersion, c.TLSCipherSuites)
+}
+
+// IsAllowedHostname returns whether edb may issue a certificate for the hostname.
+func (c Config) IsAllowedHostname(hostname string) bool {
+	patterns := c.CertificateHostnames
+	if len(patterns) <= 0 {
+		patterns = []string{c.CertificateDNSName}
+	}
+	for _, pattern := range patterns {
+		if matchHostname(strings.TrimSpace(pattern), hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostname matches a hostname against a pattern where a leading "*." matches exactly one label.
+func matchHostname(pattern, hostname string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if pattern == "" || hostname == "" {
+		return false
+	}
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == hostname
+	}
+	label, rest, found := strings.Cut(hostname, ".")
+	return found && label != "" && rest == pattern[2:]
+}
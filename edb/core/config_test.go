@@ -18,7 +18,9 @@ package core
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/edgelesssys/edgelessdb/edb/db"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,8 +28,8 @@ import (
 func TestFillConfigFromEnvironment(t *testing.T) {
 	config := Config{
 		DataPath:           "data",
-		DatabaseAddress:    "127.0.0.1",
-		APIAddress:         "127.0.0.1:8080",
+		DatabaseAddresses:  []string{"127.0.0.1"},
+		APIAddresses:       []string{"127.0.0.1:8080"},
 		CertificateDNSName: "localhost",
 	}
 
@@ -44,8 +46,8 @@ func TestFillConfigFromEnvironment(t *testing.T) {
 	require.NoError(os.Setenv(EnvAPIAddress, "1.2.3.4:1234"))
 
 	newConfig = FillConfigFromEnvironment(config)
-	assert.Equal("1.2.3.4:1234", newConfig.APIAddress)
-	assert.Equal("127.0.0.1", newConfig.DatabaseAddress) // old value
+	assert.Equal([]string{"1.2.3.4:1234"}, newConfig.APIAddresses)
+	assert.Equal([]string{"127.0.0.1"}, newConfig.DatabaseAddresses) // old value
 
 	// Full
 	require.NoError(os.Setenv(EnvAPIAddress, "1.2.3.4:1234"))
@@ -54,8 +56,333 @@ func TestFillConfigFromEnvironment(t *testing.T) {
 	require.NoError(os.Setenv(EnvCertificateDNSName, "mytest-cn"))
 
 	newConfig = FillConfigFromEnvironment(config)
-	assert.Equal("1.2.3.4:1234", newConfig.APIAddress)
+	assert.Equal([]string{"1.2.3.4:1234"}, newConfig.APIAddresses)
 	assert.Equal("edbTestDataPath", newConfig.DataPath)
-	assert.Equal("1.2.3.4", newConfig.DatabaseAddress)
+	assert.Equal([]string{"1.2.3.4"}, newConfig.DatabaseAddresses)
 	assert.Equal("mytest-cn", newConfig.CertificateDNSName)
+
+	// Address lists
+	require.NoError(os.Setenv(EnvAPIAddress, "0.0.0.0:8080,[::]:8080"))
+	require.NoError(os.Setenv(EnvDatabaseAddress, "::"))
+
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal([]string{"0.0.0.0:8080", "[::]:8080"}, newConfig.APIAddresses)
+	assert.Equal([]string{"::"}, newConfig.DatabaseAddresses)
+
+	// TLS policy
+	require.NoError(os.Setenv(EnvTLSVersion, "1.2"))
+	require.NoError(os.Setenv(EnvTLSCipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"))
+
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal("1.2", newConfig.TLSVersion)
+	assert.Equal([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, newConfig.TLSCipherSuites)
+	_, err := newConfig.TLSPolicy()
+	assert.NoError(err)
+
+	// Hostnames
+	require.NoError(os.Setenv(EnvCertificateHostnames, "mytest-cn,*.example.com"))
+	require.NoError(os.Setenv(EnvRejectUnknownHostnames, "1"))
+
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal([]string{"mytest-cn", "*.example.com"}, newConfig.CertificateHostnames)
+	assert.True(newConfig.RejectUnknownHostnames)
+
+	// Threads
+	require.NoError(os.Setenv(EnvReservedThreads, "16"))
+	require.NoError(os.Setenv(EnvGoMaxProcs, "4"))
+	require.NoError(os.Setenv(EnvThreadPoolSize, "8"))
+	require.NoError(os.Setenv(EnvThreadPoolMaxThreads, "100"))
+	require.NoError(os.Setenv(EnvThreadPoolStallLimit, "foo"))
+
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal(16, newConfig.ReservedThreads)
+	assert.Equal(4, newConfig.GoMaxProcs)
+	assert.Equal(8, newConfig.ThreadPoolSize)
+	assert.Equal(100, newConfig.ThreadPoolMaxThreads)
+	assert.Equal(-1, newConfig.ThreadPoolStallLimit)
+	assert.Error(newConfig.Validate())
+
+	// Shutdown
+	assert.Equal(DefaultShutdownTimeout, newConfig.GetShutdownTimeout())
+	require.NoError(os.Setenv(EnvShutdownTimeout, "10s"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal(10*time.Second, newConfig.GetShutdownTimeout())
+
+	// Supervisor
+	require.NoError(os.Setenv(EnvSupervise, "1"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.True(newConfig.Supervise)
+
+	// Snapshots
+	require.NoError(os.Setenv(EnvSnapshotDir, "/snapshots"))
+	require.NoError(os.Setenv(EnvRestoreSnapshot, "20221018-120000"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal("/snapshots", newConfig.SnapshotDir)
+	assert.Equal("20221018-120000", newConfig.RestoreSnapshot)
+
+	// Binary logs
+	assert.Equal(DefaultBinlogInterval, newConfig.GetBinlogInterval())
+	assert.EqualValues(DefaultBinlogMaxUnshipped<<20, newConfig.GetBinlogMaxUnshipped())
+	require.NoError(os.Setenv(EnvBinlogDir, "/binlogs"))
+	require.NoError(os.Setenv(EnvBinlogInterval, "30s"))
+	require.NoError(os.Setenv(EnvBinlogMaxUnshipped, "16"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal("/binlogs", newConfig.BinlogDir)
+	assert.Equal(30*time.Second, newConfig.GetBinlogInterval())
+	assert.EqualValues(16<<20, newConfig.GetBinlogMaxUnshipped())
+
+	// Replication
+	require.NoError(os.Setenv(EnvReplication, "1"))
+	require.NoError(os.Setenv(EnvReplicationPrimary, "primary:8080"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.True(newConfig.Replication)
+	assert.Equal("primary:8080", newConfig.ReplicationPrimary)
+
+	// Recovery lockout
+	require.NoError(os.Setenv(EnvRecoveryLockout, "10"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal(10, newConfig.RecoveryLockout)
+	require.NoError(os.Setenv(EnvRecoveryLockout, "foo"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal(-1, newConfig.RecoveryLockout)
+
+	// Logging
+	require.NoError(os.Setenv(EnvLogFormat, "json"))
+	require.NoError(os.Setenv(EnvLogLevel, "debug"))
+	newConfig = FillConfigFromEnvironment(config)
+	assert.Equal("json", newConfig.LogFormat)
+	assert.Equal("debug", newConfig.LogLevel)
+}
+
+func TestThreadPool(t *testing.T) {
+	testCases := map[string]struct {
+		config  Config
+		numTCS  int
+		want    db.ThreadPoolConfig
+		wantErr bool
+	}{
+		"default": {
+			numTCS: 64,
+			want:   db.ThreadPoolConfig{MaxThreads: 32},
+		},
+		"too few TCS for default reservation": {
+			numTCS:  32,
+			wantErr: true,
+		},
+		"custom reservation": {
+			config: Config{ReservedThreads: 8, GoMaxProcs: 2},
+			numTCS: 32,
+			want:   db.ThreadPoolConfig{MaxThreads: 24},
+		},
+		"GoMaxProcs exceeds reservation": {
+			config:  Config{ReservedThreads: 8, GoMaxProcs: 16},
+			numTCS:  64,
+			wantErr: true,
+		},
+		"all settings": {
+			config: Config{ThreadPoolSize: 4, ThreadPoolMaxThreads: 100, ThreadPoolStallLimit: 50},
+			numTCS: 1024,
+			want:   db.ThreadPoolConfig{Size: 4, MaxThreads: 100, StallLimit: 50},
+		},
+		"max threads exceed TCS": {
+			config:  Config{ThreadPoolMaxThreads: 100},
+			numTCS:  64,
+			wantErr: true,
+		},
+		"size exceeds max threads": {
+			config:  Config{ThreadPoolSize: 40},
+			numTCS:  64,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			threadPool, err := tc.config.ThreadPool(tc.numTCS)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.want, threadPool)
+		})
+	}
+}
+
+func TestIsAllowedHostname(t *testing.T) {
+	assert := assert.New(t)
+
+	config := Config{CertificateDNSName: "localhost"}
+	assert.True(config.IsAllowedHostname("localhost"))
+	assert.True(config.IsAllowedHostname("LocalHost."))
+	assert.False(config.IsAllowedHostname("example.com"))
+	assert.False(config.IsAllowedHostname(""))
+
+	config.CertificateHostnames = []string{"db.example.com", "*.edb.example.com"}
+	assert.False(config.IsAllowedHostname("localhost"))
+	assert.True(config.IsAllowedHostname("db.example.com"))
+	assert.True(config.IsAllowedHostname("a.edb.example.com"))
+	assert.False(config.IsAllowedHostname("edb.example.com"))
+	assert.False(config.IsAllowedHostname("a.b.edb.example.com"))
+	assert.False(config.IsAllowedHostname(".edb.example.com"))
+	assert.False(config.IsAllowedHostname("evil.com"))
+}
+
+func TestFillConfigFromFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	os.Clearenv()
+	defer os.Clearenv()
+
+	defaults := Config{
+		DataPath:           "data",
+		APIAddresses:       []string{":8080"},
+		CertificateDNSName: "localhost",
+	}
+
+	// JSON
+	config, err := FillConfigFromFile(defaults, []byte(`{"DataPath": "/data", "APIAddresses": ["0.0.0.0:8080", "[::]:8080"]}`))
+	require.NoError(err)
+	assert.Equal("/data", config.DataPath)
+	assert.Equal([]string{"0.0.0.0:8080", "[::]:8080"}, config.APIAddresses)
+	assert.Equal("localhost", config.CertificateDNSName)   // default
+	assert.Equal([]string{":8080"}, defaults.APIAddresses) // not modified
+
+	// YAML
+	config, err = FillConfigFromFile(defaults, []byte("DataPath: /data\nDebug: true\nLogDir: /log\nTLSVersion: \"1.3\"\n"))
+	require.NoError(err)
+	assert.Equal("/data", config.DataPath)
+	assert.True(config.Debug)
+	assert.Equal("/log", config.LogDir)
+	assert.Equal("1.3", config.TLSVersion)
+	assert.Equal([]string{":8080"}, config.APIAddresses) // default
+
+	// env overrides file
+	require.NoError(os.Setenv(EnvDataPath, "/env"))
+	config = FillConfigFromEnvironment(config)
+	assert.Equal("/env", config.DataPath)
+	assert.Equal("/log", config.LogDir)
+
+	// unknown field
+	_, err = FillConfigFromFile(defaults, []byte(`{"DataPth": "/data"}`))
+	assert.Error(err)
+
+	// invalid syntax
+	_, err = FillConfigFromFile(defaults, []byte(`{"DataPath": `))
+	assert.Error(err)
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{
+		DataPath:          "data",
+		DatabaseAddresses: []string{"0.0.0.0", "::", "[::1]:3306", "db.example.com"},
+		APIAddresses:      []string{":8080", "[::]:8080", "localhost:8080"},
+	}
+
+	testCases := map[string]struct {
+		change  func(*Config)
+		wantErr bool
+	}{
+		"valid": {
+			change: func(*Config) {},
+		},
+		"empty data path": {
+			change:  func(c *Config) { c.DataPath = "" },
+			wantErr: true,
+		},
+		"API address without port": {
+			change:  func(c *Config) { c.APIAddresses = []string{"0.0.0.0"} },
+			wantErr: true,
+		},
+		"invalid port": {
+			change:  func(c *Config) { c.APIAddresses = []string{":foo"} },
+			wantErr: true,
+		},
+		"invalid database address": {
+			change:  func(c *Config) { c.DatabaseAddresses = []string{"[::1"} },
+			wantErr: true,
+		},
+		"log dir without debug": {
+			change:  func(c *Config) { c.LogDir = "/log" },
+			wantErr: true,
+		},
+		"log dir with debug": {
+			change:  func(c *Config) { c.LogDir, c.Debug = "/log", true },
+			wantErr: false,
+		},
+		"json log": {
+			change:  func(c *Config) { c.LogFormat, c.LogLevel = "json", "warn" },
+			wantErr: false,
+		},
+		"invalid log format": {
+			change:  func(c *Config) { c.LogFormat = "xml" },
+			wantErr: true,
+		},
+		"invalid log level": {
+			change:  func(c *Config) { c.LogLevel = "verbose" },
+			wantErr: true,
+		},
+		"invalid hostname pattern": {
+			change:  func(c *Config) { c.CertificateHostnames = []string{"db.*.example.com"} },
+			wantErr: true,
+		},
+		"invalid shutdown timeout": {
+			change:  func(c *Config) { c.ShutdownTimeout = "10" },
+			wantErr: true,
+		},
+		"restore snapshot without snapshot dir": {
+			change:  func(c *Config) { c.RestoreSnapshot = "20221018-120000" },
+			wantErr: true,
+		},
+		"restore snapshot": {
+			change:  func(c *Config) { c.SnapshotDir, c.RestoreSnapshot = "/snapshots", "20221018-120000" },
+			wantErr: false,
+		},
+		"restore snapshot path": {
+			change:  func(c *Config) { c.SnapshotDir, c.RestoreSnapshot = "/snapshots", "../20221018-120000" },
+			wantErr: true,
+		},
+		"invalid binlog interval": {
+			change:  func(c *Config) { c.BinlogInterval = "-1m" },
+			wantErr: true,
+		},
+		"negative binlog max unshipped": {
+			change:  func(c *Config) { c.BinlogMaxUnshipped = -1 },
+			wantErr: true,
+		},
+		"replica": {
+			change:  func(c *Config) { c.ReplicationPrimary, c.ManifestFilePath = "primary:8080", "/manifest.json" },
+			wantErr: false,
+		},
+		"replica without manifest": {
+			change:  func(c *Config) { c.ReplicationPrimary = "primary:8080" },
+			wantErr: true,
+		},
+		"replica without port": {
+			change:  func(c *Config) { c.ReplicationPrimary, c.ManifestFilePath = "primary", "/manifest.json" },
+			wantErr: true,
+		},
+		"replica without host": {
+			change:  func(c *Config) { c.ReplicationPrimary, c.ManifestFilePath = ":8080", "/manifest.json" },
+			wantErr: true,
+		},
+		"invalid TLS version": {
+			change:  func(c *Config) { c.TLSVersion = "1.1" },
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := valid
+			tc.change(&config)
+			if tc.wantErr {
+				assert.Error(t, config.Validate())
+			} else {
+				assert.NoError(t, config.Validate())
+			}
+		})
+	}
 }
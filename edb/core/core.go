@@ -27,12 +27,13 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -40,13 +41,13 @@ import (
 	"github.com/edgelesssys/edgelessdb/edb/rt"
 	"github.com/edgelesssys/edgelessdb/edb/util"
 	"github.com/edgelesssys/ego/marble"
-	"github.com/fatih/color"
 	"github.com/spf13/afero"
 )
 
 // Core implements the core logic of EDB.
 type Core struct {
 	state     state
+	log       *rt.Logger // adds the state to each entry
 	cfg       Config
 	rt        rt.Runtime
 	db        db.Database
@@ -55,6 +56,41 @@ type Core struct {
 	report    []byte
 	isMarble  bool
 	masterKey []byte
+	tlsPolicy util.TLSPolicy
+	certCache *certificateCache
+	now       func() time.Time // returns the current time, replaced by tests
+	// snapshotMutex serializes checkpoints, which don't need to block other operations.
+	snapshotMutex sync.Mutex
+	// binlogMutex serializes the shipping of binary logs.
+	binlogMutex sync.Mutex
+	binlogStop  chan struct{}
+	binlogDone  chan struct{}
+	// binlogStatusMutex guards binlogStatus, which can be read while binary logs are shipped.
+	binlogStatusMutex sync.Mutex
+	binlogStatus      BinlogStatus
+	// supervisor is nil if supervision is disabled
+	supervisor *supervisor
+	// auditMutex serializes appending to the audit log. auditPending holds the records that can't be stored before the database has been initialized.
+	// auditDropped counts the records that didn't fit into auditPending.
+	auditMutex   sync.Mutex
+	auditPending []auditRecord
+	auditDropped uint64
+	// recoveryMutex guards the state of failed recovery attempts, which is loaded on first use.
+	recoveryMutex   sync.Mutex
+	recoveryState   *recoveryState
+	recoveryLimiter recoveryLimiter
+}
+
+// Status describes the current status of EDB.
+type Status struct {
+	TLS              util.TLSPolicy
+	CertificateCache CertificateCacheStats
+	ThreadPool       *db.ThreadPoolStatus  `json:",omitempty"` // nil if the database isn't running
+	Supervisor       *SupervisorStatus     `json:",omitempty"` // nil if supervision is disabled
+	Replication      *db.ReplicationStatus `json:",omitempty"` // nil if the database isn't running
+	ReadOnly         db.ReadOnlyStatus
+	Recovery         *RecoveryStatus `json:",omitempty"` // nil if there are no failed recovery attempts
+	Binlog           *BinlogStatus   `json:",omitempty"` // nil if binary logs aren't shipped
 }
 
 // The sequence of states EDB may be in
@@ -83,15 +119,54 @@ func (c *Core) advanceState(newState state) {
 		panic(fmt.Errorf("cannot advance from %d to %d", c.state, newState))
 	}
 	c.state = newState
+	c.log = coreLog.With("state", newState)
+}
+
+func (s state) String() string {
+	switch s {
+	case stateUninitialized:
+		return "uninitialized"
+	case stateRecovery:
+		return "recovery"
+	case stateInitialized:
+		return "initialized"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
 }
 
+// coreLog is the logger of the core component.
+var coreLog = rt.Log.With("component", "core")
+
 // NewCore creates a new Core object.
 func NewCore(cfg Config, rt rt.Runtime, db db.Database, fs afero.Afero, isMarble bool) *Core {
-	c := &Core{state: stateUninitialized, cfg: cfg, rt: rt, fs: fs, db: db, isMarble: isMarble}
+	tlsPolicy, err := cfg.TLSPolicy()
+	if err != nil {
+		panic(err)
+	}
+	c := &Core{state: stateUninitialized, log: coreLog.With("state", stateUninitialized), cfg: cfg, rt: rt, fs: fs, db: db, isMarble: isMarble, tlsPolicy: tlsPolicy, certCache: newCertificateCache(certificateCacheSize), now: time.Now}
+	if cfg.Supervise {
+		c.initSupervisor()
+	}
 	c.mustInitMasterKey()
 	return c
 }
 
+// GetStatus returns the current status of EDB.
+func (c *Core) GetStatus() Status {
+	status := Status{TLS: c.tlsPolicy, CertificateCache: c.certCache.stats()}
+	if threadPool, err := c.db.GetThreadPoolStatus(); err == nil {
+		status.ThreadPool = &threadPool
+	}
+	status.Supervisor = c.getSupervisorStatus()
+	if replication, err := c.db.GetReplicationStatus(); err == nil {
+		status.Replication = &replication
+	}
+	status.ReadOnly = c.db.GetReadOnlyStatus()
+	status.Recovery = c.getRecoveryStatus()
+	status.Binlog = c.getBinlogStatus()
+	return status
+}
+
 // GetManifestSignature returns the signature of the manifest that has been used to initialize the database.
 func (c *Core) GetManifestSignature() []byte {
 	c.mutex.Lock()
@@ -107,6 +182,8 @@ func (c *Core) GetCertificateReport() (string, []byte, error) {
 	}
 
 	// When running standalone, return edb root certificate
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	cert, _ := c.db.GetCertificate()
 	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
 	if len(pemCert) <= 0 {
@@ -115,6 +192,120 @@ func (c *Core) GetCertificateReport() (string, []byte, error) {
 	return string(pemCert), c.report, nil
 }
 
+// RenewCertificate replaces edb's root certificate and its key when running standalone.
+// Certificates issued by the previous root stay valid for the given overlap. cert must be the client certificate of an admin.
+func (c *Core) RenewCertificate(cert *x509.Certificate, overlap time.Duration) (err error) {
+	var admin string
+	defer func() { c.audit(operationRenew, cert, admin, err) }()
+	if c.isMarble {
+		return errors.New("cannot renew the root certificate when running as a Marble")
+	}
+	if overlap < 0 {
+		return errors.New("overlap must not be negative")
+	}
+	if _, admin, err = c.authorizeAdmin(cert, operationCertificate); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.db.RenewCertificate(overlap); err != nil {
+		return err
+	}
+	c.log.Info("renewed root certificate")
+	return c.GenerateReport()
+}
+
+// SetReadOnly enables or disables the read-only mode, e.g., for maintenance. cert must be the client certificate of an admin.
+// If persist is true, the database stays read-only after a restart.
+func (c *Core) SetReadOnly(cert *x509.Certificate, readOnly, persist bool) (err error) {
+	var admin string
+	defer func() { c.audit(operationReadOnly, cert, admin, err) }()
+	_, admin, err = c.authorizeAdmin(cert, operationReadOnly)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.db.SetReadOnly(readOnly, persist); err != nil {
+		return err
+	}
+	if readOnly {
+		c.log.Info("enabled the read-only mode", "admin", admin, "persistent", persist)
+	} else {
+		c.log.Info("disabled the read-only mode", "admin", admin)
+	}
+	return nil
+}
+
+// CreateCertificateRequest creates a CSR for a new root certificate when running standalone.
+// The returned quote binds the public key of the CSR. cert must be the client certificate of an admin.
+func (c *Core) CreateCertificateRequest(cert *x509.Certificate) (_ string, _ []byte, err error) {
+	var admin string
+	defer func() { c.audit(operationCSR, cert, admin, err) }()
+	if c.isMarble {
+		return "", nil, errors.New("cannot replace the root certificate when running as a Marble")
+	}
+	if _, admin, err = c.authorizeAdmin(cert, operationCertificate); err != nil {
+		return "", nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	csr, err := c.db.CreateCertificateRequest()
+	if err != nil {
+		return "", nil, err
+	}
+	parsedCSR, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := sha256.Sum256(parsedCSR.RawSubjectPublicKeyInfo)
+	report, err := c.rt.GetRemoteReport(hash[:])
+	if err != nil {
+		c.log.Warn("failed to get quote", "error", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})), report, nil
+}
+
+// SetCertificate replaces edb's root certificate by a certificate that has been issued for the last CSR when running standalone.
+// chainPEM may contain intermediate certificates, which are presented along with it. Certificates issued by the previous root stay valid for the given overlap.
+// cert must be the client certificate of an admin.
+func (c *Core) SetCertificate(cert *x509.Certificate, chainPEM []byte, overlap time.Duration) (err error) {
+	var admin string
+	defer func() { c.audit(operationCertificate, cert, admin, err) }()
+	if c.isMarble {
+		return errors.New("cannot replace the root certificate when running as a Marble")
+	}
+	if overlap < 0 {
+		return errors.New("overlap must not be negative")
+	}
+	if _, admin, err = c.authorizeAdmin(cert, operationCertificate); err != nil {
+		return err
+	}
+
+	var chain [][]byte
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block: %v", block.Type)
+		}
+		chain = append(chain, block.Bytes)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.db.SetCertificate(chain, overlap); err != nil {
+		return err
+	}
+	c.log.Info("set root certificate")
+	return c.GenerateReport()
+}
+
 // GetTLSConfig creates a TLS configuration that includes the certificate.
 func (c *Core) GetTLSConfig() *tls.Config {
 	// When running as a Marble, return TLS config containing a certificate chain
@@ -124,7 +315,7 @@ func (c *Core) GetTLSConfig() *tls.Config {
 
 	// When running standalone, return TLS config containing only edb's self-signed certificate
 	cert, key := c.db.GetCertificate()
-	return &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{
 			{
 				Certificate: [][]byte{cert},
@@ -133,16 +324,28 @@ func (c *Core) GetTLSConfig() *tls.Config {
 		},
 		GetConfigForClient: c.getConfigForClient,
 	}
+	c.tlsPolicy.Apply(config)
+	return config
 }
 
 // Initialize sets up a database according to the jsonManifest.
-func (c *Core) Initialize(jsonManifest []byte) ([]byte, error) {
-	// Encrypt recovery key if public key is provided.
-	var man struct{ Recovery string }
-	if err := json.Unmarshal(jsonManifest, &man); err != nil {
+func (c *Core) Initialize(jsonManifest []byte) (recoveryKey []byte, err error) {
+	defer func() { c.audit(operationManifest, nil, "", err) }()
+	return c.initialize(jsonManifest, nil)
+}
+
+// initialize sets up a database according to the jsonManifest. If dump isn't nil, the database is restored from it.
+func (c *Core) initialize(jsonManifest []byte, dump io.Reader) (recoveryKey []byte, err error) {
+	man, err := parseManifest(jsonManifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := man.validate(); err != nil {
 		return nil, err
 	}
-	recoveryKey, err := c.encryptRecoveryKey(c.masterKey, man.Recovery)
+
+	// Encrypt recovery key if public key is provided.
+	recoveryKey, err = c.encryptRecoveryKey(c.masterKey, man.Recovery)
 	if err != nil {
 		return nil, err
 	}
@@ -150,18 +353,64 @@ func (c *Core) Initialize(jsonManifest []byte) ([]byte, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if err := c.db.Initialize(jsonManifest); err != nil {
+	if man.QueryDigests {
+		c.db.EnableQueryDigests()
+	}
+	if dump != nil {
+		err = c.db.Restore(jsonManifest, dump)
+	} else {
+		err = c.db.Initialize(jsonManifest)
+	}
+	if err != nil {
+		// The failed initialization has been cleaned up, but MariaDB can only be started once per process.
+		var diagErr *db.DiagnosisError
+		if errors.As(err, &diagErr) {
+			c.log.Info("restarting after the failed initialization ...")
+			go func() {
+				time.Sleep(time.Second)
+				c.rt.RestartHostProcess()
+			}()
+		}
 		return nil, err
 	}
 
-	fmt.Println("restarting ...")
-	go func() {
-		time.Sleep(time.Second)
-		c.rt.RestartHostProcess()
-	}()
+	// The database has been started and is ready to serve.
+	if err := c.storeRecoveryKey(); err != nil {
+		return nil, err
+	}
+	if err := c.storeQueryDigestsMarker(man.QueryDigests); err != nil {
+		return nil, err
+	}
+	c.resetCrashStateWhenStable()
+	c.startBinlogShipping()
 	return recoveryKey, nil
 }
 
+// UpdateCAs replaces the CAs that have been added to the CAs of the manifest at runtime. cert must be the client certificate of an admin.
+func (c *Core) UpdateCAs(cert *x509.Certificate, jsonCAs []byte) (err error) {
+	var admin string
+	defer func() { c.audit(operationCA, cert, admin, err) }()
+	if _, admin, err = c.authorizeAdmin(cert, operationCA); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.db.UpdateCAs(jsonCAs)
+}
+
+// UpdateCRL replaces the certificate revocation list used to verify SQL client certificates.
+// The CRL must be signed by a CA of the manifest. cert must be the client certificate of an admin.
+func (c *Core) UpdateCRL(cert *x509.Certificate, crl []byte) (err error) {
+	var admin string
+	defer func() { c.audit(operationCRL, cert, admin, err) }()
+	if _, admin, err = c.authorizeAdmin(cert, operationCRL); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.db.UpdateCRL(crl)
+}
+
 // IsRecovering returns if edb (in standalone mode) is in recovery mode, or if it's not.
 func (c *Core) IsRecovering() bool {
 	defer c.mutex.Unlock()
@@ -169,22 +418,81 @@ func (c *Core) IsRecovering() bool {
 }
 
 // Recover sets an encryption key (ideally decrypted from the recovery data) and tries to unseal and load a saved state again.
-func (c *Core) Recover(ctx context.Context, key []byte) error {
+// Attempts are rate-limited per client and globally, and delayed exponentially after failures.
+func (c *Core) Recover(ctx context.Context, client string, key []byte) (err error) {
+	defer func() { c.audit(operationRecover, nil, "", err) }()
 	defer c.mutex.Unlock()
 	if err := c.requireState(stateRecovery); err != nil {
 		return err
 	}
-	if err := c.setMasterKey(key); err != nil {
+	if err := c.beginRecoveryAttempt(client, c.now()); err != nil {
+		return err
+	}
+	restored, err := c.verifyRecoveredKey(key)
+	if err != nil {
+		return err
+	}
+
+	// The key is only persisted after it has opened the database.
+	if err := c.storeMasterKeyToEnv(key); err != nil {
 		return err
 	}
+	c.masterKey = key
 	if err := c.StartDatabase(); err != nil {
+		c.masterKey = nil
+		os.Unsetenv(ERocksDBMasterKeyVar)
 		return err
 	}
+	if err := c.setMasterKey(key); err != nil {
+		return err
+	}
+	if err := c.endRecoveryAttempt(); err != nil {
+		return err
+	}
+	if restored {
+		c.log.Info("restored snapshot has been recovered")
+		return c.fs.Remove(filepath.Join(c.cfg.DataPath, PersistenceDir, restoredSnapshotFilename))
+	}
+	return nil
+}
+
+// StopDatabase shuts down the database cleanly. It returns an error if the database hasn't exited within the timeout.
+func (c *Core) StopDatabase(timeout time.Duration) error {
+	// Wait for operations that use the database, e.g., an initialization.
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	shipping := c.stopBinlogShipping()
+	if shipping {
+		if err := c.shipBinlogs(); err != nil {
+			c.log.Error("shipping binary logs failed", "error", err)
+		}
+	}
+	if err := c.db.Stop(timeout); err != nil {
+		return err
+	}
+	// MariaDB has completed the current binary log on shutdown.
+	if shipping {
+		if err := c.shipRemainingBinlogs(); err != nil {
+			return fmt.Errorf("shipping binary logs: %w", err)
+		}
+	}
 	return nil
 }
 
 // StartDatabase starts the database.
 func (c *Core) StartDatabase() error {
+	if c.cfg.Replication {
+		c.db.EnableReplication(c.replicationPassword())
+	}
+	joined, err := c.setReplicationSource()
+	if err != nil {
+		return err
+	}
+	queryDigests, err := c.prepareQueryDigests()
+	if err != nil {
+		return err
+	}
+
 	var dbNotInitializedYet bool
 	// Start MariaDB
 	if err := c.db.Start(); err == db.ErrNotInitializedYet {
@@ -192,14 +500,30 @@ func (c *Core) StartDatabase() error {
 	} else if err != nil {
 		return err
 	}
+	// The database has persisted the source of the joined replica.
+	if joined {
+		if err := c.fs.Remove(filepath.Join(c.cfg.DataPath, PersistenceDir, replicationJoinFilename)); err != nil {
+			return err
+		}
+	}
 
 	if err := c.GenerateReport(); err != nil {
 		return err
 	}
+	if !dbNotInitializedYet {
+		if err := c.storeRecoveryKey(); err != nil {
+			return err
+		}
+		if err := c.storeQueryDigestsMarker(queryDigests); err != nil {
+			return err
+		}
+		c.resetCrashStateWhenStable()
+		c.startBinlogShipping()
+	}
 
 	// If database is not initialized yet and a manifest file has been specified, initialize the database.
 	if dbNotInitializedYet && c.cfg.ManifestFilePath != "" {
-		rt.Log.Println("Found manifest path in environment, trying to initialize from file...")
+		c.log.Info("found manifest path in environment, trying to initialize from file ...")
 		// First, check if we can read the file
 		manifestContent, err := c.fs.ReadFile(c.cfg.ManifestFilePath)
 		if err != nil {
@@ -212,18 +536,11 @@ func (c *Core) StartDatabase() error {
 		}
 
 		if !c.isMarble && len(encryptedRecoveryData) > 0 {
-			color.Yellow("----------------------------------------ATTENTION----------------------------------------")
-			color.Yellow("Store the data below in a safe place to relaunch EdgelessDB on another host machine.")
-			color.Yellow("For more information: https://edglss.cc/doc-edb-recovery")
-			color.Yellow("-----------------------------------------------------------------------------------------")
-			color.Yellow("--------------------------------------RECOVERY DATA--------------------------------------")
-			color.Yellow(base64.StdEncoding.EncodeToString(encryptedRecoveryData))
-			color.Yellow("-----------------------------------------------------------------------------------------")
+			c.log.Warn("store the recovery data in a safe place to relaunch EdgelessDB on another host machine, for more information visit https://edglss.cc/doc-edb-recovery",
+				"recovery_data", base64.StdEncoding.EncodeToString(encryptedRecoveryData))
 		}
 	} else if dbNotInitializedYet && c.isMarble {
-		color.Red("edb was launched as a Marble, but was not provided a manifest to initialize with.")
-		color.Red("Please make sure your MarbleRun manifest does specify the required environment variable *and* manifest file.")
-		color.Red("For more information: https://edglss.cc/doc-edb-marblerun")
+		c.log.Error("edb was launched as a Marble, but was not provided a manifest to initialize with; make sure your MarbleRun manifest specifies the required environment variable and manifest file, for more information visit https://edglss.cc/doc-edb-marblerun")
 		return errors.New("marblerun did not supply any manifest")
 	}
 
@@ -247,8 +564,7 @@ func (c *Core) GenerateReport() error {
 
 	// If the report generation failed and attestation is not available, just warn the user, but do not cause the calling code to abort by returning an error.
 	if err != nil {
-		rt.Log.Printf("Failed to get quote: %v", err)
-		rt.Log.Print("Attestation will not be available.")
+		c.log.Warn("failed to get quote, attestation will not be available", "error", err)
 	}
 
 	return nil
@@ -267,26 +583,46 @@ func (c *Core) getConfigForClient(chi *tls.ClientHelloInfo) (*tls.Config, error)
 	if hostname == "" {
 		// use configured DNS name if client did not send ServerName
 		hostname = c.cfg.CertificateDNSName
+	} else if !c.cfg.IsAllowedHostname(hostname) {
+		// Don't issue certificates chaining to our root for arbitrary hostnames
+		if c.cfg.RejectUnknownHostnames {
+			return nil, fmt.Errorf("hostname not allowed: %v", hostname)
+		}
+		hostname = c.cfg.CertificateDNSName
 	}
 
-	ips := []net.IP{{127, 0, 0, 1}}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
 	if addr, ok := chi.Conn.LocalAddr().(*net.TCPAddr); ok {
+		// IPv4-mapped IPv6 addresses of dual-stack listeners are encoded as IPv4 SANs
 		ips = append(ips, addr.IP)
 	}
 
-	cert, key, err := createCertificate(hostname, ips, signerCert, signerKey)
-	if err != nil {
-		return nil, err
+	// Generating a certificate is expensive, so reuse it for connections with the same server name and IP.
+	now := time.Now()
+	cacheKey := newCertificateCacheKey(hostname, ips[len(ips)-1].String(), signerCert)
+	cert, key, ok := c.certCache.get(cacheKey, now)
+	if !ok {
+		notAfter := now.Add(ephemeralCertificateValidity)
+		var err error
+		cert, key, err = createCertificate(hostname, ips, notAfter, signerCert, signerKey)
+		if err != nil {
+			return nil, err
+		}
+		c.certCache.put(cacheKey, cert, key, notAfter, now)
 	}
 
-	return &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{
 			{
-				Certificate: [][]byte{cert, signerCert},
+				Certificate: append([][]byte{cert}, c.db.GetCertificateChain()...),
 				PrivateKey:  key,
 			},
 		},
-	}, nil
+		// Admins authenticate with client certificates declared in the manifest.
+		ClientAuth: tls.RequestClientCert,
+	}
+	c.tlsPolicy.Apply(config)
+	return config, nil
 }
 
 func (c *Core) encryptRecoveryKey(key []byte, recoveryKeyPEM string) ([]byte, error) {
@@ -294,30 +630,38 @@ func (c *Core) encryptRecoveryKey(key []byte, recoveryKeyPEM string) ([]byte, er
 		return nil, nil
 	}
 
-	block, _ := pem.Decode([]byte(recoveryKeyPEM))
-	if block == nil {
-		return nil, errors.New("failed to decode recovery public key")
+	rsaKey, err := parseRSAPublicKey(recoveryKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("recovery key: %w", err)
 	}
 
-	parsedRSAKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	recoveryKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, key, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	rsaKey, ok := parsedRSAKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("failed to get RSA key from recovery public key")
+	return recoveryKey, nil
+}
+
+func parseRSAPublicKey(keyPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("failed to decode public key")
 	}
 
-	recoveryKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, key, nil)
+	parsedRSAKey, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	return recoveryKey, nil
+	rsaKey, ok := parsedRSAKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("failed to get RSA key from public key")
+	}
+	return rsaKey, nil
 }
 
-func createCertificate(hostname string, ips []net.IP, signerCert []byte, signerKey crypto.PrivateKey) ([]byte, crypto.PrivateKey, error) {
+func createCertificate(hostname string, ips []net.IP, notAfter time.Time, signerCert []byte, signerKey crypto.PrivateKey) ([]byte, crypto.PrivateKey, error) {
 	serialNumber, err := util.GenerateCertificateSerialNumber()
 	if err != nil {
 		return nil, nil, err
@@ -325,7 +669,7 @@ func createCertificate(hostname string, ips []net.IP, signerCert []byte, signerK
 	template := &x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject:      pkix.Name{Organization: []string{"EDB ephemeral"}, CommonName: hostname},
-		NotAfter:     time.Now().Add(time.Hour),
+		NotAfter:     notAfter,
 		DNSNames:     []string{hostname},
 		IPAddresses:  ips,
 	}
@@ -369,7 +713,7 @@ func (c *Core) getTLSConfigMarble() *tls.Config {
 		panic(err)
 	}
 
-	return &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{
 			{
 				Certificate: [][]byte{cert, marbleCACert},
@@ -378,6 +722,8 @@ func (c *Core) getTLSConfigMarble() *tls.Config {
 		},
 		GetConfigForClient: c.getConfigForClient,
 	}
+	c.tlsPolicy.Apply(config)
+	return config
 }
 
 // getCertificateCA returns the Marblerun root/intermediate CA certificate used to generate edb's root certificate when running as a Marble
@@ -19,13 +19,17 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/edgelesssys/edgelessdb/edb/db"
 	"github.com/edgelesssys/edgelessdb/edb/rt"
+	"github.com/edgelesssys/edgelessdb/edb/util"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -71,6 +75,180 @@ func TestGetCertificateReport(t *testing.T) {
 	assert.Equal([]byte{2, 3, 4}, quote)
 }
 
+func TestTLSPolicy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	tlsConfig := core.GetTLSConfig()
+	assert.Zero(tlsConfig.MinVersion)
+	assert.Empty(core.GetStatus().TLS.MinVersion)
+
+	core.tlsPolicy, _ = util.ParseTLSPolicy("1.3", nil)
+	tlsConfig = core.GetTLSConfig()
+	assert.EqualValues(tls.VersionTLS13, tlsConfig.MinVersion)
+	assert.Equal("1.3", core.GetStatus().TLS.MinVersion)
+
+	// the config returned for a client must be restricted as well
+	clientConfig, err := tlsConfig.GetConfigForClient(&tls.ClientHelloInfo{Conn: newTCPConn(t, "127.0.0.1:0")})
+	require.NoError(err)
+	assert.EqualValues(tls.VersionTLS13, clientConfig.MinVersion)
+
+	// a cipher list excludes TLS 1.3, whose suites can't be restricted
+	core.tlsPolicy, _ = util.ParseTLSPolicy("", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	clientConfig, err = core.GetTLSConfig().GetConfigForClient(&tls.ClientHelloInfo{Conn: newTCPConn(t, "127.0.0.1:0")})
+	require.NoError(err)
+	assert.EqualValues(tls.VersionTLS12, clientConfig.MaxVersion)
+	assert.Equal("1.2", core.GetStatus().TLS.MaxVersion)
+}
+
+func TestStopDatabase(t *testing.T) {
+	core, _ := newCoreWithMocks()
+	assert.NoError(t, core.StartDatabase())
+	assert.NoError(t, core.StopDatabase(time.Second))
+	assert.True(t, core.db.(*db.DatabaseMock).Stopped)
+}
+
+func TestGetStatusThreadPool(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _ := newCoreWithMocks()
+	core.db.(*db.DatabaseMock).ThreadPool = db.ThreadPoolStatus{ThreadPoolConfig: db.ThreadPoolConfig{MaxThreads: 32}, Threads: 4, IdleThreads: 1, ConnectedThreads: 3}
+
+	status := core.GetStatus()
+	assert.NotNil(status.ThreadPool)
+	assert.Equal(32, status.ThreadPool.MaxThreads)
+	assert.Equal(4, status.ThreadPool.Threads)
+}
+
+func TestSetReadOnly(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	core, _ := newCoreWithMocks()
+	assert.Error(core.SetReadOnly(adminCert, true, false))
+
+	require.NoError(core.StartDatabase())
+	_, err := core.Initialize(createBackupManifest("", adminCertPEM))
+	require.NoError(err)
+
+	assert.ErrorIs(core.SetReadOnly(nil, true, false), ErrNotAdmin)
+	assert.False(core.GetStatus().ReadOnly.Enabled)
+
+	require.NoError(core.SetReadOnly(adminCert, true, true))
+	assert.Equal(db.ReadOnlyStatus{Enabled: true, Persistent: true}, core.GetStatus().ReadOnly)
+
+	require.NoError(core.SetReadOnly(adminCert, false, true))
+	assert.Equal(db.ReadOnlyStatus{}, core.GetStatus().ReadOnly)
+}
+
+func TestGetConfigForClientCache(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	core.cfg.CertificateHostnames = []string{"a", "b"}
+	conn := newTCPConn(t, "127.0.0.1:0")
+
+	getCert := func(serverName string) []byte {
+		config, err := core.getConfigForClient(&tls.ClientHelloInfo{ServerName: serverName, Conn: conn})
+		require.NoError(err)
+		return config.Certificates[0].Certificate[0]
+	}
+
+	cert := getCert("a")
+	assert.Equal(cert, getCert("a"))
+	assert.NotEqual(cert, getCert("b"))
+
+	parsedCert, err := x509.ParseCertificate(cert)
+	require.NoError(err)
+	assert.Equal([]string{"a"}, parsedCert.DNSNames)
+
+	assert.Equal(CertificateCacheStats{Hits: 1, Misses: 2, Size: 2}, core.GetStatus().CertificateCache)
+}
+
+func TestGetConfigForClientHostnames(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	core.cfg.CertificateDNSName = "localhost"
+	core.cfg.CertificateHostnames = []string{"localhost", "*.example.com"}
+	conn := newTCPConn(t, "127.0.0.1:0")
+
+	getDNSNames := func(serverName string) []string {
+		config, err := core.getConfigForClient(&tls.ClientHelloInfo{ServerName: serverName, Conn: conn})
+		require.NoError(err)
+		cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		require.NoError(err)
+		return cert.DNSNames
+	}
+
+	assert.Equal([]string{"localhost"}, getDNSNames(""))
+	assert.Equal([]string{"db.example.com"}, getDNSNames("db.example.com"))
+	assert.Equal([]string{"localhost"}, getDNSNames("evil.com"))
+
+	core.cfg.RejectUnknownHostnames = true
+	_, err := core.getConfigForClient(&tls.ClientHelloInfo{ServerName: "evil.com", Conn: conn})
+	assert.Error(err)
+	_, err = core.getConfigForClient(&tls.ClientHelloInfo{ServerName: "db.example.com", Conn: conn})
+	assert.NoError(err)
+}
+
+func TestGetConfigForClientIPv6(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	conn := newTCPConn(t, "[::1]:0")
+
+	config, err := core.getConfigForClient(&tls.ClientHelloInfo{Conn: conn})
+	require.NoError(err)
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	require.NoError(err)
+
+	assert.Len(cert.IPAddresses, 3)
+	assert.True(cert.IPAddresses[0].Equal(net.IPv4(127, 0, 0, 1)))
+	assert.True(cert.IPAddresses[1].Equal(net.IPv6loopback))
+	assert.NoError(cert.VerifyHostname("::1"))
+	assert.NoError(cert.VerifyHostname("127.0.0.1"))
+}
+
+func BenchmarkGetConfigForClient(b *testing.B) {
+	core, _ := newCoreWithMocks()
+	core.cfg.CertificateDNSName = "localhost"
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	chi := &tls.ClientHelloInfo{ServerName: "localhost", Conn: server}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := core.getConfigForClient(chi); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetConfigForClientUncached(b *testing.B) {
+	core, _ := newCoreWithMocks()
+	core.cfg.CertificateDNSName = "localhost"
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	chi := &tls.ClientHelloInfo{ServerName: "localhost", Conn: server}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// a new cache on each handshake equals the behavior without a cache
+		core.certCache = newCertificateCache(certificateCacheSize)
+		if _, err := core.getConfigForClient(chi); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestEncryptRecoveryKey(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -99,6 +277,18 @@ func newCoreWithMocks() (*Core, string) {
 	return NewCore(cfg, &rt, &db, fs, false), tempPath
 }
 
+func newTCPConn(t *testing.T, address string) net.Conn {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skip("cannot listen on", address, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
 func createMockRecoveryKey() (string, *rsa.PrivateKey, error) {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
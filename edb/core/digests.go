@@ -0,0 +1,94 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+)
+
+// queryDigestsFilename marks that the manifest enables query digests. MariaDB's performance schema can only be configured
+// before the database is started, i.e., before the manifest can be read, so edb configures it on the next start.
+// The marker doesn't permit anything: the digests are only collected if the manifest enables them.
+const queryDigestsFilename = "query_digests"
+
+// Limits of the number of query digests returned by GetQueryDigests
+const (
+	DefaultQueryDigestLimit = 100
+	MaxQueryDigestLimit     = 1000
+)
+
+// ErrQueryDigestsDisabled is returned if query digests are requested, but the manifest doesn't enable them.
+var ErrQueryDigestsDisabled = errors.New("the manifest doesn't enable query digests")
+
+// GetQueryDigests returns up to limit query digests, the ones with the highest total time first.
+func (c *Core) GetQueryDigests(cert *x509.Certificate, limit int) ([]db.QueryDigest, error) {
+	man, _, err := c.authorizeAdmin(cert, operationDigests)
+	if err != nil {
+		return nil, err
+	}
+	if !man.QueryDigests {
+		return nil, ErrQueryDigestsDisabled
+	}
+	if limit <= 0 || limit > MaxQueryDigestLimit {
+		return nil, errors.New("invalid limit")
+	}
+	return c.db.GetQueryDigests(limit)
+}
+
+// prepareQueryDigests configures the collection of query digests if the manifest enabled them when the database ran the last time.
+// It returns whether it has configured them.
+func (c *Core) prepareQueryDigests() (bool, error) {
+	exists, err := c.fs.Exists(filepath.Join(c.cfg.DataPath, PersistenceDir, queryDigestsFilename))
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		c.db.EnableQueryDigests()
+	}
+	return exists, nil
+}
+
+// storeQueryDigestsMarker updates the marker for the next start according to the manifest of the running database.
+// configured tells if the collection has been configured when the database has been started.
+func (c *Core) storeQueryDigestsMarker(configured bool) error {
+	jsonManifest := c.db.GetManifest()
+	if jsonManifest == nil {
+		return nil
+	}
+	man, err := parseManifest(jsonManifest)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(c.cfg.DataPath, PersistenceDir, queryDigestsFilename)
+	if !man.QueryDigests {
+		if err := c.fs.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if !configured {
+		c.log.Warn("the manifest enables query digests, they are collected after edb has been restarted")
+	}
+	if err := c.fs.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	return c.fs.WriteFile(filename, nil, 0o600)
+}
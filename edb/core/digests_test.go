@@ -0,0 +1,77 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryDigests(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	cfg := Config{DataPath: t.TempDir()}
+	markerPath := filepath.Join(cfg.DataPath, PersistenceDir, queryDigestsFilename)
+
+	// the manifest enables query digests
+	core, mockDB := newCoreWithOsFs(cfg)
+	require.NoError(core.StartDatabase())
+	jsonManifest, err := json.Marshal(map[string]interface{}{
+		"querydigests": true,
+		"admins":       map[string]string{"alice": adminCertPEM},
+	})
+	require.NoError(err)
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(err)
+	assert.True(mockDB.QueryDigestsEnabled)
+	assert.FileExists(markerPath)
+
+	mockDB.QueryDigests = []db.QueryDigest{
+		{Digest: "a", Statement: "SELECT * FROM `t` WHERE `i` = ?", Count: 2, TotalTime: time.Second},
+		{Digest: "b", Statement: "INSERT INTO `t` VALUES (...)", Count: 1, TotalTime: time.Millisecond},
+	}
+	digests, err := core.GetQueryDigests(adminCert, 1)
+	require.NoError(err)
+	assert.Equal(mockDB.QueryDigests[:1], digests)
+	_, err = core.GetQueryDigests(nil, 1)
+	assert.ErrorIs(err, ErrNotAdmin)
+	_, err = core.GetQueryDigests(adminCert, MaxQueryDigestLimit+1)
+	assert.Error(err)
+
+	// the collection is configured again after a restart
+	core, mockDB = newCoreWithOsFs(cfg)
+	require.NoError(core.StartDatabase())
+	assert.True(mockDB.QueryDigestsEnabled)
+
+	// the manifest doesn't enable query digests
+	core, mockDB = newCoreWithOsFs(cfg)
+	require.NoError(mockDB.Initialize(createBackupManifest("", adminCertPEM)))
+	require.NoError(core.StartDatabase())
+	assert.NoFileExists(markerPath)
+	_, err = core.GetQueryDigests(adminCert, 1)
+	assert.ErrorIs(err, ErrQueryDigestsDisabled)
+}
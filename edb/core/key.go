@@ -23,7 +23,6 @@ import (
 	"path/filepath"
 	"time"
 
-	"github.com/edgelesssys/edgelessdb/edb/rt"
 	"github.com/edgelesssys/ego/ecrypto"
 )
 
@@ -136,6 +135,7 @@ func (c *Core) storeMasterKey(key []byte) error {
 	if err := c.storeMasterKeyToEnv(key); err != nil {
 		return err
 	}
+	unsealedKey := key
 
 	// Save master key
 	if c.rt.IsEnclave() {
@@ -163,7 +163,7 @@ func (c *Core) storeMasterKey(key []byte) error {
 		return err
 	}
 
-	return nil
+	return c.storeKeyCheck(unsealedKey)
 }
 
 func (c *Core) setMasterKey(key []byte) error {
@@ -177,6 +177,18 @@ func (c *Core) setMasterKey(key []byte) error {
 }
 
 func (c *Core) mustInitMasterKey() {
+	if c.cfg.RestoreSnapshot != "" && c.mustRestoreSnapshot() {
+		c.log.Info("entering recovery mode to receive the master key of the restored snapshot ...")
+		c.advanceState(stateRecovery)
+		return
+	}
+	if c.cfg.ReplicationPrimary != "" && c.mustJoinPrimary() {
+		if err := c.initRecoveryState(); err != nil {
+			panic(err)
+		}
+		c.advanceState(stateInitialized)
+		return
+	}
 	// Check if RocksDB has already been initialized
 	rocksDBAlreadyInitialized, err := c.fs.Exists(filepath.Join(c.cfg.DataPath, "#rocksdb"))
 	if err != nil {
@@ -195,11 +207,22 @@ func (c *Core) mustInitMasterKey() {
 	}
 	// Failed to read/decrypt? Enter recovery.
 	if err != nil {
-		rt.Log.Println("Failed to initialize master key:", err)
-		rt.Log.Println("Entering recovery mode...")
+		c.log.Warn("failed to initialize master key, entering recovery mode ...", "error", err)
 		c.advanceState(stateRecovery)
 		return
 	}
+	// Databases created before the key check was introduced get it once the key has been unsealed.
+	if exists, err := c.fs.Exists(filepath.Join(c.cfg.DataPath, PersistenceDir, keyCheckFilename)); err != nil {
+		panic(err)
+	} else if !exists {
+		if err := c.storeKeyCheck(key); err != nil {
+			panic(err)
+		}
+	}
+	// New databases and databases created before the recovery state was authenticated get it once the key has been unsealed.
+	if err := c.initRecoveryState(); err != nil {
+		panic(err)
+	}
 	c.advanceState(stateInitialized)
 	c.masterKey = key
 }
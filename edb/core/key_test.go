@@ -125,17 +125,23 @@ func TestStoreMasterKey(t *testing.T) {
 	secondMockKey := []byte{4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}
 	assert.NoError(core.storeMasterKey(secondMockKey))
 
-	// Check if we can access both keys, the new one and the old backuped one
+	// Check if we can access both keys, the new one and the old backuped one, next to the key check and the recovery state
 	fsInfo, err = core.fs.ReadDir(path.Join(tempPath, PersistenceDir))
 	require.NoError(err)
-	require.Len(fsInfo, 2)
+	require.Len(fsInfo, 4)
+	assert.Equal(keyCheckFilename, fsInfo[0].Name())
+	assert.Equal(recoveryStateFilename, fsInfo[1].Name())
 
-	newKey, err := core.fs.ReadFile(filepath.Join(tempPath, PersistenceDir, fsInfo[0].Name()))
+	newKey, err := core.fs.ReadFile(filepath.Join(tempPath, PersistenceDir, fsInfo[2].Name()))
 	require.NoError(err)
 	assert.Equal(secondMockKey, newKey)
-	oldKey, err := core.fs.ReadFile(filepath.Join(tempPath, PersistenceDir, fsInfo[1].Name()))
+	oldKey, err := core.fs.ReadFile(filepath.Join(tempPath, PersistenceDir, fsInfo[3].Name()))
 	require.NoError(err)
 	assert.Equal(mockKey, oldKey)
+	_, err = core.verifyRecoveredKey(secondMockKey)
+	assert.NoError(err)
+	_, err = core.verifyRecoveredKey(mockKey)
+	assert.ErrorIs(err, ErrWrongKey)
 }
 
 func TestSetMasterKey(t *testing.T) {
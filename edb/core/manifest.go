@@ -0,0 +1,247 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNotAdmin is returned if a privileged operation is requested without the certificate of an admin.
+var ErrNotAdmin = errors.New("client is not an admin declared in the manifest")
+
+// errNotInitialized is returned if a privileged operation is requested before the database has been initialized.
+var errNotInitialized = errors.New("database has not been initialized yet")
+
+// ErrNotPermitted is returned if an admin requests an operation that the manifest doesn't permit them.
+var ErrNotPermitted = errors.New("admin is not permitted to perform this operation")
+
+// Privileged operations that the manifest can permit to admins.
+const (
+	operationBackup      = "backup"
+	operationSnapshot    = "snapshot"
+	operationPITR        = "pitr"
+	operationPromote     = "promote"
+	operationReadOnly    = "readonly"
+	operationCRL         = "crl"
+	operationCA          = "ca"
+	operationCertificate = "certificate"
+	operationAudit       = "audit"
+	operationDigests     = "digests"
+)
+
+var operations = []string{operationBackup, operationSnapshot, operationPITR, operationPromote, operationReadOnly, operationCRL, operationCA, operationCertificate, operationAudit, operationDigests}
+
+// manifest holds the parts of the manifest that are enforced by the core.
+type manifest struct {
+	Recovery     string
+	Backup       string
+	Admins       map[string]adminEntry
+	QueryDigests bool
+	// raw is the JSON-encoded manifest. Operations that embed the manifest use it so that it matches the parsed parts.
+	raw []byte
+}
+
+// adminEntry declares how an admin authenticates and which operations they may perform.
+// In the manifest, an entry is either an object or just a PEM-encoded certificate, which permits all operations.
+type adminEntry struct {
+	// Certificate is a PEM-encoded client certificate.
+	Certificate string
+	// PublicKey is a PEM-encoded public key of client certificates.
+	PublicKey string
+	// Issuer is a PEM-encoded CA certificate that issues client certificates. If Subject is set, it must match the subject of the certificate.
+	Issuer  string
+	Subject string
+	// Operations are permitted to the admin. All operations are permitted if it's empty.
+	Operations []string
+}
+
+func (e *adminEntry) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*e = adminEntry{}
+		return json.Unmarshal(data, &e.Certificate)
+	}
+	type plainEntry adminEntry
+	return json.Unmarshal(data, (*plainEntry)(e))
+}
+
+func parseManifest(jsonManifest []byte) (manifest, error) {
+	var man manifest
+	if err := json.Unmarshal(jsonManifest, &man); err != nil {
+		return manifest{}, err
+	}
+	man.raw = jsonManifest
+	return man, nil
+}
+
+func (m manifest) validate() error {
+	for name, entry := range m.Admins {
+		if err := entry.validate(); err != nil {
+			return fmt.Errorf("admin %v: %w", name, err)
+		}
+	}
+	if m.Backup != "" {
+		if _, err := parseRSAPublicKey(m.Backup); err != nil {
+			return fmt.Errorf("backup key: %w", err)
+		}
+	}
+	return nil
+}
+
+// admin returns the name of the admin that cert belongs to. The admin must be permitted to perform the operation.
+func (m manifest) admin(cert *x509.Certificate, operation string) (string, error) {
+	if cert == nil {
+		return "", ErrNotAdmin
+	}
+	names := make([]string, 0, len(m.Admins))
+	for name := range m.Admins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error = ErrNotAdmin
+	for _, name := range names {
+		entry := m.Admins[name]
+		match, matchErr := entry.matches(cert)
+		if matchErr != nil {
+			return "", fmt.Errorf("admin %v: %w", name, matchErr)
+		}
+		if !match {
+			continue
+		}
+		if entry.permits(operation) {
+			return name, nil
+		}
+		// the certificate may match another admin that is permitted
+		err = fmt.Errorf("%w: admin %v may not perform %v", ErrNotPermitted, name, operation)
+	}
+	return "", err
+}
+
+func (e adminEntry) validate() error {
+	set := 0
+	for _, value := range []string{e.Certificate, e.PublicKey, e.Issuer} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of certificate, publicKey, and issuer must be set")
+	}
+	if e.Subject != "" && e.Issuer == "" {
+		return errors.New("subject requires an issuer")
+	}
+	for _, operation := range e.Operations {
+		if !contains(operations, operation) {
+			return fmt.Errorf("unknown operation: %v", operation)
+		}
+	}
+	var err error
+	switch {
+	case e.Certificate != "":
+		_, err = parseCertificatePEM(e.Certificate)
+	case e.PublicKey != "":
+		_, err = parsePublicKeyPEM(e.PublicKey)
+	case e.Issuer != "":
+		_, err = parseCertificatePEM(e.Issuer)
+	}
+	return err
+}
+
+// matches returns whether cert authenticates the admin.
+func (e adminEntry) matches(cert *x509.Certificate) (bool, error) {
+	switch {
+	case e.Certificate != "":
+		adminCert, err := parseCertificatePEM(e.Certificate)
+		if err != nil {
+			return false, err
+		}
+		return adminCert.Equal(cert), nil
+	case e.PublicKey != "":
+		key, err := parsePublicKeyPEM(e.PublicKey)
+		if err != nil {
+			return false, err
+		}
+		certKey, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		return ok && certKey.Equal(key), nil
+	case e.Issuer != "":
+		issuer, err := parseCertificatePEM(e.Issuer)
+		if err != nil {
+			return false, err
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(issuer)
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			return false, nil
+		}
+		return e.Subject == "" || e.Subject == cert.Subject.String(), nil
+	}
+	return false, nil
+}
+
+func (e adminEntry) permits(operation string) bool {
+	return len(e.Operations) == 0 || contains(e.Operations, operation)
+}
+
+func parseCertificatePEM(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePublicKeyPEM(keyPEM string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("failed to decode public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeAdmin returns the manifest of the database and the name of the admin that cert belongs to.
+// The admin must be permitted to perform the operation. The caller should use the returned manifest for the rest of the request
+// rather than getting it from the database again.
+func (c *Core) authorizeAdmin(cert *x509.Certificate, operation string) (manifest, string, error) {
+	jsonManifest := c.db.GetManifest()
+	if jsonManifest == nil {
+		return manifest{}, "", errNotInitialized
+	}
+	man, err := parseManifest(jsonManifest)
+	if err != nil {
+		return manifest{}, "", err
+	}
+	admin, err := man.admin(cert, operation)
+	if err != nil {
+		return manifest{}, "", err
+	}
+	return man, admin, nil
+}
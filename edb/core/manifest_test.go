@@ -0,0 +1,157 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestAdminEntries(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	aliceCert, aliceCertPEM := createMockClientCertificate(t)
+	bobCert, _ := createMockClientCertificate(t)
+	bobKey, err := x509.MarshalPKIXPublicKey(bobCert.PublicKey)
+	require.NoError(err)
+	bobKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: bobKey}))
+	caCertPEM, carolCert, daveCert := createMockIssuedClientCertificates(t)
+	otherCert, _ := createMockClientCertificate(t)
+
+	jsonManifest, err := json.Marshal(map[string]interface{}{
+		"admins": map[string]interface{}{
+			"alice": aliceCertPEM,
+			"bob":   map[string]interface{}{"publicKey": bobKeyPEM, "operations": []string{"backup"}},
+			"carol": map[string]interface{}{"issuer": caCertPEM, "subject": "CN=carol", "operations": []string{"snapshot", "readonly"}},
+		},
+	})
+	require.NoError(err)
+	man, err := parseManifest(jsonManifest)
+	require.NoError(err)
+	require.NoError(man.validate())
+
+	testCases := map[string]struct {
+		cert      *x509.Certificate
+		operation string
+		wantAdmin string
+		wantErr   error
+	}{
+		"certificate permits all operations": {aliceCert, operationCRL, "alice", nil},
+		"public key":                         {bobCert, operationBackup, "bob", nil},
+		"public key not permitted":           {bobCert, operationPromote, "", ErrNotPermitted},
+		"issuer and subject":                 {carolCert, operationReadOnly, "carol", nil},
+		"issuer not permitted":               {carolCert, operationBackup, "", ErrNotPermitted},
+		"subject mismatch":                   {daveCert, operationSnapshot, "", ErrNotAdmin},
+		"unknown certificate":                {otherCert, operationSnapshot, "", ErrNotAdmin},
+		"no certificate":                     {nil, operationSnapshot, "", ErrNotAdmin},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			admin, err := man.admin(tc.cert, tc.operation)
+			if tc.wantErr != nil {
+				assert.ErrorIs(err, tc.wantErr)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantAdmin, admin)
+		})
+	}
+
+	// invalid entries
+	for _, entry := range []adminEntry{
+		{},
+		{Certificate: aliceCertPEM, PublicKey: bobKeyPEM},
+		{PublicKey: aliceCertPEM},
+		{Certificate: aliceCertPEM, Subject: "CN=alice"},
+		{Certificate: aliceCertPEM, Operations: []string{"unknown"}},
+	} {
+		assert.Error(manifest{Admins: map[string]adminEntry{"eve": entry}}.validate(), entry)
+	}
+}
+
+func TestAuthorizeAdmin(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	otherCert, _ := createMockClientCertificate(t)
+	core, _ := newCoreWithMocks()
+
+	// no one may perform the operation before the database has been initialized
+	_, _, err := core.authorizeAdmin(adminCert, operationCRL)
+	assert.Error(err)
+
+	require.NoError(core.StartDatabase())
+	_, err = core.Initialize(createBackupManifest("", adminCertPEM))
+	require.NoError(err)
+
+	_, _, err = core.authorizeAdmin(nil, operationCRL)
+	assert.ErrorIs(err, ErrNotAdmin)
+	_, _, err = core.authorizeAdmin(otherCert, operationCRL)
+	assert.ErrorIs(err, ErrNotAdmin)
+	_, admin, err := core.authorizeAdmin(adminCert, operationCRL)
+	assert.NoError(err)
+	assert.Equal("alice", admin)
+}
+
+// createMockIssuedClientCertificates creates a CA and client certificates for carol and dave issued by it.
+func createMockIssuedClientCertificates(t *testing.T) (string, *x509.Certificate, *x509.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "admin CA"},
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	var certs []*x509.Certificate
+	for i, name := range []string{"carol", "dave"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(certDER)
+		require.NoError(t, err)
+		certs = append(certs, cert)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})), certs[0], certs[1]
+}
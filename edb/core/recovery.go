@@ -0,0 +1,418 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// recoveryWindow is the period in which a client may make recoveryClientLimit attempts and all clients together recoveryGlobalLimit attempts.
+	recoveryWindow      = time.Minute
+	recoveryClientLimit = 5
+	recoveryGlobalLimit = 20
+	// After a failed attempt, the next attempt is delayed exponentially from recoveryBackoffBase up to recoveryBackoffMax.
+	recoveryBackoffBase = time.Second
+	recoveryBackoffMax  = 5 * time.Minute
+	// recoveryUntrustedFailures is assumed if the recovery state is missing or invalid, so that the next failure is delayed by recoveryBackoffMax.
+	recoveryUntrustedFailures = 20
+
+	// recoveryStateFilename is stored in the persistence dir once the master key has been set.
+	recoveryStateFilename = "recovery_state.json"
+	// recoveryKeyFilename is stored in the persistence dir so that an operator can unlock recovery before the database runs.
+	recoveryKeyFilename = "recovery_key.json"
+	// keyCheckFilename is stored in the persistence dir so that a recovered master key can be verified before it's used.
+	keyCheckFilename = "key_check"
+	// recoveryUnlockPrefix is prepended to the hex-encoded nonce to form the message that an operator signs to unlock recovery.
+	recoveryUnlockPrefix = "edb recovery unlock "
+)
+
+// ErrRecoveryLocked is returned if recovery has been locked after too many failed attempts.
+var ErrRecoveryLocked = errors.New("recovery is locked after too many failed attempts")
+
+// ErrWrongKey is returned if a recovery key doesn't belong to the database.
+var ErrWrongKey = errors.New("the key doesn't belong to the database")
+
+// RecoveryRateLimitedError is returned if a recovery attempt is rejected because of rate limiting or backoff.
+type RecoveryRateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RecoveryRateLimitedError) Error() string {
+	return fmt.Sprintf("too many recovery attempts, retry after %v", e.RetryAfter.Round(time.Second))
+}
+
+// RecoveryStatus describes the failed recovery attempts.
+type RecoveryStatus struct {
+	// Failures is the number of consecutive failed attempts.
+	Failures int
+	Locked   bool
+	// UnlockNonce must be signed to unlock recovery.
+	UnlockNonce string `json:",omitempty"`
+}
+
+// recoveryState is persisted so that restarting edb doesn't reset the failed attempts. Without a monotonic counter,
+// the host can still replace it with an older valid state before edb loads it, so the limits only hold within one
+// process lifetime.
+type recoveryState struct {
+	Failures int
+	// Next is the earliest time of the next attempt.
+	Next time.Time
+	// Nonce is set while recovery is locked.
+	Nonce []byte `json:",omitempty"`
+	// MAC authenticates the state with the product seal key, so that the host can't modify it or create its own.
+	MAC []byte `json:",omitempty"`
+}
+
+// recoveryKeyFile holds the recovery key of the manifest while the database isn't running.
+type recoveryKeyFile struct {
+	Key string
+	// MAC authenticates the key with the product seal key, so that the host can't unlock recovery with its own key.
+	MAC []byte
+}
+
+// recoveryLimiter tracks the recent recovery attempts in memory.
+type recoveryLimiter struct {
+	clients map[string][]time.Time
+	all     []time.Time
+}
+
+// beginRecoveryAttempt checks the rate limits, the backoff, and the lockout for an attempt by client.
+// The attempt counts as failed until endRecoveryAttempt is called, so that an attempt that crashes edb counts, too.
+func (c *Core) beginRecoveryAttempt(client string, now time.Time) error {
+	c.recoveryMutex.Lock()
+	defer c.recoveryMutex.Unlock()
+	state, err := c.getRecoveryState()
+	if err != nil {
+		return err
+	}
+	if state.Nonce != nil {
+		return fmt.Errorf("%w, unlock nonce: %x", ErrRecoveryLocked, state.Nonce)
+	}
+
+	if now.Before(state.Next) {
+		return &RecoveryRateLimitedError{RetryAfter: state.Next.Sub(now)}
+	}
+	limiter := &c.recoveryLimiter
+	if limiter.clients == nil {
+		limiter.clients = map[string][]time.Time{}
+	}
+	limiter.all = attemptsSince(limiter.all, now.Add(-recoveryWindow))
+	for name, attempts := range limiter.clients {
+		if limiter.clients[name] = attemptsSince(attempts, now.Add(-recoveryWindow)); len(limiter.clients[name]) == 0 {
+			delete(limiter.clients, name)
+		}
+	}
+	if attempts := limiter.clients[client]; len(attempts) >= recoveryClientLimit {
+		return &RecoveryRateLimitedError{RetryAfter: attempts[0].Add(recoveryWindow).Sub(now)}
+	}
+	if len(limiter.all) >= recoveryGlobalLimit {
+		return &RecoveryRateLimitedError{RetryAfter: limiter.all[0].Add(recoveryWindow).Sub(now)}
+	}
+	limiter.clients[client] = append(limiter.clients[client], now)
+	limiter.all = append(limiter.all, now)
+
+	state.Failures++
+	backoff := recoveryBackoffMax
+	if state.Failures <= 20 {
+		if delay := recoveryBackoffBase << (state.Failures - 1); delay < backoff {
+			backoff = delay
+		}
+	}
+	state.Next = now.Add(backoff)
+	// Recovery isn't locked if it couldn't be unlocked, e.g., on another CPU. The backoff still applies.
+	if _, err := c.loadRecoveryKey(); err == nil && c.cfg.RecoveryLockout > 0 && state.Failures >= c.cfg.RecoveryLockout {
+		state.Nonce = make([]byte, 16)
+		if _, err := rand.Read(state.Nonce); err != nil {
+			return err
+		}
+	}
+	return c.storeRecoveryState(state)
+}
+
+// endRecoveryAttempt resets the failed attempts after a successful recovery.
+func (c *Core) endRecoveryAttempt() error {
+	c.recoveryMutex.Lock()
+	defer c.recoveryMutex.Unlock()
+	c.recoveryLimiter = recoveryLimiter{}
+	return c.storeRecoveryState(recoveryState{})
+}
+
+// UnlockRecovery unlocks recovery after too many failed attempts. signature must be an RSA PKCS #1 v1.5 signature with SHA-256
+// of the unlock message, created with the private recovery key of the manifest.
+func (c *Core) UnlockRecovery(signature []byte) (err error) {
+	defer func() { c.audit(operationUnlock, nil, "", err) }()
+	c.recoveryMutex.Lock()
+	defer c.recoveryMutex.Unlock()
+	state, err := c.getRecoveryState()
+	if err != nil {
+		return err
+	}
+	if state.Nonce == nil {
+		return errors.New("recovery is not locked")
+	}
+	key, err := c.loadRecoveryKey()
+	if err != nil {
+		return fmt.Errorf("loading recovery key: %w", err)
+	}
+	hash := sha256.Sum256([]byte(recoveryUnlockPrefix + hex.EncodeToString(state.Nonce)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return errors.New("invalid unlock signature")
+	}
+	c.recoveryLimiter = recoveryLimiter{}
+	return c.storeRecoveryState(recoveryState{})
+}
+
+// getRecoveryStatus returns nil if there are no failed recovery attempts.
+func (c *Core) getRecoveryStatus() *RecoveryStatus {
+	c.recoveryMutex.Lock()
+	defer c.recoveryMutex.Unlock()
+	state, err := c.getRecoveryState()
+	if err != nil || state.Failures == 0 {
+		return nil
+	}
+	status := &RecoveryStatus{Failures: state.Failures, Locked: state.Nonce != nil}
+	if state.Nonce != nil {
+		status.UnlockNonce = hex.EncodeToString(state.Nonce)
+	}
+	return status
+}
+
+// getRecoveryState loads the persisted state on first use. The caller must hold recoveryMutex.
+func (c *Core) getRecoveryState() (recoveryState, error) {
+	if c.recoveryState != nil {
+		return *c.recoveryState, nil
+	}
+	state, invalid, err := c.readRecoveryState()
+	if err != nil {
+		return recoveryState{}, err
+	}
+	if invalid != nil {
+		// The state is written when the master key is known, so the host may have removed or modified it.
+		c.log.Warn("recovery state is missing or invalid, treating recovery as locked", "error", invalid)
+		if state, err = c.untrustedRecoveryState(); err != nil {
+			return recoveryState{}, err
+		}
+		if err := c.storeRecoveryState(state); err != nil {
+			return recoveryState{}, err
+		}
+	}
+	c.recoveryState = &state
+	return state, nil
+}
+
+// initRecoveryState stores a state without failed attempts unless a valid state exists. It's called once the master key is known.
+func (c *Core) initRecoveryState() error {
+	c.recoveryMutex.Lock()
+	defer c.recoveryMutex.Unlock()
+	state, invalid, err := c.readRecoveryState()
+	if err != nil {
+		return err
+	}
+	if invalid != nil {
+		return c.storeRecoveryState(recoveryState{})
+	}
+	c.recoveryState = &state
+	return nil
+}
+
+// readRecoveryState reads the persisted state. invalid is set if the state is missing or can't be verified.
+func (c *Core) readRecoveryState() (state recoveryState, invalid error, err error) {
+	key, err := c.rt.GetProductSealKey()
+	if err != nil {
+		return recoveryState{}, nil, err
+	}
+	data, err := c.fs.ReadFile(filepath.Join(c.cfg.DataPath, PersistenceDir, recoveryStateFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return recoveryState{}, err, nil
+	} else if err != nil {
+		return recoveryState{}, nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return recoveryState{}, err, nil
+	}
+	if err := state.verify(key); err != nil {
+		return recoveryState{}, err, nil
+	}
+	return state, nil, nil
+}
+
+// untrustedRecoveryState returns the state that replaces a missing or invalid one. Recovery is locked if it can be unlocked,
+// i.e., if the recovery key can be verified. Otherwise, e.g., on another CPU, whose product seal key doesn't verify the recovery key
+// either, the next attempt is delayed by recoveryBackoffMax.
+func (c *Core) untrustedRecoveryState() (recoveryState, error) {
+	state := recoveryState{Failures: recoveryUntrustedFailures, Next: c.now().Add(recoveryBackoffMax)}
+	if _, err := c.loadRecoveryKey(); err != nil {
+		return state, nil
+	}
+	state.Nonce = make([]byte, 16)
+	if _, err := rand.Read(state.Nonce); err != nil {
+		return recoveryState{}, err
+	}
+	return state, nil
+}
+
+// storeRecoveryState persists the state. The caller must hold recoveryMutex.
+func (c *Core) storeRecoveryState(state recoveryState) error {
+	key, err := c.rt.GetProductSealKey()
+	if err != nil {
+		return err
+	}
+	state.MAC = nil
+	if state.MAC, err = macJSON(key, state); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(c.cfg.DataPath, PersistenceDir, recoveryStateFilename)
+	if err := c.fs.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	if err := c.fs.WriteFile(filename, data, 0o600); err != nil {
+		return err
+	}
+	c.recoveryState = &state
+	return nil
+}
+
+func (s recoveryState) verify(key []byte) error {
+	mac := s.MAC
+	s.MAC = nil
+	if !validMACJSON(key, s, mac) {
+		return errors.New("recovery state has been modified")
+	}
+	return nil
+}
+
+// storeRecoveryKey persists the recovery key of the manifest so that it's available in recovery mode.
+func (c *Core) storeRecoveryKey() error {
+	jsonManifest := c.db.GetManifest()
+	if jsonManifest == nil {
+		return nil
+	}
+	man, err := parseManifest(jsonManifest)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(c.cfg.DataPath, PersistenceDir, recoveryKeyFilename)
+	if man.Recovery == "" {
+		if err := c.fs.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	key, err := c.rt.GetProductSealKey()
+	if err != nil {
+		return err
+	}
+	file := recoveryKeyFile{Key: man.Recovery}
+	if file.MAC, err = macJSON(key, file); err != nil {
+		return err
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	return c.fs.WriteFile(filename, data, 0o600)
+}
+
+// loadRecoveryKey returns the recovery key that has been stored by storeRecoveryKey.
+func (c *Core) loadRecoveryKey() (*rsa.PublicKey, error) {
+	data, err := c.fs.ReadFile(filepath.Join(c.cfg.DataPath, PersistenceDir, recoveryKeyFilename))
+	if err != nil {
+		return nil, err
+	}
+	var file recoveryKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	key, err := c.rt.GetProductSealKey()
+	if err != nil {
+		return nil, err
+	}
+	mac := file.MAC
+	file.MAC = nil
+	if !validMACJSON(key, file, mac) {
+		return nil, errors.New("recovery key has been modified")
+	}
+	return parseRSAPublicKey(file.Key)
+}
+
+// verifyRecoveredKey verifies a master key before it's used. It returns whether the key belongs to a restored snapshot.
+// Databases get the key check when the master key is set or unsealed, so a missing key check means that the host has removed it.
+func (c *Core) verifyRecoveredKey(key []byte) (bool, error) {
+	if c.isMarble {
+		return false, ErrKeyNotAllowedToChangeMarblerun
+	}
+	if len(key) != 16 {
+		return false, ErrKeyIncorrectSize
+	}
+	restored, err := c.verifyRestoredSnapshotKey(key)
+	if err != nil || restored {
+		return restored, err
+	}
+	expected, err := c.fs.ReadFile(filepath.Join(c.cfg.DataPath, PersistenceDir, keyCheckFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("%w: the key check is missing", ErrRecoveryLocked)
+	} else if err != nil {
+		return false, err
+	}
+	if !hmac.Equal(keyCheck(key), expected) {
+		return false, ErrWrongKey
+	}
+	return false, nil
+}
+
+// storeKeyCheck persists a value that verifies the master key without revealing it.
+func (c *Core) storeKeyCheck(key []byte) error {
+	filename := filepath.Join(c.cfg.DataPath, PersistenceDir, keyCheckFilename)
+	if err := c.fs.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	return c.fs.WriteFile(filename, keyCheck(key), 0o600)
+}
+
+func keyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("edb key check"))
+	return mac.Sum(nil)
+}
+
+// attemptsSince returns the attempts at or after since. attempts must be sorted.
+func attemptsSince(attempts []time.Time, since time.Time) []time.Time {
+	for i, attempt := range attempts {
+		if !attempt.Before(since) {
+			return attempts[i:]
+		}
+	}
+	return nil
+}
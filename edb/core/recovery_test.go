@@ -0,0 +1,194 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+	core, _ := newCoreWithOsFs(Config{DataPath: t.TempDir()})
+	now := time.Now()
+
+	// exponential backoff: 1s, 2s, 4s, 8s, 16s
+	require.NoError(core.beginRecoveryAttempt("a", now))
+	var rateLimitedErr *RecoveryRateLimitedError
+	require.ErrorAs(core.beginRecoveryAttempt("a", now.Add(500*time.Millisecond)), &rateLimitedErr)
+	assert.Equal(500*time.Millisecond, rateLimitedErr.RetryAfter)
+	for _, offset := range []time.Duration{1, 3, 7, 15} {
+		require.NoError(core.beginRecoveryAttempt("a", now.Add(offset*time.Second)))
+	}
+
+	// the client has used up its attempts in this window, but another client hasn't
+	assert.ErrorAs(core.beginRecoveryAttempt("a", now.Add(31*time.Second)), &rateLimitedErr)
+	assert.NoError(core.beginRecoveryAttempt("b", now.Add(31*time.Second)))
+	assert.Equal(&RecoveryStatus{Failures: 6}, core.GetStatus().Recovery)
+
+	require.NoError(core.endRecoveryAttempt())
+	assert.Nil(core.GetStatus().Recovery)
+	assert.NoError(core.beginRecoveryAttempt("a", now.Add(32*time.Second)))
+}
+
+func TestRecoveryLockout(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+	cfg := Config{DataPath: t.TempDir(), RecoveryLockout: 2}
+	core, _ := newCoreWithOsFs(cfg)
+	now := time.Now()
+
+	// recovery is only locked if the recovery key can unlock it
+	recoveryKeyPEM, recoveryKey, err := createMockRecoveryKey()
+	require.NoError(err)
+	require.NoError(core.beginRecoveryAttempt("a", now))
+	require.NoError(core.beginRecoveryAttempt("a", now.Add(time.Second)))
+	require.NoError(core.beginRecoveryAttempt("a", now.Add(time.Hour)))
+	writeMockRecoveryKey(t, core, recoveryKeyPEM)
+	require.NoError(core.beginRecoveryAttempt("a", now.Add(2*time.Hour)))
+	assert.ErrorIs(core.beginRecoveryAttempt("a", now.Add(3*time.Hour)), ErrRecoveryLocked)
+
+	// the lockout persists across restarts
+	core, _ = newCoreWithOsFs(cfg)
+	assert.ErrorIs(core.beginRecoveryAttempt("b", now.Add(3*time.Hour)), ErrRecoveryLocked)
+	status := core.GetStatus().Recovery
+	require.NotNil(status)
+	assert.Equal(4, status.Failures)
+	assert.True(status.Locked)
+
+	// unlocking requires a signature of the recovery key
+	assert.Error(core.UnlockRecovery([]byte("signature")))
+
+	nonce, err := hex.DecodeString(status.UnlockNonce)
+	require.NoError(err)
+	hash := sha256.Sum256([]byte(recoveryUnlockPrefix + hex.EncodeToString(nonce)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, recoveryKey, crypto.SHA256, hash[:])
+	require.NoError(err)
+	require.NoError(core.UnlockRecovery(signature))
+	assert.Nil(core.GetStatus().Recovery)
+	assert.NoError(core.beginRecoveryAttempt("a", now.Add(3*time.Hour)))
+	assert.Error(core.UnlockRecovery(signature))
+}
+
+func TestRecoverVerifiesKey(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+	cfg := Config{DataPath: t.TempDir()}
+	core, _ := newCoreWithOsFs(cfg)
+	key := core.masterKey
+	sealedKeyPath := filepath.Join(cfg.DataPath, PersistenceDir, sealedKeyFname)
+	require.NoError(os.Remove(sealedKeyPath))
+	require.NoError(os.Mkdir(filepath.Join(cfg.DataPath, "#rocksdb"), 0o700))
+	os.Clearenv()
+
+	// a host without the sealed key enters recovery mode
+	core, _ = newCoreWithOsFs(cfg)
+	require.True(core.IsRecovering())
+
+	// a wrong key isn't used or persisted
+	wrongKey := make([]byte, 16)
+	assert.ErrorIs(core.Recover(context.Background(), "client", wrongKey), ErrWrongKey)
+	assert.True(core.IsRecovering())
+	assert.NoFileExists(sealedKeyPath)
+	assert.Empty(os.Getenv(ERocksDBMasterKeyVar))
+	assert.Equal(1, core.GetStatus().Recovery.Failures)
+
+	var rateLimitedErr *RecoveryRateLimitedError
+	assert.ErrorAs(core.Recover(context.Background(), "client", key), &rateLimitedErr)
+	core.now = func() time.Time { return time.Now().Add(recoveryBackoffBase) }
+
+	require.NoError(core.Recover(context.Background(), "client", key))
+	assert.FileExists(sealedKeyPath)
+	assert.Nil(core.GetStatus().Recovery)
+}
+
+func TestRecoveryStateTampered(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+	cfg := Config{DataPath: t.TempDir(), RecoveryLockout: 5}
+	core, _ := newCoreWithOsFs(cfg)
+	key := core.masterKey
+	statePath := filepath.Join(cfg.DataPath, PersistenceDir, recoveryStateFilename)
+	keyCheckPath := filepath.Join(cfg.DataPath, PersistenceDir, keyCheckFilename)
+	require.FileExists(statePath)
+	require.NoError(os.Remove(filepath.Join(cfg.DataPath, PersistenceDir, sealedKeyFname)))
+	require.NoError(os.Mkdir(filepath.Join(cfg.DataPath, "#rocksdb"), 0o700))
+	os.Clearenv()
+
+	// without a verifiable recovery key, a modified state delays the next attempt
+	require.NoError(os.WriteFile(statePath, []byte(`{"Failures":0}`), 0o600))
+	core, _ = newCoreWithOsFs(cfg)
+	require.True(core.IsRecovering())
+	var rateLimitedErr *RecoveryRateLimitedError
+	assert.ErrorAs(core.Recover(context.Background(), "client", key), &rateLimitedErr)
+	status := core.GetStatus().Recovery
+	require.NotNil(status)
+	assert.Equal(recoveryUntrustedFailures, status.Failures)
+	assert.False(status.Locked)
+
+	// with a verifiable recovery key, a removed state locks recovery
+	recoveryKeyPEM, _, err := createMockRecoveryKey()
+	require.NoError(err)
+	writeMockRecoveryKey(t, core, recoveryKeyPEM)
+	require.NoError(os.Remove(statePath))
+	core, _ = newCoreWithOsFs(cfg)
+	assert.ErrorIs(core.Recover(context.Background(), "client", key), ErrRecoveryLocked)
+	assert.True(core.GetStatus().Recovery.Locked)
+
+	// a removed key check locks recovery, too
+	require.NoError(os.Remove(keyCheckPath))
+	core, _ = newCoreWithOsFs(cfg)
+	core.recoveryState = &recoveryState{}
+	assert.ErrorIs(core.Recover(context.Background(), "client", key), ErrRecoveryLocked)
+	assert.True(core.IsRecovering())
+}
+
+// writeMockRecoveryKey stores the recovery key like storeRecoveryKey.
+func writeMockRecoveryKey(t *testing.T, core *Core, recoveryKeyPEM string) {
+	key, err := core.rt.GetProductSealKey()
+	require.NoError(t, err)
+	file := recoveryKeyFile{Key: recoveryKeyPEM}
+	file.MAC, err = macJSON(key, file)
+	require.NoError(t, err)
+	data, err := json.Marshal(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(core.cfg.DataPath, PersistenceDir, recoveryKeyFilename), data, 0o600))
+}
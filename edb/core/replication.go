@@ -0,0 +1,484 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/edgelesssys/edgelessdb/edb/util"
+)
+
+// ErrNotAttested is returned if a replica can't prove that it runs the same enclave.
+var ErrNotAttested = errors.New("replica could not be attested")
+
+const (
+	replicationVersion = 1
+	// replicationJoinFilename holds the source of a replica that has joined but whose database hasn't been started yet.
+	replicationJoinFilename = "replication_join.json"
+	// replicationCheckpointDir holds the checkpoint that is sent to a joining replica.
+	replicationCheckpointDir = "replication_checkpoint"
+	replicationJoinAttempts  = 12
+	replicationJoinDelay     = 5 * time.Second
+)
+
+// replicaRequest is sent by a replica to join the primary. The TLS client certificate of the replica is bound to the report.
+type replicaRequest struct {
+	Report            []byte
+	ManifestSignature []byte
+}
+
+// replicaHeader is the first line of the primary's response. The files of a checkpoint follow as a tar stream.
+type replicaHeader struct {
+	Version           int
+	Key               []byte
+	ManifestSignature []byte
+	DatabasePort      string
+	// CA is the PEM-encoded certificate chain of the primary's SQL interface.
+	CA string
+	// GTID is the position in the primary's binary log right after the checkpoint.
+	GTID  string
+	Files map[string]int64
+}
+
+// replicationJoin is the source of a joined replica. It's stored on the host until the database has been started and persisted it.
+type replicationJoin struct {
+	Address string
+	CA      string
+	GTID    string
+	// MAC authenticates the source with the master key.
+	MAC []byte
+}
+
+// AddReplica lets a replica join if its report proves that it runs the same enclave and binds cert, the replica's client certificate.
+// It reads the request of the replica from r and writes the master key and a checkpoint of the database to w.
+func (c *Core) AddReplica(w io.Writer, r io.Reader, cert *x509.Certificate) (err error) {
+	defer func() { c.audit(operationReplica, cert, "", err) }()
+	if !c.cfg.Replication {
+		return errors.New("replication is disabled")
+	}
+	if c.isMarble {
+		return errors.New("replication isn't supported when running as a Marble")
+	}
+	if cert == nil {
+		return fmt.Errorf("%w: no client certificate", ErrNotAttested)
+	}
+	var request replicaRequest
+	if err := json.NewDecoder(r).Decode(&request); err != nil {
+		return fmt.Errorf("parsing request: %w", err)
+	}
+	hash := sha256.Sum256(cert.Raw)
+	if err := c.rt.VerifyPeerReport(request.Report, hash[:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotAttested, err)
+	}
+
+	jsonManifest := c.db.GetManifest()
+	if jsonManifest == nil {
+		return errNotInitialized
+	}
+	signature := sha256.Sum256(jsonManifest)
+	if !bytes.Equal(signature[:], request.ManifestSignature) {
+		return errors.New("replica has a different manifest")
+	}
+
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	dir := filepath.Join(c.cfg.DataPath, PersistenceDir, replicationCheckpointDir)
+	if err := c.fs.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := c.fs.MkdirAll(filepath.Dir(dir), 0o700); err != nil {
+		return err
+	}
+	defer c.fs.RemoveAll(dir)
+	position, err := c.db.CreateCheckpoint(dir)
+	if err != nil {
+		return fmt.Errorf("creating checkpoint: %w", err)
+	}
+	if position.File == "" {
+		return errors.New("binary log is disabled")
+	}
+
+	entries, err := c.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	header := replicaHeader{
+		Version:           replicationVersion,
+		Key:               c.masterKey,
+		ManifestSignature: signature[:],
+		DatabasePort:      c.databasePort(),
+		GTID:              position.GTID,
+		Files:             map[string]int64{},
+	}
+	for _, cert := range c.db.GetCertificateChain() {
+		header.CA += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+	}
+	for _, entry := range entries {
+		header.Files[entry.Name()] = entry.Size()
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(headerJSON, '\n')); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		if err := c.writeTarFile(tw, filepath.Join(dir, entry.Name()), entry.Size()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	c.log.Info("a replica has joined", "gtid", position.GTID)
+	return nil
+}
+
+func (c *Core) writeTarFile(tw *tar.Writer, path string, size int64) error {
+	file, err := c.fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: filepath.Base(path), Mode: 0o600, Size: size}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// Promote stops the replication and makes this replica a writable primary. cert must be the client certificate of an admin.
+func (c *Core) Promote(cert *x509.Certificate) (err error) {
+	var admin string
+	defer func() { c.audit(operationPromote, cert, admin, err) }()
+	_, admin, err = c.authorizeAdmin(cert, operationPromote)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.db.Promote(); err != nil {
+		return err
+	}
+	c.log.Info("promoted the replica to primary", "admin", admin)
+	return nil
+}
+
+// mustJoinPrimary joins the configured primary if the data directory is empty. It returns false if the database already exists.
+func (c *Core) mustJoinPrimary() bool {
+	if c.isMarble {
+		panic(errors.New("replication isn't supported when running as a Marble"))
+	}
+	rocksDBPath := filepath.Join(c.cfg.DataPath, "#rocksdb")
+	if exists, err := c.fs.Exists(rocksDBPath); err != nil {
+		panic(err)
+	} else if exists {
+		return false
+	}
+
+	c.log.Info("joining primary", "primary", c.cfg.ReplicationPrimary)
+	for attempt := 1; ; attempt++ {
+		err := c.joinPrimary(rocksDBPath)
+		if err == nil {
+			break
+		}
+		if err := c.fs.RemoveAll(rocksDBPath); err != nil {
+			panic(err)
+		}
+		if attempt == replicationJoinAttempts {
+			panic(fmt.Errorf("joining primary: %w", err))
+		}
+		c.log.Error("joining primary failed", "error", err)
+		time.Sleep(replicationJoinDelay)
+	}
+	c.log.Info("joined primary, starting as replica")
+	return true
+}
+
+// joinPrimary attests the primary and receives the master key and a checkpoint of the database.
+func (c *Core) joinPrimary(rocksDBPath string) error {
+	jsonManifest, err := c.fs.ReadFile(c.cfg.ManifestFilePath)
+	if err != nil {
+		return err
+	}
+	signature := sha256.Sum256(jsonManifest)
+
+	primaryCert, err := c.attestPrimary()
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(primaryCert)
+
+	// The primary attests this replica by the report on its client certificate.
+	clientCert, clientKey, err := createSelfSignedCertificate()
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(clientCert)
+	report, err := c.rt.GetRemoteReport(hash[:])
+	if err != nil {
+		return fmt.Errorf("getting report: %w", err)
+	}
+	request, err := json.Marshal(replicaRequest{Report: report, ManifestSignature: signature[:]})
+	if err != nil {
+		return err
+	}
+	client := newPrimaryClient(&tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert}, PrivateKey: clientKey}},
+		// The primary is verified against its attested certificate instead of its hostname.
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyCertificateChain(roots),
+	})
+	resp, err := client.Post("https://"+c.cfg.ReplicationPrimary+"/replica", "application/json", bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("primary returned %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	br := bufio.NewReader(resp.Body)
+	headerJSON, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	var header replicaHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("parsing header: %w", err)
+	}
+	if header.Version != replicationVersion {
+		return fmt.Errorf("unsupported replication version: %v", header.Version)
+	}
+	if !bytes.Equal(header.ManifestSignature, signature[:]) {
+		return errors.New("primary has a different manifest")
+	}
+	if len(header.Key) != 16 {
+		return ErrKeyIncorrectSize
+	}
+	if err := c.receiveCheckpoint(tar.NewReader(br), rocksDBPath, header.Files); err != nil {
+		return fmt.Errorf("receiving checkpoint: %w", err)
+	}
+
+	if err := c.storeMasterKey(header.Key); err != nil {
+		return err
+	}
+	c.masterKey = header.Key
+	host, _, err := net.SplitHostPort(c.cfg.ReplicationPrimary)
+	if err != nil {
+		return err
+	}
+	return c.writeReplicationJoin(replicationJoin{Address: net.JoinHostPort(host, header.DatabasePort), CA: header.CA, GTID: header.GTID})
+}
+
+// attestPrimary returns the certificate of the primary after verifying that its report binds the certificate and that it runs the same enclave.
+func (c *Core) attestPrimary() (*x509.Certificate, error) {
+	client := newPrimaryClient(&tls.Config{InsecureSkipVerify: true})
+	resp, err := client.Get("https://" + c.cfg.ReplicationPrimary + "/quote")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var quote struct {
+		Data struct {
+			Cert  string
+			Quote []byte
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&quote); err != nil {
+		return nil, fmt.Errorf("parsing quote: %w", err)
+	}
+	block, _ := pem.Decode([]byte(quote.Data.Cert))
+	if block == nil {
+		return nil, errors.New("primary returned no certificate")
+	}
+	hash := sha256.Sum256(block.Bytes)
+	if err := c.rt.VerifyPeerReport(quote.Data.Quote, hash[:]); err != nil {
+		return nil, fmt.Errorf("attesting primary: %w", err)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// receiveCheckpoint extracts the files of a checkpoint to the empty RocksDB directory.
+func (c *Core) receiveCheckpoint(tr *tar.Reader, rocksDBPath string, files map[string]int64) error {
+	if err := c.fs.MkdirAll(rocksDBPath, 0o700); err != nil {
+		return err
+	}
+	received := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		size, ok := files[header.Name]
+		if !ok || size != header.Size || !isPlainFilename(header.Name) {
+			return fmt.Errorf("unexpected file: %v", header.Name)
+		}
+		file, err := c.fs.OpenFile(filepath.Join(rocksDBPath, header.Name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, tr); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		received++
+	}
+	if received != len(files) {
+		return fmt.Errorf("received %v of %v files", received, len(files))
+	}
+	return nil
+}
+
+func (c *Core) writeReplicationJoin(join replicationJoin) error {
+	var err error
+	if join.MAC, err = macJSON(c.masterKey, join); err != nil {
+		return err
+	}
+	data, err := json.Marshal(join)
+	if err != nil {
+		return err
+	}
+	return c.fs.WriteFile(filepath.Join(c.cfg.DataPath, PersistenceDir, replicationJoinFilename), data, 0o600)
+}
+
+// setReplicationSource passes the source of a joined replica to the database. It returns false if the replica has already been started.
+func (c *Core) setReplicationSource() (bool, error) {
+	data, err := c.fs.ReadFile(filepath.Join(c.cfg.DataPath, PersistenceDir, replicationJoinFilename))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var join replicationJoin
+	if err := json.Unmarshal(data, &join); err != nil {
+		return false, err
+	}
+	if err := join.verify(c.masterKey); err != nil {
+		return false, err
+	}
+	c.db.SetReplicationSource(db.ReplicationSource{Address: join.Address, CA: []byte(join.CA), Password: c.replicationPassword(), GTID: join.GTID})
+	return true, nil
+}
+
+// replicationPassword derives the password that replicas authenticate with from the master key.
+func (c *Core) replicationPassword() string {
+	mac := hmac.New(sha256.New, c.masterKey)
+	mac.Write([]byte("edb replication"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// databasePort returns the port of the SQL interface.
+func (c *Core) databasePort() string {
+	for _, address := range c.cfg.DatabaseAddresses {
+		if _, port, err := net.SplitHostPort(address); err == nil && port != "" {
+			return port
+		}
+	}
+	return "3306"
+}
+
+func (j replicationJoin) verify(key []byte) error {
+	mac := j.MAC
+	j.MAC = nil
+	if !validMACJSON(key, j, mac) {
+		return errors.New("replication source has not been created with this key")
+	}
+	return nil
+}
+
+func newPrimaryClient(config *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: 10 * time.Minute}
+}
+
+// verifyCertificateChain verifies the peer's certificate chain against roots, ignoring the hostname.
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate")
+		}
+		intermediates := x509.NewCertPool()
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
+
+// createSelfSignedCertificate creates the client certificate that a replica presents when joining.
+func createSelfSignedCertificate() ([]byte, *ecdsa.PrivateKey, error) {
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{Organization: []string{"EDB replica"}},
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
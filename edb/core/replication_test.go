@@ -0,0 +1,162 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaJoin(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	jsonManifest, err := json.Marshal(map[string]interface{}{"admins": map[string]string{"alice": adminCertPEM}})
+	require.NoError(err)
+
+	primary, primaryDB := newCoreWithOsFs(Config{DataPath: t.TempDir(), DatabaseAddresses: []string{"0.0.0.0:3307"}, Replication: true})
+	require.NoError(primary.StartDatabase())
+	_, err = primary.Initialize(jsonManifest)
+	require.NoError(err)
+	assert.Equal(primary.replicationPassword(), primaryDB.ReplicationPassword)
+	primaryDB.CheckpointFiles = map[string][]byte{"CURRENT": []byte("MANIFEST-1"), "MANIFEST-1": {1}, "000001.sst": {2, 2}}
+	primaryDB.CurrentBinlog = "binlog.000002"
+	primaryDB.CheckpointGTID = "0-1-5"
+
+	// serve the endpoints used by the replica like the API server does
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote", func(w http.ResponseWriter, r *http.Request) {
+		cert, report, err := primary.GetCertificateReport()
+		require.NoError(err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": map[string]interface{}{"Cert": cert, "Quote": report}})
+	})
+	mux.HandleFunc("/replica", func(w http.ResponseWriter, r *http.Request) {
+		if err := primary.AddReplica(w, r.Body, r.TLS.PeerCertificates[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = primary.GetTLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(os.WriteFile(manifestPath, jsonManifest, 0o600))
+	dataPath := t.TempDir()
+	os.Clearenv()
+	replica, replicaDB := newCoreWithOsFs(Config{DataPath: dataPath, ManifestFilePath: manifestPath, ReplicationPrimary: server.Listener.Addr().String()})
+	assert.Equal(primary.masterKey, replica.masterKey)
+	for name, data := range primaryDB.CheckpointFiles {
+		received, err := os.ReadFile(filepath.Join(dataPath, "#rocksdb", name))
+		require.NoError(err)
+		assert.Equal(data, received)
+	}
+	assert.NoDirExists(filepath.Join(primary.cfg.DataPath, PersistenceDir, replicationCheckpointDir))
+
+	require.NoError(replica.StartDatabase())
+	require.NotNil(replicaDB.ReplicationSource)
+	assert.Equal("127.0.0.1:3307", replicaDB.ReplicationSource.Address)
+	assert.Equal("0-1-5", replicaDB.ReplicationSource.GTID)
+	assert.Equal(primaryDB.ReplicationPassword, replicaDB.ReplicationSource.Password)
+	assert.NotEmpty(replicaDB.ReplicationSource.CA)
+	assert.NoFileExists(filepath.Join(dataPath, PersistenceDir, replicationJoinFilename))
+	assert.Equal(db.RoleReplica, replica.GetStatus().Replication.Role)
+
+	// the replica's database contains the manifest of the primary
+	require.NoError(replicaDB.Initialize(jsonManifest))
+	assert.ErrorIs(replica.Promote(nil), ErrNotAdmin)
+	require.NoError(replica.Promote(adminCert))
+	assert.Nil(replicaDB.ReplicationSource)
+	assert.Equal(db.RolePrimary, replica.GetStatus().Replication.Role)
+}
+
+func TestAddReplica(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+
+	jsonManifest := []byte(`{"sql":["CREATE USER test"]}`)
+	signature := sha256.Sum256(jsonManifest)
+	cert, _ := createMockClientCertificate(t)
+	validRequest, err := json.Marshal(replicaRequest{Report: []byte{2, 3, 4}, ManifestSignature: signature[:]})
+	require.NoError(t, err)
+	otherManifest, err := json.Marshal(replicaRequest{Report: []byte{2, 3, 4}, ManifestSignature: make([]byte, 32)})
+	require.NoError(t, err)
+	invalidReport, err := json.Marshal(replicaRequest{Report: []byte{1}, ManifestSignature: signature[:]})
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		disabled    bool
+		request     []byte
+		noCert      bool
+		noBinlog    bool
+		wantErr     bool
+		notAttested bool
+	}{
+		"valid":                 {request: validRequest},
+		"replication disabled":  {disabled: true, request: validRequest, wantErr: true},
+		"no client certificate": {request: validRequest, noCert: true, wantErr: true, notAttested: true},
+		"invalid report":        {request: invalidReport, wantErr: true, notAttested: true},
+		"other manifest":        {request: otherManifest, wantErr: true},
+		"binary log disabled":   {request: validRequest, noBinlog: true, wantErr: true},
+		"invalid request":       {request: []byte("{"), wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			core, mockDB := newCoreWithOsFs(Config{DataPath: t.TempDir(), Replication: !tc.disabled})
+			require.NoError(core.StartDatabase())
+			_, err := core.Initialize(jsonManifest)
+			require.NoError(err)
+			mockDB.CheckpointFiles = map[string][]byte{"CURRENT": []byte("MANIFEST-1")}
+			if !tc.noBinlog {
+				mockDB.CurrentBinlog = "binlog.000001"
+			}
+			clientCert := cert
+			if tc.noCert {
+				clientCert = nil
+			}
+
+			var out bytes.Buffer
+			err = core.AddReplica(&out, bytes.NewReader(tc.request), clientCert)
+			if !tc.wantErr {
+				assert.NoError(err)
+				assert.Contains(out.String(), "MANIFEST-1")
+				return
+			}
+			assert.Error(err)
+			assert.Equal(tc.notAttested, errors.Is(err, ErrNotAttested))
+			assert.Zero(out.Len())
+		})
+	}
+}
@@ -0,0 +1,347 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotVersion          = 1
+	snapshotMetadataFilename = "snapshot.json"
+	snapshotDataDir          = "rocksdb"
+	snapshotNameFormat       = "20060102-150405"
+	// snapshotCheckpointDir is the temporary RocksDB checkpoint in the persistence dir.
+	// It's on the file system of the database, so RocksDB hard-links the SST files instead of copying them.
+	snapshotCheckpointDir = "checkpoint"
+	// restoredSnapshotFilename is stored in the persistence dir until the master key of a restored snapshot has been provided.
+	restoredSnapshotFilename = "restored_snapshot.json"
+)
+
+// snapshotMetadata describes a physical snapshot. It's stored next to the RocksDB checkpoint.
+type snapshotMetadata struct {
+	Version           int
+	Name              string
+	Created           time.Time
+	Manifest          []byte
+	ManifestSignature []byte
+	// Key is the master key encrypted with the recovery key of the manifest.
+	Key []byte
+	// Files lists the files of the checkpoint. The SST files of an incremental snapshot may be stored in older snapshots.
+	Files map[string]snapshotFile
+	// Binlog is the binary log that starts right after the snapshot. It's nil if the binary log is disabled.
+	Binlog *snapshotBinlog `json:",omitempty"`
+	// MAC authenticates the metadata with the master key.
+	MAC []byte
+}
+
+type snapshotBinlog struct {
+	Timeline string
+	File     string
+}
+
+type snapshotFile struct {
+	Size int64
+	// Snapshot is the name of the snapshot that stores the file.
+	Snapshot string
+}
+
+// Snapshot creates a physical snapshot of the database in the snapshot directory and returns its name. cert must be the client certificate of an admin.
+// SST files are immutable, so those that are unchanged since the previous snapshot aren't stored again.
+func (c *Core) Snapshot(cert *x509.Certificate) (name string, err error) {
+	var admin string
+	defer func() { c.audit(operationSnapshot, cert, admin, err) }()
+	if c.cfg.SnapshotDir == "" {
+		return "", errors.New("no snapshot directory has been configured")
+	}
+	man, admin, err := c.authorizeAdmin(cert, operationSnapshot)
+	if err != nil {
+		return "", err
+	}
+	// The snapshot can only be restored on another host if the master key can be recovered.
+	if man.Recovery == "" {
+		return "", errors.New("the manifest doesn't declare a recovery key")
+	}
+	wrappedKey, err := c.encryptRecoveryKey(c.masterKey, man.Recovery)
+	if err != nil {
+		return "", err
+	}
+
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+
+	now := c.now().UTC()
+	name = now.Format(snapshotNameFormat)
+	dir := filepath.Join(c.cfg.SnapshotDir, name)
+	if exists, err := c.fs.Exists(dir); err != nil {
+		return "", err
+	} else if exists {
+		return "", fmt.Errorf("snapshot %v already exists", name)
+	}
+	base, err := c.latestSnapshot()
+	if err != nil {
+		return "", err
+	}
+
+	c.log.Info("creating snapshot", "snapshot", name, "admin", admin)
+	if err := c.fs.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			c.fs.RemoveAll(dir)
+		}
+	}()
+
+	checkpointDir := filepath.Join(c.cfg.DataPath, PersistenceDir, snapshotCheckpointDir)
+	// a previous snapshot may have been interrupted
+	if err := c.fs.RemoveAll(checkpointDir); err != nil {
+		return "", err
+	}
+	binlog, err := c.db.CreateCheckpoint(checkpointDir)
+	if err != nil {
+		return "", fmt.Errorf("creating checkpoint: %w", err)
+	}
+	defer c.fs.RemoveAll(checkpointDir)
+	files, err := c.copyCheckpoint(name, checkpointDir, filepath.Join(dir, snapshotDataDir), base)
+	if err != nil {
+		return "", err
+	}
+
+	signature := sha256.Sum256(man.raw)
+	metadata := snapshotMetadata{
+		Version:           snapshotVersion,
+		Name:              name,
+		Created:           now,
+		Manifest:          man.raw,
+		ManifestSignature: signature[:],
+		Key:               wrappedKey,
+		Files:             files,
+	}
+	if binlog.File != "" {
+		timeline, _ := c.db.GetBinlogTimeline()
+		metadata.Binlog = &snapshotBinlog{Timeline: timeline, File: binlog.File}
+	}
+	if metadata.MAC, err = macJSON(c.masterKey, metadata); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	if err := c.fs.WriteFile(filepath.Join(dir, snapshotMetadataFilename), data, 0o600); err != nil {
+		return "", err
+	}
+
+	if base != nil {
+		c.log.Info("snapshot completed", "snapshot", name, "base", base.Name)
+	} else {
+		c.log.Info("snapshot completed", "snapshot", name)
+	}
+	return name, nil
+}
+
+// copyCheckpoint copies the files of the checkpoint to dataDir, except for the SST files that are already stored by the base snapshot.
+func (c *Core) copyCheckpoint(name, checkpointDir, dataDir string, base *snapshotMetadata) (map[string]snapshotFile, error) {
+	entries, err := c.fs.ReadDir(checkpointDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.fs.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+	files := make(map[string]snapshotFile, len(entries))
+	for _, entry := range entries {
+		file := snapshotFile{Size: entry.Size(), Snapshot: name}
+		if base != nil && strings.HasSuffix(entry.Name(), ".sst") {
+			if baseFile, ok := base.Files[entry.Name()]; ok && baseFile.Size == file.Size {
+				file.Snapshot = baseFile.Snapshot
+				files[entry.Name()] = file
+				continue
+			}
+		}
+		if err := c.copyFile(filepath.Join(checkpointDir, entry.Name()), filepath.Join(dataDir, entry.Name()), file.Size); err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = file
+	}
+	return files, nil
+}
+
+// latestSnapshot returns the metadata of the latest snapshot of this database, or nil if there is none.
+func (c *Core) latestSnapshot() (*snapshotMetadata, error) {
+	entries, err := c.fs.ReadDir(c.cfg.SnapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		metadata, err := c.readSnapshotMetadata(filepath.Join(c.cfg.SnapshotDir, entry.Name(), snapshotMetadataFilename))
+		if err != nil {
+			continue
+		}
+		// Snapshots of other databases can't be the base of an incremental snapshot.
+		if metadata.verify(c.masterKey) == nil {
+			return metadata, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Core) readSnapshotMetadata(path string) (*snapshotMetadata, error) {
+	data, err := c.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var metadata snapshotMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("parsing snapshot metadata: %w", err)
+	}
+	if metadata.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %v", metadata.Version)
+	}
+	return &metadata, nil
+}
+
+// mustRestoreSnapshot copies the files of the configured snapshot into an empty data directory.
+// It returns true if a restored snapshot awaits its master key.
+func (c *Core) mustRestoreSnapshot() bool {
+	if c.isMarble {
+		panic(errors.New("restoring snapshots isn't supported when running as a Marble"))
+	}
+
+	restoredPath := filepath.Join(c.cfg.DataPath, PersistenceDir, restoredSnapshotFilename)
+	if exists, err := c.fs.Exists(restoredPath); err != nil {
+		panic(err)
+	} else if exists {
+		return true
+	}
+	rocksDBPath := filepath.Join(c.cfg.DataPath, "#rocksdb")
+	if exists, err := c.fs.Exists(rocksDBPath); err != nil {
+		panic(err)
+	} else if exists {
+		c.log.Info("data directory already contains a database, not restoring snapshot", "snapshot", c.cfg.RestoreSnapshot)
+		return false
+	}
+
+	metadata, err := c.readSnapshotMetadata(filepath.Join(c.cfg.SnapshotDir, c.cfg.RestoreSnapshot, snapshotMetadataFilename))
+	if err != nil {
+		panic(fmt.Errorf("restoring snapshot %v: %w", c.cfg.RestoreSnapshot, err))
+	}
+	c.log.Info("restoring snapshot", "snapshot", metadata.Name)
+	if err := c.copySnapshotFiles(metadata, rocksDBPath); err != nil {
+		c.fs.RemoveAll(rocksDBPath)
+		panic(fmt.Errorf("restoring snapshot %v: %w", metadata.Name, err))
+	}
+
+	// The master key is verified against the MAC of the metadata during recovery.
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		panic(err)
+	}
+	if err := c.fs.MkdirAll(filepath.Dir(restoredPath), 0o700); err != nil {
+		panic(err)
+	}
+	if err := c.fs.WriteFile(restoredPath, data, 0o600); err != nil {
+		panic(err)
+	}
+	// The new data directory starts without failed recovery attempts.
+	if err := c.initRecoveryState(); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+func (c *Core) copySnapshotFiles(metadata *snapshotMetadata, rocksDBPath string) error {
+	if err := c.fs.MkdirAll(rocksDBPath, 0o700); err != nil {
+		return err
+	}
+	for name, file := range metadata.Files {
+		if !isPlainFilename(name) || !isPlainFilename(file.Snapshot) {
+			return fmt.Errorf("invalid file in snapshot metadata: %v/%v", file.Snapshot, name)
+		}
+		src := filepath.Join(c.cfg.SnapshotDir, file.Snapshot, snapshotDataDir, name)
+		if err := c.copyFile(src, filepath.Join(rocksDBPath, name), file.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Core) copyFile(src, dst string, size int64) error {
+	in, err := c.fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := c.fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if n != size {
+		out.Close()
+		return fmt.Errorf("%v has %v bytes, expected %v", src, n, size)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// verifyRestoredSnapshotKey checks that key is the master key of a restored snapshot. It returns false if no snapshot has been restored.
+func (c *Core) verifyRestoredSnapshotKey(key []byte) (bool, error) {
+	metadata, err := c.readSnapshotMetadata(filepath.Join(c.cfg.DataPath, PersistenceDir, restoredSnapshotFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := metadata.verify(key); err != nil {
+		return false, fmt.Errorf("the key doesn't belong to the restored snapshot %v", metadata.Name)
+	}
+	return true, nil
+}
+
+func (m snapshotMetadata) verify(key []byte) error {
+	mac := m.MAC
+	m.MAC = nil
+	if !validMACJSON(key, m, mac) {
+		return errors.New("snapshot metadata has not been created with this key")
+	}
+	return nil
+}
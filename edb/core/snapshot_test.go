@@ -0,0 +1,121 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/edgelessdb/edb/db"
+	"github.com/edgelesssys/edgelessdb/edb/rt"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Clearenv()
+	defer os.Clearenv()
+
+	recoveryKeyPEM, recoveryKey, err := createMockRecoveryKey()
+	require.NoError(err)
+	adminCert, adminCertPEM := createMockClientCertificate(t)
+	jsonManifest, err := json.Marshal(map[string]interface{}{"recovery": recoveryKeyPEM, "admins": map[string]string{"alice": adminCertPEM}})
+	require.NoError(err)
+	snapshotDir := t.TempDir()
+
+	core, mockDB := newCoreWithOsFs(Config{DataPath: t.TempDir(), SnapshotDir: snapshotDir})
+	require.NoError(core.StartDatabase())
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(err)
+
+	_, err = core.Snapshot(nil)
+	assert.ErrorIs(err, ErrNotAdmin)
+
+	mockDB.CheckpointFiles = map[string][]byte{"CURRENT": []byte("MANIFEST-1"), "MANIFEST-1": {1}, "000001.sst": {2, 2}, "000002.sst": {3}}
+	base, err := core.Snapshot(adminCert)
+	require.NoError(err)
+
+	// snapshot names have a resolution of seconds
+	core.now = func() time.Time { return time.Now().Add(time.Second) }
+
+	// the second snapshot only stores the new and changed SST files
+	mockDB.CheckpointFiles = map[string][]byte{"CURRENT": []byte("MANIFEST-2"), "MANIFEST-2": {1}, "000001.sst": {2, 2}, "000002.sst": {3, 3}, "000003.sst": {4}}
+	name, err := core.Snapshot(adminCert)
+	require.NoError(err)
+	metadata, err := core.readSnapshotMetadata(filepath.Join(snapshotDir, name, snapshotMetadataFilename))
+	require.NoError(err)
+	assert.Equal(base, metadata.Files["000001.sst"].Snapshot)
+	assert.Equal(name, metadata.Files["000002.sst"].Snapshot)
+	assert.Equal(name, metadata.Files["000003.sst"].Snapshot)
+	assert.Equal(name, metadata.Files["CURRENT"].Snapshot)
+	assert.NoFileExists(filepath.Join(snapshotDir, name, snapshotDataDir, "000001.sst"))
+	assert.FileExists(filepath.Join(snapshotDir, name, snapshotDataDir, "000002.sst"))
+	// the checkpoint in the data directory is removed
+	assert.NoDirExists(filepath.Join(core.cfg.DataPath, PersistenceDir, snapshotCheckpointDir))
+
+	// restore on a new host
+	os.Clearenv()
+	dataPath := t.TempDir()
+	restoreConfig := Config{DataPath: dataPath, SnapshotDir: snapshotDir, RestoreSnapshot: name}
+	newCore, _ := newCoreWithOsFs(restoreConfig)
+	assert.True(newCore.IsRecovering())
+	for file, want := range mockDB.CheckpointFiles {
+		data, err := os.ReadFile(filepath.Join(dataPath, "#rocksdb", file))
+		require.NoError(err)
+		assert.Equal(want, data)
+	}
+
+	// the restored snapshot still awaits its key after a restart
+	newCore, _ = newCoreWithOsFs(restoreConfig)
+	assert.True(newCore.IsRecovering())
+
+	assert.Error(newCore.Recover(context.Background(), "client", make([]byte, 16)))
+	assert.True(newCore.IsRecovering())
+	// skip the backoff after the failed attempt
+	newCore.now = func() time.Time { return time.Now().Add(recoveryBackoffBase) }
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, recoveryKey, metadata.Key, nil)
+	require.NoError(err)
+	require.NoError(newCore.Recover(context.Background(), "client", key))
+	assert.NoFileExists(filepath.Join(dataPath, PersistenceDir, restoredSnapshotFilename))
+
+	// an existing database isn't overwritten
+	os.Clearenv()
+	newCore, _ = newCoreWithOsFs(restoreConfig)
+	assert.False(newCore.IsRecovering())
+}
+
+func TestSnapshotRequiresConfig(t *testing.T) {
+	core, _ := newCoreWithMocks()
+	_, err := core.Snapshot(nil)
+	assert.Error(t, err)
+}
+
+func newCoreWithOsFs(cfg Config) (*Core, *db.DatabaseMock) {
+	db := &db.DatabaseMock{}
+	return NewCore(cfg, &rt.RuntimeMock{}, db, afero.Afero{Fs: afero.NewOsFs()}, false), db
+}
@@ -0,0 +1,202 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// crashStateFname is the file in the persistence dir that keeps track of crashes across restarts of the host process
+	crashStateFname = "crashes.json"
+	// restartBackoffBase is the delay before the first restart after a crash. It doubles with each consecutive crash.
+	restartBackoffBase = time.Second
+	// restartBackoffMax is the maximum delay before a restart.
+	restartBackoffMax = 5 * time.Minute
+	// crashLoopThreshold is the number of consecutive crashes after which EDB reports a crash loop.
+	crashLoopThreshold = 5
+	// crashResetDelay is the time the database must be running before previous crashes are forgotten.
+	crashResetDelay = 10 * time.Minute
+)
+
+// SupervisorStatus describes the crashes of the database that the supervisor has handled.
+type SupervisorStatus struct {
+	Restarts    int       // consecutive restarts after crashes
+	LastCrash   time.Time `json:",omitempty"`
+	LastReason  string    `json:",omitempty"`
+	CrashLoop   bool      // the database keeps crashing
+	NextRestart time.Time `json:",omitempty"` // set while waiting for a restart
+}
+
+type supervisor struct {
+	mutex  sync.Mutex
+	status SupervisorStatus
+	sleep  func(time.Duration) // waits for the restart backoff, replaced by tests
+}
+
+// crashState is stored on the untrusted host and authenticated with the product seal key.
+type crashState struct {
+	Status SupervisorStatus
+	MAC    []byte
+}
+
+// HandleCrash is called when the database has exited unexpectedly. The reason classifies the crash.
+// If supervision is enabled and restarting may help, it restarts the host process after a backoff and doesn't return.
+// Otherwise it returns and the caller should exit.
+func (c *Core) HandleCrash(reason string, restartable bool) {
+	if c.supervisor == nil {
+		return
+	}
+	if !restartable {
+		c.log.Error("database crashed, not restarting because a restart won't help", "reason", reason)
+		return
+	}
+
+	s := c.supervisor
+	s.mutex.Lock()
+	now := time.Now()
+	s.status.Restarts++
+	s.status.LastCrash = now
+	s.status.LastReason = reason
+	s.status.CrashLoop = s.status.Restarts >= crashLoopThreshold
+	delay := restartDelay(s.status.Restarts)
+	s.status.NextRestart = now.Add(delay)
+	status := s.status
+	s.mutex.Unlock()
+
+	if err := c.saveCrashState(status); err != nil {
+		c.log.Error("failed to save crash state", "error", err)
+	}
+	if status.CrashLoop {
+		c.log.Error("database is crash looping", "restarts", status.Restarts)
+	}
+	c.log.Error("database crashed, restarting", "reason", reason, "delay", delay)
+
+	// The API server keeps running in the meantime and reports the status.
+	s.sleep(delay)
+	c.rt.RestartHostProcess()
+}
+
+// getSupervisorStatus returns nil if supervision is disabled.
+func (c *Core) getSupervisorStatus() *SupervisorStatus {
+	if c.supervisor == nil {
+		return nil
+	}
+	c.supervisor.mutex.Lock()
+	defer c.supervisor.mutex.Unlock()
+	status := c.supervisor.status
+	return &status
+}
+
+// initSupervisor loads the crash state of the previous runs.
+func (c *Core) initSupervisor() {
+	c.supervisor = &supervisor{sleep: time.Sleep}
+	status, err := c.loadCrashState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return
+		}
+		// Don't let the host hide a crash loop by tampering with the state.
+		c.log.Error("failed to load crash state, assuming a crash loop", "error", err)
+		status = SupervisorStatus{Restarts: crashLoopThreshold, LastReason: "invalid-crash-state", CrashLoop: true}
+	}
+	status.NextRestart = time.Time{}
+	c.supervisor.status = status
+}
+
+// resetCrashStateWhenStable forgets previous crashes once the database has been running for a while.
+func (c *Core) resetCrashStateWhenStable() {
+	if c.supervisor == nil {
+		return
+	}
+	time.AfterFunc(crashResetDelay, func() {
+		s := c.supervisor
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.status.Restarts == 0 || !s.status.NextRestart.IsZero() {
+			return
+		}
+		s.status = SupervisorStatus{}
+		if err := c.fs.Remove(c.crashStatePath()); err != nil && !os.IsNotExist(err) {
+			c.log.Error("failed to remove crash state", "error", err)
+		}
+	})
+}
+
+func (c *Core) saveCrashState(status SupervisorStatus) error {
+	key, err := c.rt.GetProductSealKey()
+	if err != nil {
+		return err
+	}
+	state := crashState{Status: status}
+	if state.MAC, err = macJSON(key, state); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return c.fs.WriteFile(c.crashStatePath(), data, 0o600)
+}
+
+func (c *Core) loadCrashState() (SupervisorStatus, error) {
+	data, err := c.fs.ReadFile(c.crashStatePath())
+	if err != nil {
+		return SupervisorStatus{}, err
+	}
+	var state crashState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return SupervisorStatus{}, err
+	}
+	key, err := c.rt.GetProductSealKey()
+	if err != nil {
+		return SupervisorStatus{}, err
+	}
+	if err := state.verify(key); err != nil {
+		return SupervisorStatus{}, err
+	}
+	return state.Status, nil
+}
+
+func (c *Core) crashStatePath() string {
+	return filepath.Join(c.cfg.DataPath, PersistenceDir, crashStateFname)
+}
+
+// restartDelay returns the backoff before the given consecutive restart.
+func restartDelay(restarts int) time.Duration {
+	delay := restartBackoffBase
+	for i := 1; i < restarts && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	return delay
+}
+
+func (s crashState) verify(key []byte) error {
+	mac := s.MAC
+	s.MAC = nil
+	if !validMACJSON(key, s, mac) {
+		return errors.New("crash state has been modified")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Second, restartDelay(1))
+	assert.Equal(2*time.Second, restartDelay(2))
+	assert.Equal(4*time.Second, restartDelay(3))
+	assert.Equal(256*time.Second, restartDelay(9))
+	assert.Equal(restartBackoffMax, restartDelay(10))
+	assert.Equal(restartBackoffMax, restartDelay(1000))
+}
+
+func TestSupervisorDisabled(t *testing.T) {
+	core, _ := newCoreWithMocks()
+	core.HandleCrash("unknown", true) // returns immediately
+	assert.Nil(t, core.GetStatus().Supervisor)
+}
+
+func TestSupervisorCrashState(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	require.NoError(core.fs.MkdirAll(filepath.Join(core.cfg.DataPath, PersistenceDir), 0o700))
+	core.initSupervisor()
+	assert.Equal(&SupervisorStatus{}, core.GetStatus().Supervisor)
+
+	// not restartable: no restart and no state change
+	core.HandleCrash("wrong-key-or-corruption", false)
+	assert.Zero(core.GetStatus().Supervisor.Restarts)
+
+	// state of a previous run is loaded after a restart
+	lastCrash := time.Now().Round(0)
+	require.NoError(core.saveCrashState(SupervisorStatus{Restarts: 5, LastCrash: lastCrash, LastReason: "port-in-use", CrashLoop: true, NextRestart: lastCrash.Add(time.Minute)}))
+	core.initSupervisor()
+	status := core.GetStatus().Supervisor
+	assert.Equal(5, status.Restarts)
+	assert.True(lastCrash.Equal(status.LastCrash))
+	assert.Equal("port-in-use", status.LastReason)
+	assert.True(status.CrashLoop)
+	assert.Zero(status.NextRestart)
+}
+
+func TestSupervisorHandleCrash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	require.NoError(core.fs.MkdirAll(filepath.Join(core.cfg.DataPath, PersistenceDir), 0o700))
+	core.initSupervisor()
+	var delays []time.Duration
+	core.supervisor.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	// the mock's RestartHostProcess returns after the backoff
+	core.HandleCrash("port-in-use", true)
+	core.HandleCrash("port-in-use", true)
+	assert.Equal([]time.Duration{time.Second, 2 * time.Second}, delays)
+	status := core.GetStatus().Supervisor
+	assert.Equal(2, status.Restarts)
+	assert.Equal("port-in-use", status.LastReason)
+	assert.False(status.CrashLoop)
+
+	// the restarted process continues counting
+	core.initSupervisor()
+	assert.Equal(2, core.GetStatus().Supervisor.Restarts)
+}
+
+func TestSupervisorTamperedCrashState(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _ := newCoreWithMocks()
+	fs := core.fs
+	require.NoError(fs.MkdirAll(filepath.Join(core.cfg.DataPath, PersistenceDir), 0o700))
+	require.NoError(core.saveCrashState(SupervisorStatus{Restarts: 7, CrashLoop: true}))
+
+	// the host resets the counter
+	data, err := fs.ReadFile(core.crashStatePath())
+	require.NoError(err)
+	data = bytes.Replace(data, []byte(`"Restarts":7`), []byte(`"Restarts":0`), 1)
+	data = bytes.Replace(data, []byte(`"CrashLoop":true`), []byte(`"CrashLoop":false`), 1)
+	require.NoError(fs.WriteFile(core.crashStatePath(), data, 0o600))
+
+	core.initSupervisor()
+	status := core.GetStatus().Supervisor
+	assert.Equal(crashLoopThreshold, status.Restarts)
+	assert.True(status.CrashLoop)
+	assert.Equal("invalid-crash-state", status.LastReason)
+
+	// a missing state means no crashes
+	require.NoError(fs.Remove(core.crashStatePath()))
+	core.initSupervisor()
+	assert.Equal(&SupervisorStatus{}, core.GetStatus().Supervisor)
+}
@@ -0,0 +1,46 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// macJSON returns the HMAC-SHA256 of the JSON encoding of v. If v holds its own MAC, the field must be unset.
+func macJSON(key []byte, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// validMACJSON reports whether mac is the MAC of v as returned by macJSON.
+func validMACJSON(key []byte, v interface{}, mac []byte) bool {
+	expected, err := macJSON(key, v)
+	return err == nil && hmac.Equal(expected, mac)
+}
+
+// isPlainFilename reports whether name refers to a visible entry of a directory, i.e., it's neither a path nor hidden.
+func isPlainFilename(name string) bool {
+	return filepath.Base(name) == name && !strings.HasPrefix(name, ".")
+}
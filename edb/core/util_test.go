@@ -0,0 +1,49 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMACJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key := []byte{2, 3, 4}
+	value := struct{ Name string }{"foo"}
+	mac, err := macJSON(key, value)
+	require.NoError(err)
+
+	assert.True(validMACJSON(key, value, mac))
+	assert.False(validMACJSON([]byte{2, 3, 5}, value, mac))
+	assert.False(validMACJSON(key, struct{ Name string }{"bar"}, mac))
+	assert.False(validMACJSON(key, value, nil))
+	assert.False(validMACJSON(key, make(chan int), mac))
+}
+
+func TestIsPlainFilename(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isPlainFilename("20221018-120000"))
+	assert.True(isPlainFilename("000001.sst"))
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "../a", "/a", "a/"} {
+		assert.False(isPlainFilename(name), name)
+	}
+}
@@ -0,0 +1,65 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// AuditEntry is a signed entry of the audit log.
+type AuditEntry struct {
+	Content   []byte
+	Signature []byte
+}
+
+// AppendAuditEntry appends an entry to the audit log.
+func (d *Mariadb) AppendAuditEntry(entry AuditEntry) error {
+	return d.execInternal("INSERT INTO $edgeless.audit (content, signature) VALUES (?, ?)", entry.Content, entry.Signature)
+}
+
+// GetAuditEntries returns the entries of the audit log, oldest first.
+func (d *Mariadb) GetAuditEntries() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(context.Background(), "SELECT content, signature FROM $edgeless.audit ORDER BY seq")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var entry AuditEntry
+			if err := rows.Scan(&entry.Content, &entry.Signature); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return rows.Err()
+	})
+	return entries, err
+}
+
+// GetLastAuditEntry returns the newest entry of the audit log. Content is nil if the log is empty.
+func (d *Mariadb) GetLastAuditEntry() (AuditEntry, error) {
+	var entry AuditEntry
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT content, signature FROM $edgeless.audit ORDER BY seq DESC LIMIT 1").Scan(&entry.Content, &entry.Signature)
+	})
+	if err == sql.ErrNoRows {
+		return AuditEntry{}, nil
+	}
+	return entry, err
+}
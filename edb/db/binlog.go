@@ -0,0 +1,615 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filenameBinlog is the basename of the binary logs. They're written to the internal path, i.e., to enclave memory.
+const filenameBinlog = "binlog"
+
+// binlogMagic starts each binary log file.
+const binlogMagic = "\xfebin"
+
+// binlog event types, see https://mariadb.com/kb/en/2-binlog-event-header/
+const (
+	binlogQueryEvent             = 2
+	binlogStopEvent              = 3
+	binlogRotateEvent            = 4
+	binlogFormatDescriptionEvent = 15
+	binlogXIDEvent               = 16
+	binlogTableMapEvent          = 19
+	binlogHeartbeatEvent         = 27
+	binlogIgnorableEvent         = 28
+	binlogRowsQueryEvent         = 29
+	binlogAnnotateRowsEvent      = 160
+	binlogCheckpointEvent        = 161
+	binlogGTIDEvent              = 162
+	binlogGTIDListEvent          = 163
+)
+
+// status variables of query events, see https://mariadb.com/kb/en/query_event/
+const (
+	queryFlags2             = 0
+	querySQLMode            = 1
+	queryCatalog            = 2
+	queryAutoIncrement      = 3
+	queryCharset            = 4
+	queryTimeZone           = 5
+	queryCatalogNZ          = 6
+	queryLCTimeNames        = 7
+	queryCharsetDatabase    = 8
+	queryTableMapForUpdate  = 9
+	queryMasterDataWritten  = 10
+	queryInvoker            = 11
+	queryUpdatedDBNames     = 12
+	queryMicroseconds       = 13
+	queryHRNow              = 128
+	queryXID                = 129
+	queryGTIDFlags3         = 130
+	queryOverMaxDBsInEvent  = 254 // number of updated databases if there are too many to be listed
+	optionAutoIsNull        = 1 << 14
+	optionNoForeignKeyCheck = 1 << 26
+	optionRelaxedUnique     = 1 << 27
+)
+
+const (
+	binlogHeaderSize     = 19
+	binlogIgnorableFlag  = 0x80 // LOG_EVENT_IGNORABLE_F
+	binlogStmtEndFlag    = 1    // STMT_END_F of rows events
+	binlogStandaloneFlag = 1    // FL_STANDALONE of GTID events, i.e., the event group isn't a transaction
+)
+
+// EnableBinlog makes MariaDB write binary logs to enclave memory, from where they can be shipped encrypted.
+// It must be called before the database is started.
+func (d *Mariadb) EnableBinlog() {
+	d.binlog = true
+}
+
+// GetBinlogTimeline returns the timeline of the binary logs written since the database has been started
+// and the timeline of the previous run, if any.
+func (d *Mariadb) GetBinlogTimeline() (timeline, previous string) {
+	return d.binlogTimeline, d.binlogPrevious
+}
+
+// startBinlogTimeline starts a new timeline because the binary logs of previous runs aren't available anymore.
+// It remembers the timeline of the previous run. Whether the new timeline continues it depends on whether all of its binary logs have been shipped.
+func (d *Mariadb) startBinlogTimeline() error {
+	if !d.binlog {
+		return nil
+	}
+	var previous string
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT timeline FROM $edgeless.binlog").Scan(&previous)
+	})
+	if err == sql.ErrNoRows {
+		previous = ""
+	} else if err != nil {
+		return err
+	}
+
+	timelineRaw := make([]byte, 8)
+	if _, err := rand.Read(timelineRaw); err != nil {
+		return err
+	}
+	timeline := hex.EncodeToString(timelineRaw)
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("DELETE FROM $edgeless.binlog"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO $edgeless.binlog VALUES (?)", timeline); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}); err != nil {
+		return err
+	}
+
+	d.binlogTimeline, d.binlogPrevious = timeline, previous
+	startLog.Info("writing binary logs", "timeline", timeline)
+	return nil
+}
+
+// RotateBinlog closes the current binary log. It returns the paths of the closed binary logs, oldest first, and the name of the new one.
+func (d *Mariadb) RotateBinlog() (closed []string, current string, err error) {
+	if !d.binlog {
+		return nil, "", errors.New("binary log is disabled")
+	}
+	err = d.withInternalConn(func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(context.Background(), "FLUSH BINARY LOGS"); err != nil {
+			return err
+		}
+		names, err := queryFirstColumn(conn, "SHOW BINARY LOGS")
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return errors.New("no binary logs")
+		}
+		for _, name := range names[:len(names)-1] {
+			closed = append(closed, filepath.Join(d.internalPath, name))
+		}
+		current = names[len(names)-1]
+		return nil
+	})
+	return closed, current, err
+}
+
+// PurgeBinlogs deletes the binary logs before the one with the given name.
+func (d *Mariadb) PurgeBinlogs(to string) error {
+	return d.execInternal("PURGE BINARY LOGS TO '" + escapeString([]byte(to)) + "'")
+}
+
+// BinlogFiles returns the paths of all binary logs, oldest first. It can be used after the database has been stopped.
+func (d *Mariadb) BinlogFiles() ([]string, error) {
+	if !d.binlog {
+		return nil, nil
+	}
+	index, err := os.ReadFile(filepath.Join(d.internalPath, filenameBinlog+".index"))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(index), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, filepath.Join(d.internalPath, filepath.Base(line)))
+		}
+	}
+	return files, nil
+}
+
+// ReplayBinlog executes the transactions of a binary log that started at or before until.
+// It returns the start time of the last executed transaction and whether the whole binary log has been executed.
+// Row events are executed as BINLOG statements, the same way mysqlbinlog outputs them.
+func (d *Mariadb) ReplayBinlog(r io.Reader, until time.Time) (last time.Time, complete bool, err error) {
+	err = d.withDumpConn(func(conn *sql.Conn) error {
+		replayer := binlogReplayer{ctx: context.Background(), conn: conn, until: until}
+		err := replayer.replay(bufio.NewReader(r))
+		if replayer.inTransaction {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+		// The connection is reused, e.g., for dumps.
+		if reset := replayer.resetSession(); reset != "" {
+			if _, resetErr := conn.ExecContext(context.Background(), reset); resetErr != nil && err == nil {
+				err = resetErr
+			}
+		}
+		last, complete = replayer.last, !replayer.stopped
+		return err
+	})
+	return last, complete, err
+}
+
+type binlogReplayer struct {
+	ctx   context.Context
+	conn  *sql.Conn
+	until time.Time
+	last  time.Time
+	// rows holds a table map event and the rows events of the current statement
+	rows     []byte
+	database string
+	// session holds the session variables that have been set from status variables of query events
+	session       map[string]string
+	inTransaction bool
+	stopped       bool
+}
+
+func (b *binlogReplayer) replay(r io.Reader) error {
+	magic := make([]byte, len(binlogMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return fmt.Errorf("reading binary log: %w", err)
+	}
+	if string(magic) != binlogMagic {
+		return errors.New("not a binary log")
+	}
+
+	for {
+		event, err := readBinlogEvent(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := b.apply(event); err != nil {
+			return err
+		}
+		if b.stopped {
+			return nil
+		}
+	}
+
+	// binary logs are rotated at transaction boundaries
+	if b.inTransaction || len(b.rows) > 0 {
+		return errors.New("binary log ends in the middle of a transaction")
+	}
+	return nil
+}
+
+func (b *binlogReplayer) apply(event binlogEvent) error {
+	switch event.eventType {
+	case binlogFormatDescriptionEvent:
+		// binlog version (2 bytes), server version (50), create timestamp (4), header length (1)
+		if len(event.data) <= binlogHeaderSize+56 || event.data[binlogHeaderSize+56] != binlogHeaderSize {
+			return errors.New("unsupported binary log event header length")
+		}
+		// The server needs the format description to apply the following row events.
+		return b.exec("BINLOG '" + base64.StdEncoding.EncodeToString(event.data) + "'")
+
+	case binlogGTIDEvent:
+		timestamp := time.Unix(int64(event.timestamp), 0)
+		if timestamp.After(b.until) {
+			b.stopped = true
+			return nil
+		}
+		b.last = timestamp
+		if len(event.data) < binlogHeaderSize+13 {
+			return errors.New("invalid GTID event")
+		}
+		if event.data[binlogHeaderSize+12]&binlogStandaloneFlag == 0 {
+			b.inTransaction = true
+			return b.exec("BEGIN")
+		}
+		return nil
+
+	case binlogQueryEvent:
+		parsed, err := parseQueryEvent(event.data)
+		if err != nil {
+			return err
+		}
+		if set := b.updateSession(parsed.sessionVars); set != "" {
+			if err := b.exec(set); err != nil {
+				return err
+			}
+		}
+		database, query := parsed.database, parsed.query
+		if database != "" && database != b.database {
+			if err := b.exec("USE " + quoteIdentifier(database)); err != nil {
+				return err
+			}
+			b.database = database
+		}
+		switch strings.ToUpper(query) {
+		case "BEGIN":
+			b.inTransaction = true
+		case "COMMIT", "ROLLBACK":
+			b.inTransaction = false
+		}
+		return b.exec(query)
+
+	case binlogTableMapEvent:
+		b.rows = append(b.rows, event.data...)
+		return nil
+
+	case binlogXIDEvent:
+		b.inTransaction = false
+		return b.exec("COMMIT")
+
+	case binlogStopEvent, binlogRotateEvent, binlogHeartbeatEvent, binlogIgnorableEvent, binlogRowsQueryEvent,
+		binlogAnnotateRowsEvent, binlogCheckpointEvent, binlogGTIDListEvent:
+		return nil
+	}
+
+	if isRowsEvent(event.eventType) {
+		b.rows = append(b.rows, event.data...)
+		if len(event.data) < binlogHeaderSize+8 {
+			return errors.New("invalid rows event")
+		}
+		// table id (6 bytes), flags (2 bytes)
+		if binary.LittleEndian.Uint16(event.data[binlogHeaderSize+6:])&binlogStmtEndFlag == 0 {
+			return nil
+		}
+		rows := b.rows
+		b.rows = nil
+		return b.exec("BINLOG '" + base64.StdEncoding.EncodeToString(rows) + "'")
+	}
+
+	if event.flags&binlogIgnorableFlag != 0 {
+		return nil
+	}
+	return fmt.Errorf("unsupported binary log event type: %v", event.eventType)
+}
+
+// updateSession returns a SET statement for the session variables that differ from the current session, or "" if none do.
+func (b *binlogReplayer) updateSession(vars map[string]string) string {
+	var assignments []string
+	for _, name := range sortedKeys(vars) {
+		if current, ok := b.session[name]; ok && current == vars[name] {
+			continue
+		}
+		if b.session == nil {
+			b.session = map[string]string{}
+		}
+		b.session[name] = vars[name]
+		assignments = append(assignments, "@@session."+name+"="+vars[name])
+	}
+	if len(assignments) == 0 {
+		return ""
+	}
+	return "SET " + strings.Join(assignments, ", ")
+}
+
+// resetSession returns a SET statement that resets the session variables set by updateSession to their global values, or "" if none have been set.
+func (b *binlogReplayer) resetSession() string {
+	var assignments []string
+	for _, name := range sortedKeys(b.session) {
+		assignments = append(assignments, "@@session."+name+"=DEFAULT")
+	}
+	b.session = nil
+	if len(assignments) == 0 {
+		return ""
+	}
+	return "SET " + strings.Join(assignments, ", ")
+}
+
+func (b *binlogReplayer) exec(query string) error {
+	if _, err := b.conn.ExecContext(b.ctx, query); err != nil {
+		return fmt.Errorf("replaying transaction of %v: %w", b.last.UTC().Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// isRowsEvent returns whether the event type is a write, update, or delete rows event, including MariaDB's compressed ones.
+func isRowsEvent(eventType byte) bool {
+	return (23 <= eventType && eventType <= 25) || (30 <= eventType && eventType <= 32) || (166 <= eventType && eventType <= 171)
+}
+
+type binlogEvent struct {
+	timestamp uint32
+	eventType byte
+	flags     uint16
+	// data is the whole event including the header
+	data []byte
+}
+
+func readBinlogEvent(r io.Reader) (binlogEvent, error) {
+	header := make([]byte, binlogHeaderSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return binlogEvent{}, io.EOF
+	} else if err != nil {
+		return binlogEvent{}, fmt.Errorf("reading binary log: %w", err)
+	}
+	size := binary.LittleEndian.Uint32(header[9:])
+	if size < binlogHeaderSize || size > 1<<30 {
+		return binlogEvent{}, fmt.Errorf("invalid binary log event size: %v", size)
+	}
+	data := make([]byte, size)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[binlogHeaderSize:]); err != nil {
+		return binlogEvent{}, fmt.Errorf("reading binary log: %w", err)
+	}
+	return binlogEvent{
+		timestamp: binary.LittleEndian.Uint32(header),
+		eventType: header[4],
+		flags:     binary.LittleEndian.Uint16(header[17:]),
+		data:      data,
+	}, nil
+}
+
+// queryEvent is the statement of a query event and the session it has been executed in.
+type queryEvent struct {
+	database string
+	query    string
+	// sessionVars maps session variables to the values that the status variables of the event and its timestamp set, the way mysqlbinlog sets them.
+	sessionVars map[string]string
+}
+
+// parseQueryEvent returns the default database, the statement, and the session variables of a query event.
+func parseQueryEvent(data []byte) (queryEvent, error) {
+	// thread id (4 bytes), execution time (4), database length (1), error code (2), status variables length (2)
+	const postHeaderSize = 13
+	body := data[binlogHeaderSize:]
+	if len(body) < postHeaderSize {
+		return queryEvent{}, errors.New("invalid query event")
+	}
+	databaseLen := int(body[8])
+	statusVarsLen := int(binary.LittleEndian.Uint16(body[11:]))
+	start := postHeaderSize + statusVarsLen
+	if len(body) < start+databaseLen+1 {
+		return queryEvent{}, errors.New("invalid query event")
+	}
+	vars, err := parseQueryStatusVars(body[postHeaderSize:start], binary.LittleEndian.Uint32(data))
+	if err != nil {
+		return queryEvent{}, err
+	}
+	return queryEvent{
+		database:    string(body[start : start+databaseLen]),
+		query:       string(body[start+databaseLen+1:]),
+		sessionVars: vars,
+	}, nil
+}
+
+// queryStatusVarSizes holds the sizes of the status variables of query events that have a fixed size.
+var queryStatusVarSizes = map[byte]int{
+	queryFlags2: 4, querySQLMode: 8, queryAutoIncrement: 4, queryCharset: 6, queryLCTimeNames: 2, queryCharsetDatabase: 2,
+	queryTableMapForUpdate: 8, queryMasterDataWritten: 4, queryMicroseconds: 3, queryHRNow: 3, queryXID: 8, queryGTIDFlags3: 1,
+}
+
+var errInvalidStatusVars = errors.New("invalid status variables in query event")
+
+// parseQueryStatusVars returns the session variables that the status variables of a query event set.
+// The autocommit flag is ignored because the binary log marks the transactions explicitly. Status variables that don't
+// affect the execution of the statement, e.g., the invoker, are skipped. Unknown status variables fail the replay.
+func parseQueryStatusVars(data []byte, timestamp uint32) (map[string]string, error) {
+	vars := map[string]string{"timestamp": strconv.FormatUint(uint64(timestamp), 10)}
+	for len(data) > 0 {
+		code := data[0]
+		data = data[1:]
+
+		if size, ok := queryStatusVarSizes[code]; ok {
+			if len(data) < size {
+				return nil, errInvalidStatusVars
+			}
+			value := data[:size]
+			data = data[size:]
+			switch code {
+			case queryFlags2:
+				flags := binary.LittleEndian.Uint32(value)
+				vars["foreign_key_checks"] = boolVar(flags&optionNoForeignKeyCheck == 0)
+				vars["unique_checks"] = boolVar(flags&optionRelaxedUnique == 0)
+				vars["sql_auto_is_null"] = boolVar(flags&optionAutoIsNull != 0)
+			case querySQLMode:
+				vars["sql_mode"] = strconv.FormatUint(binary.LittleEndian.Uint64(value), 10)
+			case queryAutoIncrement:
+				vars["auto_increment_increment"] = uint16Var(value)
+				vars["auto_increment_offset"] = uint16Var(value[2:])
+			case queryCharset:
+				vars["character_set_client"] = uint16Var(value)
+				vars["collation_connection"] = uint16Var(value[2:])
+				vars["collation_server"] = uint16Var(value[4:])
+			case queryLCTimeNames:
+				vars["lc_time_names"] = uint16Var(value)
+			case queryCharsetDatabase:
+				if binary.LittleEndian.Uint16(value) == 0 {
+					vars["collation_database"] = "DEFAULT"
+				} else {
+					vars["collation_database"] = uint16Var(value)
+				}
+			case queryMicroseconds, queryHRNow:
+				micros := uint32(value[0]) | uint32(value[1])<<8 | uint32(value[2])<<16
+				vars["timestamp"] = fmt.Sprintf("%v.%06d", timestamp, micros)
+			}
+			continue
+		}
+
+		var err error
+		switch code {
+		case queryTimeZone:
+			var timeZone []byte
+			if timeZone, data, err = cutLengthPrefixed(data); err == nil {
+				vars["time_zone"] = "'" + escapeString(timeZone) + "'"
+			}
+		case queryCatalog:
+			// followed by a zero byte
+			if _, data, err = cutLengthPrefixed(data); err == nil {
+				if len(data) == 0 {
+					return nil, errInvalidStatusVars
+				}
+				data = data[1:]
+			}
+		case queryCatalogNZ:
+			_, data, err = cutLengthPrefixed(data)
+		case queryInvoker:
+			// user and host
+			if _, data, err = cutLengthPrefixed(data); err == nil {
+				_, data, err = cutLengthPrefixed(data)
+			}
+		case queryUpdatedDBNames:
+			data, err = cutUpdatedDBNames(data)
+		default:
+			return nil, fmt.Errorf("unsupported status variable in query event: %v", code)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// cutLengthPrefixed returns a value that is prefixed by its one-byte length and the remaining data.
+func cutLengthPrefixed(data []byte) (value, rest []byte, err error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, nil, errInvalidStatusVars
+	}
+	return data[1 : 1+int(data[0])], data[1+int(data[0]):], nil
+}
+
+// cutUpdatedDBNames skips the zero-terminated names of the databases that a statement has updated and returns the remaining data.
+func cutUpdatedDBNames(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errInvalidStatusVars
+	}
+	count := int(data[0])
+	data = data[1:]
+	if count == queryOverMaxDBsInEvent {
+		return data, nil
+	}
+	for i := 0; i < count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, errInvalidStatusVars
+		}
+		data = data[end+1:]
+	}
+	return data, nil
+}
+
+func uint16Var(value []byte) string {
+	return strconv.Itoa(int(binary.LittleEndian.Uint16(value)))
+}
+
+func boolVar(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func queryFirstColumn(conn *sql.Conn, query string) ([]string, error) {
+	rows, err := conn.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		var first string
+		values[0] = &first
+		for i := 1; i < len(values); i++ {
+			values[i] = new(sql.RawBytes)
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		result = append(result, first)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,206 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBinlogEvent(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	query := newQueryEvent(1000, "db", "CREATE TABLE t (i INT)")
+	r := bytes.NewReader(append(append([]byte{}, query...), newBinlogEvent(binlogXIDEvent, 1001, 0, make([]byte, 8))...))
+
+	event, err := readBinlogEvent(r)
+	require.NoError(err)
+	assert.EqualValues(binlogQueryEvent, event.eventType)
+	assert.EqualValues(1000, event.timestamp)
+	assert.Equal(query, event.data)
+
+	event, err = readBinlogEvent(r)
+	require.NoError(err)
+	assert.EqualValues(binlogXIDEvent, event.eventType)
+	assert.Len(event.data, binlogHeaderSize+8)
+
+	_, err = readBinlogEvent(r)
+	assert.Equal(io.EOF, err)
+
+	// truncated event
+	_, err = readBinlogEvent(bytes.NewReader(query[:len(query)-1]))
+	assert.Error(err)
+	assert.NotEqual(io.EOF, err)
+
+	// invalid size
+	invalid := append([]byte{}, query...)
+	binary.LittleEndian.PutUint32(invalid[9:], 3)
+	_, err = readBinlogEvent(bytes.NewReader(invalid))
+	assert.Error(err)
+}
+
+func TestParseQueryEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	event, err := parseQueryEvent(newQueryEvent(1000, "db", "DROP TABLE t"))
+	assert.NoError(err)
+	assert.Equal("db", event.database)
+	assert.Equal("DROP TABLE t", event.query)
+	assert.Equal(map[string]string{"timestamp": "1000"}, event.sessionVars)
+
+	event, err = parseQueryEvent(newQueryEvent(0, "", "CREATE DATABASE db"))
+	assert.NoError(err)
+	assert.Empty(event.database)
+	assert.Equal("CREATE DATABASE db", event.query)
+
+	_, err = parseQueryEvent(newBinlogEvent(binlogQueryEvent, 0, 0, make([]byte, 12)))
+	assert.Error(err)
+}
+
+func TestParseQueryStatusVars(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	statusVars := []byte{
+		queryFlags2, 0, 0, 0, 0x4, // no foreign key checks
+		querySQLMode, 0x20, 0, 0, 0, 0, 0, 0, 0, // ANSI_QUOTES
+		queryCatalogNZ, 3, 's', 't', 'd',
+		queryAutoIncrement, 2, 0, 1, 0,
+		queryCharset, 33, 0, 33, 0, 8, 0,
+		queryTimeZone, 6, 'S', 'Y', 'S', 'T', 'E', 'M',
+		queryLCTimeNames, 0, 0,
+		queryCharsetDatabase, 0, 0,
+		queryInvoker, 4, 'r', 'o', 'o', 't', 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't',
+		queryUpdatedDBNames, 2, 'a', 0, 'b', 0,
+		queryHRNow, 0x40, 0xe2, 0x01, // 123456 microseconds
+		queryXID, 1, 2, 3, 4, 5, 6, 7, 8,
+	}
+	vars, err := parseQueryStatusVars(statusVars, 1000)
+	require.NoError(err)
+	assert.Equal(map[string]string{
+		"foreign_key_checks":       "0",
+		"unique_checks":            "1",
+		"sql_auto_is_null":         "0",
+		"sql_mode":                 "32",
+		"auto_increment_increment": "2",
+		"auto_increment_offset":    "1",
+		"character_set_client":     "33",
+		"collation_connection":     "33",
+		"collation_server":         "8",
+		"time_zone":                "'SYSTEM'",
+		"lc_time_names":            "0",
+		"collation_database":       "DEFAULT",
+		"timestamp":                "1000.123456",
+	}, vars)
+
+	// too many updated databases to be listed
+	_, err = parseQueryStatusVars([]byte{queryUpdatedDBNames, queryOverMaxDBsInEvent}, 0)
+	assert.NoError(err)
+
+	for name, statusVars := range map[string][]byte{
+		"truncated fixed size":    {querySQLMode, 0, 0},
+		"truncated string":        {queryTimeZone, 6, 'S'},
+		"catalog without zero":    {queryCatalog, 1, 's'},
+		"unterminated db name":    {queryUpdatedDBNames, 1, 'a'},
+		"unknown status variable": {200, 0},
+	} {
+		_, err := parseQueryStatusVars(statusVars, 0)
+		assert.Error(err, name)
+	}
+}
+
+func TestBinlogReplayerSession(t *testing.T) {
+	assert := assert.New(t)
+
+	replayer := binlogReplayer{}
+	assert.Empty(replayer.resetSession())
+
+	assert.Equal("SET @@session.sql_mode=32, @@session.timestamp=1000", replayer.updateSession(map[string]string{"timestamp": "1000", "sql_mode": "32"}))
+	// only changed variables are set
+	assert.Empty(replayer.updateSession(map[string]string{"timestamp": "1000", "sql_mode": "32"}))
+	assert.Equal("SET @@session.timestamp=1001", replayer.updateSession(map[string]string{"timestamp": "1001", "sql_mode": "32"}))
+
+	assert.Equal("SET @@session.sql_mode=DEFAULT, @@session.timestamp=DEFAULT", replayer.resetSession())
+	assert.Empty(replayer.resetSession())
+}
+
+func TestIsRowsEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, eventType := range []byte{23, 24, 25, 30, 31, 32, 166, 169, 171} {
+		assert.True(isRowsEvent(eventType), eventType)
+	}
+	for _, eventType := range []byte{binlogQueryEvent, binlogTableMapEvent, binlogXIDEvent, binlogGTIDEvent, 26, 165, 172} {
+		assert.False(isRowsEvent(eventType), eventType)
+	}
+}
+
+func TestBinlogReplayer(t *testing.T) {
+	assert := assert.New(t)
+
+	until := time.Unix(2000, 0)
+
+	// not a binary log
+	replayer := binlogReplayer{ctx: context.Background(), until: until}
+	assert.Error(replayer.replay(bytes.NewReader([]byte("binlog"))))
+
+	// stops at the first transaction that started after until
+	replayer = binlogReplayer{ctx: context.Background(), until: until}
+	log := append([]byte(binlogMagic), newBinlogEvent(binlogGTIDEvent, 2001, 0, make([]byte, 13))...)
+	log = append(log, newQueryEvent(2001, "db", "DROP TABLE t")...)
+	assert.NoError(replayer.replay(bytes.NewReader(log)))
+	assert.True(replayer.stopped)
+	assert.True(replayer.last.IsZero())
+
+	// a binary log must not end in the middle of a statement
+	replayer = binlogReplayer{ctx: context.Background(), until: until}
+	log = append([]byte(binlogMagic), newBinlogEvent(binlogTableMapEvent, 1000, 0, make([]byte, 8))...)
+	assert.Error(replayer.replay(bytes.NewReader(log)))
+
+	// unknown events are only skipped if they are ignorable
+	replayer = binlogReplayer{ctx: context.Background(), until: until}
+	assert.NoError(replayer.apply(binlogEvent{eventType: 200, flags: binlogIgnorableFlag}))
+	assert.Error(replayer.apply(binlogEvent{eventType: 200}))
+}
+
+func newBinlogEvent(eventType byte, timestamp uint32, flags uint16, body []byte) []byte {
+	event := make([]byte, binlogHeaderSize, binlogHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(event, timestamp)
+	event[4] = eventType
+	binary.LittleEndian.PutUint32(event[9:], uint32(binlogHeaderSize+len(body)))
+	binary.LittleEndian.PutUint16(event[17:], flags)
+	return append(event, body...)
+}
+
+func newQueryEvent(timestamp uint32, database, query string, statusVars ...byte) []byte {
+	// thread id, execution time, database length, error code, status variables length, status variables
+	body := make([]byte, 13, 13+len(statusVars)+len(database)+1+len(query))
+	body[8] = byte(len(database))
+	binary.LittleEndian.PutUint16(body[11:], uint16(len(statusVars)))
+	body = append(body, statusVars...)
+	body = append(body, database...)
+	body = append(body, 0)
+	body = append(body, query...)
+	return newBinlogEvent(binlogQueryEvent, timestamp, 0, body)
+}
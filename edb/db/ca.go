@@ -0,0 +1,114 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// caBundleEntry is a CA certificate that is only accepted during its validity window.
+// A zero NotBefore or NotAfter leaves the respective side of the window open.
+type caBundleEntry struct {
+	Cert      string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// caBundle holds the CA certificates used to verify client certificates.
+type caBundle struct {
+	ca      string // accepted at any time
+	entries []caBundleEntry
+	added   []caBundleEntry // added at runtime
+}
+
+func newCABundle(man manifest) caBundle {
+	return caBundle{ca: man.CA, entries: man.CAs}
+}
+
+// withAdded returns a copy of the bundle in which the CAs added at runtime are replaced by added.
+func (b caBundle) withAdded(added []caBundleEntry) caBundle {
+	b.added = added
+	return b
+}
+
+func (b caBundle) allEntries() []caBundleEntry {
+	return append(append([]caBundleEntry{}, b.entries...), b.added...)
+}
+
+func (b caBundle) validate() error {
+	for i, entry := range b.allEntries() {
+		if _, err := parseCertificates([]byte(entry.Cert)); err != nil {
+			return fmt.Errorf("invalid CA %v: %v", i, err)
+		}
+		if !entry.NotBefore.IsZero() && !entry.NotAfter.IsZero() && !entry.NotBefore.Before(entry.NotAfter) {
+			return fmt.Errorf("invalid CA %v: validity window is empty", i)
+		}
+	}
+	return nil
+}
+
+// all returns all CA certificates regardless of their validity windows.
+func (b caBundle) all() string {
+	return b.join(func(caBundleEntry) bool { return true })
+}
+
+// active returns the CA certificates that are accepted at the given time.
+func (b caBundle) active(now time.Time) string {
+	return b.join(func(entry caBundleEntry) bool { return entry.isActive(now) })
+}
+
+// join concatenates the PEM-encoded CA certificates of the entries for which include returns true.
+// The certificates are separated by line breaks, because PEM requires each block to start on its own line.
+func (b caBundle) join(include func(caBundleEntry) bool) string {
+	var certs []string
+	if b.ca != "" {
+		certs = append(certs, strings.TrimSpace(b.ca))
+	}
+	for _, entry := range b.allEntries() {
+		if include(entry) {
+			certs = append(certs, strings.TrimSpace(entry.Cert))
+		}
+	}
+	if len(certs) <= 0 {
+		return ""
+	}
+	return strings.Join(certs, "\n") + "\n"
+}
+
+// nextChange returns the next point in time after now when the set of active CAs changes.
+func (b caBundle) nextChange(now time.Time) (time.Time, error) {
+	var next time.Time
+	for _, entry := range b.allEntries() {
+		for _, t := range []time.Time{entry.NotBefore, entry.NotAfter} {
+			if t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	if next.IsZero() {
+		return time.Time{}, errNoCAChange
+	}
+	return next, nil
+}
+
+func (e caBundleEntry) isActive(now time.Time) bool {
+	return !now.Before(e.NotBefore) && (e.NotAfter.IsZero() || now.Before(e.NotAfter))
+}
+
+var errNoCAChange = errors.New("the set of active CAs does not change anymore")
@@ -0,0 +1,122 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCABundle(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	cas := caBundle{
+		ca: "static",
+		entries: []caBundleEntry{
+			{Cert: "old", NotAfter: now.Add(time.Hour)},
+			{Cert: "new", NotBefore: now.Add(-time.Hour)},
+			{Cert: "next", NotBefore: now.Add(30 * time.Minute), NotAfter: now.Add(2 * time.Hour)},
+		},
+	}
+
+	assert.Equal("static\nold\nnew\nnext\n", cas.all())
+	assert.Equal("static\nold\nnew\n", cas.active(now))
+	assert.Equal("static\nold\nnew\nnext\n", cas.active(now.Add(30*time.Minute)))
+	assert.Equal("static\nnew\nnext\n", cas.active(now.Add(time.Hour)))
+	assert.Equal("static\nnew\n", cas.active(now.Add(2*time.Hour)))
+
+	next, err := cas.nextChange(now)
+	require.NoError(err)
+	assert.Equal(now.Add(30*time.Minute), next)
+	next, err = cas.nextChange(next)
+	require.NoError(err)
+	assert.Equal(now.Add(time.Hour), next)
+	next, err = cas.nextChange(next)
+	require.NoError(err)
+	assert.Equal(now.Add(2*time.Hour), next)
+	_, err = cas.nextChange(next)
+	assert.Equal(errNoCAChange, err)
+
+	// CAs added at runtime
+	added := cas.withAdded([]caBundleEntry{{Cert: "added", NotAfter: now.Add(3 * time.Hour)}})
+	assert.Equal("static\nnew\nadded\n", added.active(now.Add(2*time.Hour)))
+	next, err = added.nextChange(now.Add(2 * time.Hour))
+	require.NoError(err)
+	assert.Equal(now.Add(3*time.Hour), next)
+	assert.Empty(cas.added)
+}
+
+func TestRotateCAsStops(t *testing.T) {
+	d := &Mariadb{cas: caBundle{entries: []caBundleEntry{{Cert: "next", NotBefore: time.Now().Add(time.Hour)}}}, done: make(chan struct{})}
+	rotated := make(chan struct{})
+	go func() {
+		d.rotateCAs()
+		close(rotated)
+	}()
+
+	d.stopBackgroundTasks()
+	select {
+	case <-rotated:
+	case <-time.After(time.Second):
+		assert.Fail(t, "rotateCAs hasn't returned")
+	}
+}
+
+func TestCABundleFromManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cert, _, err := createCertificate("ca")
+	require.NoError(err)
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+
+	jsonManifest, err := json.Marshal(map[string]interface{}{
+		"cas": []map[string]string{
+			{"cert": certPEM, "notAfter": "2021-02-01T00:00:00Z"},
+			{"cert": certPEM, "notBefore": "2021-01-01T00:00:00Z"},
+		},
+	})
+	require.NoError(err)
+	var man manifest
+	require.NoError(json.Unmarshal(jsonManifest, &man))
+
+	cas := newCABundle(man)
+	assert.NoError(cas.validate())
+	assert.Equal(certPEM, cas.active(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(certPEM+certPEM, cas.active(time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)))
+
+	// empty validity window
+	cas.entries[0].NotBefore = cas.entries[0].NotAfter
+	assert.Error(cas.validate())
+
+	// invalid certificate
+	cas.entries[0] = caBundleEntry{Cert: "invalid"}
+	assert.Error(cas.validate())
+
+	// PEM blocks without a trailing line break are separated
+	cas = caBundle{ca: strings.TrimSpace(certPEM), entries: []caBundleEntry{{Cert: strings.TrimSpace(certPEM)}}}
+	certs, err := parseCertificates([]byte(cas.all()))
+	require.NoError(err)
+	assert.Len(certs, 2)
+}
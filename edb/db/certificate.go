@@ -0,0 +1,354 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// GetCertificate gets the database certificate.
+func (d *Mariadb) GetCertificate() ([]byte, crypto.PrivateKey) {
+	d.certMutex.RLock()
+	defer d.certMutex.RUnlock()
+	return d.cert, d.key
+}
+
+// GetCertificateChain gets the certificates that must be presented along with a certificate issued by the database certificate.
+func (d *Mariadb) GetCertificateChain() [][]byte {
+	chain, _ := d.getCertificateChain(time.Now())
+	return chain
+}
+
+// getCertificateChain returns the certificate chain and, if it's in effect, the end of the overlap after a renewal.
+func (d *Mariadb) getCertificateChain(now time.Time) ([][]byte, time.Time) {
+	d.certMutex.RLock()
+	defer d.certMutex.RUnlock()
+
+	var chain [][]byte
+	var overlapEnd time.Time
+	if now.Before(d.crossCertNotAfter) {
+		// clients that still trust the previous certificate can verify the new one via the cross-signed certificate
+		chain = append(chain, d.crossCert)
+		overlapEnd = d.crossCertNotAfter
+	}
+	chain = append(chain, d.cert)
+	return append(chain, d.chain...), overlapEnd
+}
+
+// RenewCertificate replaces the database certificate and its key by a new self-signed certificate.
+// The previous certificate stays valid for the given overlap by cross-signing the new one.
+func (d *Mariadb) RenewCertificate(overlap time.Duration) error {
+	if d.isMarble {
+		return errors.New("cannot renew the certificate provided by marblerun")
+	}
+	parsedCert, err := d.parseCertificate()
+	if err != nil {
+		return err
+	}
+	cert, key, err := createCertificate(parsedCert.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+	return d.replaceCertificate(cert, key, nil, overlap)
+}
+
+// CreateCertificateRequest creates a CSR for a new database certificate. The key is kept in the database until the signed
+// certificate is set, so repeated calls return a CSR for the same key.
+func (d *Mariadb) CreateCertificateRequest() ([]byte, error) {
+	if d.isMarble {
+		return nil, errors.New("cannot replace the certificate provided by marblerun")
+	}
+
+	key, err := d.getCSRKeyFromSQL()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		keyRaw, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.execInternal("INSERT INTO $edgeless.csr_key VALUES (?)", keyRaw); err != nil {
+			return nil, err
+		}
+	}
+
+	parsedCert, err := d.parseCertificate()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{Organization: []string{"EDB root"}, CommonName: parsedCert.Subject.CommonName},
+		DNSNames: []string{parsedCert.Subject.CommonName},
+	}
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
+
+// SetCertificate replaces the database certificate by chain[0], which must have been issued for the key of the last CSR.
+// The remaining certificates of the chain are presented along with it. The previous certificate stays valid for the given overlap.
+func (d *Mariadb) SetCertificate(chain [][]byte, overlap time.Duration) error {
+	if d.isMarble {
+		return errors.New("cannot replace the certificate provided by marblerun")
+	}
+	if len(chain) <= 0 {
+		return errors.New("no certificate found")
+	}
+
+	key, err := d.getCSRKeyFromSQL()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.New("no certificate request has been created")
+	}
+
+	if err := verifyCertificateChain(chain, key); err != nil {
+		return err
+	}
+	return d.replaceCertificate(chain[0], key, chain[1:], overlap)
+}
+
+func verifyCertificateChain(chain [][]byte, key crypto.PrivateKey) error {
+	var certs []*x509.Certificate
+	for _, raw := range chain {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	cert := certs[0]
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return errors.New("key is not a signer")
+	}
+	if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(signer.Public()) {
+		return errors.New("certificate does not match the key of the certificate request")
+	}
+	// EDB issues certificates with it, so it must be a CA
+	if !cert.IsCA || (cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return errors.New("certificate is not a CA")
+	}
+	if !time.Now().Before(cert.NotAfter) {
+		return errors.New("certificate has expired")
+	}
+
+	for i := 0; i < len(certs)-1; i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceCertificate stores the new certificate in the database and lets MariaDB use it.
+func (d *Mariadb) replaceCertificate(cert []byte, key crypto.PrivateKey, chain [][]byte, overlap time.Duration) error {
+	oldCert, oldKey := d.GetCertificate()
+	parsedOldCert, err := x509.ParseCertificate(oldCert)
+	if err != nil {
+		return err
+	}
+	keyRaw, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	var crossCert []byte
+	crossCertNotAfter := time.Now().Add(overlap)
+	if overlap > 0 {
+		if crossCertNotAfter.After(parsedOldCert.NotAfter) {
+			crossCertNotAfter = parsedOldCert.NotAfter
+		}
+		if crossCert, err = createCrossCertificate(cert, key, crossCertNotAfter, oldCert, oldKey); err != nil {
+			return err
+		}
+	}
+
+	var chainPEM []byte
+	for _, c := range chain {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("UPDATE $edgeless.config SET c=?, k=?", cert, keyRaw); err != nil {
+			return err
+		}
+		for _, table := range []string{"cert_chain", "cross_cert", "csr_key"} {
+			if _, err := tx.Exec("DELETE FROM $edgeless." + table); err != nil {
+				return err
+			}
+		}
+		if chainPEM != nil {
+			if _, err := tx.Exec("INSERT INTO $edgeless.cert_chain VALUES (?)", chainPEM); err != nil {
+				return err
+			}
+		}
+		if crossCert != nil {
+			if _, err := tx.Exec("INSERT INTO $edgeless.cross_cert VALUES (?, ?)", crossCert, crossCertNotAfter.Unix()); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}); err != nil {
+		return err
+	}
+
+	d.setCertificate(cert, key, chain, crossCert, crossCertNotAfter)
+	if err := d.writeServerCertificate(); err != nil {
+		return err
+	}
+	return d.execInternal("FLUSH SSL")
+}
+
+func (d *Mariadb) setCertificate(cert []byte, key crypto.PrivateKey, chain [][]byte, crossCert []byte, crossCertNotAfter time.Time) {
+	d.certMutex.Lock()
+	defer d.certMutex.Unlock()
+	d.cert = cert
+	d.key = key
+	d.chain = chain
+	d.crossCert = crossCert
+	d.crossCertNotAfter = crossCertNotAfter
+
+	// A previous renewal's overlap is superseded.
+	if d.overlapTimer != nil {
+		d.overlapTimer.Stop()
+		d.overlapTimer = nil
+	}
+	if crossCert != nil {
+		// MariaDB must present the certificate itself again when the overlap ends
+		d.overlapTimer = time.AfterFunc(time.Until(crossCertNotAfter), func() {
+			if atomic.LoadInt32(&d.stopping) != 0 {
+				return
+			}
+			if _, overlapEnd := d.getCertificateChain(time.Now()); !overlapEnd.IsZero() {
+				return // there has been another renewal in the meantime
+			}
+			log.Info("certificate overlap has ended")
+			if err := d.writeServerCertificate(); err != nil {
+				log.Error("failed to write certificate", "error", err)
+				return
+			}
+			if err := d.execInternal("FLUSH SSL"); err != nil {
+				log.Error("failed to reload SSL context", "error", err)
+			}
+		})
+	}
+}
+
+// writeServerCertificate writes the certificate that MariaDB presents to clients. This is the database certificate itself,
+// except during the overlap after a renewal, where it's a certificate that chains to both the new and the previous one.
+func (d *Mariadb) writeServerCertificate() error {
+	cert, key := d.GetCertificate()
+	chain, overlapEnd := d.getCertificateChain(time.Now())
+
+	if !overlapEnd.IsZero() {
+		parsedCert, err := x509.ParseCertificate(cert)
+		if err != nil {
+			return err
+		}
+		serverCert, serverKey, err := createServerCertificate(parsedCert.Subject.CommonName, overlapEnd, cert, key)
+		if err != nil {
+			return err
+		}
+		chain = append([][]byte{serverCert}, chain...)
+		key = serverKey
+	}
+
+	var pemChain []byte
+	for _, c := range chain {
+		pemChain = append(pemChain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	_, pemKey, err := toPEM(cert, key)
+	if err != nil {
+		return err
+	}
+	if err := d.writeFile(filenameCert, pemChain); err != nil {
+		return err
+	}
+	return d.writeFile(filenameKey, pemKey)
+}
+
+func (d *Mariadb) parseCertificate() (*x509.Certificate, error) {
+	cert, _ := d.GetCertificate()
+	return x509.ParseCertificate(cert)
+}
+
+func (d *Mariadb) getCertificateChainFromSQL() (chain [][]byte, crossCert []byte, crossCertNotAfter time.Time, err error) {
+	var chainPEM []byte
+	err = d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT c FROM $edgeless.cert_chain").Scan(&chainPEM)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, time.Time{}, err
+	}
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		chain = append(chain, block.Bytes)
+	}
+
+	var notAfterUnix int64
+	err = d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT * FROM $edgeless.cross_cert").Scan(&crossCert, &notAfterUnix)
+	})
+	if err == sql.ErrNoRows {
+		return chain, nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	crossCertNotAfter = time.Unix(notAfterUnix, 0)
+	if !time.Now().Before(crossCertNotAfter) {
+		return chain, nil, time.Time{}, nil
+	}
+	return chain, crossCert, crossCertNotAfter, nil
+}
+
+func (d *Mariadb) getCSRKeyFromSQL() (crypto.PrivateKey, error) {
+	var keyRaw []byte
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT k FROM $edgeless.csr_key").Scan(&keyRaw)
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS8PrivateKey(keyRaw)
+}
@@ -0,0 +1,71 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCertificateChain(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	orgCert, orgKey := createCA(t, "Org CA")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	parsedOrgCert, err := x509.ParseCertificate(orgCert)
+	require.NoError(err)
+	issue := func(isCA bool, notAfter time.Time) []byte {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(2),
+			Subject:               pkix.Name{CommonName: "localhost"},
+			NotAfter:              notAfter,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  isCA,
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, template, parsedOrgCert, &key.PublicKey, orgKey)
+		require.NoError(err)
+		return cert
+	}
+	cert := issue(true, time.Now().Add(time.Hour))
+
+	assert.NoError(verifyCertificateChain([][]byte{cert}, key))
+	assert.NoError(verifyCertificateChain([][]byte{cert, orgCert}, key))
+
+	// wrong key
+	assert.Error(verifyCertificateChain([][]byte{cert}, otherKey))
+	// not a CA
+	assert.Error(verifyCertificateChain([][]byte{issue(false, time.Now().Add(time.Hour))}, key))
+	// expired
+	assert.Error(verifyCertificateChain([][]byte{issue(true, time.Now().Add(-time.Hour))}, key))
+	// broken chain
+	otherCert, _ := createCA(t, "Other CA")
+	assert.Error(verifyCertificateChain([][]byte{cert, otherCert}, key))
+}
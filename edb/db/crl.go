@@ -0,0 +1,112 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// parseCRLs parses a list of PEM-encoded CRLs and verifies that each of them has been signed by one of the CAs in caPEM.
+func parseCRLs(crlPEM []byte, caPEM string) ([]*x509.RevocationList, error) {
+	cas, err := parseCertificates([]byte(caPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	var crls []*x509.RevocationList
+	for rest := crlPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block in CRL: %v", block.Type)
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if !isSignedByAny(crl, cas) {
+			return nil, fmt.Errorf("CRL of %v is not signed by a CA of the manifest", crl.Issuer)
+		}
+		crls = append(crls, crl)
+	}
+
+	if len(crls) <= 0 {
+		return nil, errors.New("no CRL found")
+	}
+	return crls, nil
+}
+
+// checkCRLUpdate ensures that the new CRLs have not expired and that an update neither drops the CRL of an issuer nor rolls it back to an older one.
+func checkCRLUpdate(oldCRLs, newCRLs []*x509.RevocationList) error {
+	for _, newCRL := range newCRLs {
+		if !newCRL.NextUpdate.IsZero() && newCRL.NextUpdate.Before(time.Now()) {
+			return fmt.Errorf("CRL of %v has expired", newCRL.Issuer)
+		}
+	}
+	for _, oldCRL := range oldCRLs {
+		var found bool
+		for _, newCRL := range newCRLs {
+			if !bytes.Equal(oldCRL.RawIssuer, newCRL.RawIssuer) {
+				continue
+			}
+			if newCRL.ThisUpdate.Before(oldCRL.ThisUpdate) {
+				return fmt.Errorf("CRL of %v is older than the current one", newCRL.Issuer)
+			}
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("CRL of %v is missing", oldCRL.Issuer)
+		}
+	}
+	return nil
+}
+
+func isSignedByAny(crl *x509.RevocationList, cas []*x509.Certificate) bool {
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCertificates(certPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) <= 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
@@ -0,0 +1,121 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCRLs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	caCert, caKey := createCA(t, "ca")
+	otherCert, otherKey := createCA(t, "other")
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert}))
+
+	crl := createCRL(t, caCert, caKey, time.Now())
+	crls, err := parseCRLs(crl, caPEM)
+	require.NoError(err)
+	assert.Len(crls, 1)
+
+	// CRL not signed by the CA
+	_, err = parseCRLs(createCRL(t, otherCert, otherKey, time.Now()), caPEM)
+	assert.Error(err)
+
+	// CA bundle
+	bundlePEM := caPEM + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert}))
+	crls, err = parseCRLs(append(crl, createCRL(t, otherCert, otherKey, time.Now())...), bundlePEM)
+	require.NoError(err)
+	assert.Len(crls, 2)
+
+	// Invalid input
+	_, err = parseCRLs(nil, caPEM)
+	assert.Error(err)
+	_, err = parseCRLs(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert}), caPEM)
+	assert.Error(err)
+}
+
+func TestCheckCRLUpdate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	caCert, caKey := createCA(t, "ca")
+	otherCert, otherKey := createCA(t, "other")
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert}))
+	bundlePEM := caPEM + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert}))
+
+	now := time.Now()
+	oldCRLs, err := parseCRLs(createCRL(t, caCert, caKey, now.Add(-time.Hour)), caPEM)
+	require.NoError(err)
+	newCRLs, err := parseCRLs(createCRL(t, caCert, caKey, now), caPEM)
+	require.NoError(err)
+	otherCRLs, err := parseCRLs(createCRL(t, otherCert, otherKey, now), bundlePEM)
+	require.NoError(err)
+	expiredCRLs, err := parseCRLs(createCRL(t, caCert, caKey, now.Add(-48*time.Hour)), caPEM)
+	require.NoError(err)
+
+	assert.NoError(checkCRLUpdate(nil, newCRLs))
+	assert.NoError(checkCRLUpdate(oldCRLs, newCRLs))
+	assert.NoError(checkCRLUpdate(oldCRLs, append(newCRLs, otherCRLs...)))
+	assert.Error(checkCRLUpdate(newCRLs, oldCRLs))   // rollback
+	assert.Error(checkCRLUpdate(oldCRLs, otherCRLs)) // missing issuer
+	assert.Error(checkCRLUpdate(nil, expiredCRLs))   // expired
+}
+
+func createCA(t *testing.T, commonName string) ([]byte, crypto.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	return cert, priv
+}
+
+func createCRL(t *testing.T, caCert []byte, caKey crypto.PrivateKey, thisUpdate time.Time) []byte {
+	parsedCACert, err := x509.ParseCertificate(caCert)
+	require.NoError(t, err)
+	template := &x509.RevocationList{
+		Number:     big.NewInt(thisUpdate.Unix()),
+		ThisUpdate: thisUpdate,
+		NextUpdate: thisUpdate.Add(24 * time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(2), RevocationTime: thisUpdate},
+		},
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, template, parsedCACert, caKey.(crypto.Signer))
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+}
@@ -0,0 +1,155 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EventKind classifies an event of MariaDB's error log.
+type EventKind string
+
+// Kinds of error log events.
+const (
+	EventWrongKeyOrCorruption EventKind = "wrong-key-or-corruption"
+	EventPortInUse            EventKind = "port-in-use"
+	EventOutOfMemory          EventKind = "out-of-enclave-memory"
+	EventDiskFull             EventKind = "disk-full"
+	EventManifestSQL          EventKind = "manifest-sql"
+	EventTLSFile              EventKind = "tls-file"
+	EventError                EventKind = "error" // any other error
+)
+
+// Event is a diagnosed event of MariaDB's error log.
+type Event struct {
+	Kind    EventKind
+	Message string // the message of the log line
+	Port    int    `json:",omitempty"` // EventPortInUse
+	Code    int    `json:",omitempty"` // MariaDB error code of EventManifestSQL
+	// Statement is the index of the failed manifest SQL statement of EventManifestSQL.
+	Statement *int `json:",omitempty"`
+	// StatementText is the text of the failed manifest SQL statement of EventManifestSQL.
+	StatementText string `json:",omitempty"`
+}
+
+// Description returns a description of the event for users.
+func (e Event) Description() string {
+	switch e.Kind {
+	case EventWrongKeyOrCorruption:
+		return "RocksDB failed to open the database. This likely failed due to an incorrect key being used to decrypt the database or the database being corrupted: " + e.Message
+	case EventPortInUse:
+		if e.Port != 0 {
+			return fmt.Sprintf("port %v is already in use", e.Port)
+		}
+		return "a port is already in use: " + e.Message
+	case EventOutOfMemory:
+		return "MariaDB ran out of enclave memory, consider increasing the enclave's heap size: " + e.Message
+	case EventDiskFull:
+		return "the disk is full: " + e.Message
+	case EventManifestSQL:
+		if e.Statement == nil {
+			return fmt.Sprintf("a manifest SQL statement failed with error %v: %v", e.Code, e.Message)
+		}
+		return fmt.Sprintf("manifest SQL statement %v (%q) failed with error %v: %v", *e.Statement, e.StatementText, e.Code, e.Message)
+	case EventTLSFile:
+		return "MariaDB failed to load a TLS certificate, key, CA, or CRL: " + e.Message
+	}
+	return e.Message
+}
+
+// DiagnosisError is returned if MariaDB's error log contains errors.
+type DiagnosisError struct {
+	Events []Event
+}
+
+func (e *DiagnosisError) Error() string {
+	descriptions := make([]string, len(e.Events))
+	for i, event := range e.Events {
+		descriptions[i] = event.Description()
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+var (
+	// MariaDB's log lines look like "2021-05-10 10:00:00 0 [ERROR] message"
+	logLinePattern = regexp.MustCompile(`^\d{4}-\d\d-\d\d\s+\d?\d:\d\d:\d\d\s+\d+\s+\[(\w+)\]\s*(.*)$`)
+	portPattern    = regexp.MustCompile(`running on port: (\d+) \?`)
+
+	eventPatterns = []struct {
+		kind    EventKind
+		pattern *regexp.Regexp
+	}{
+		{EventWrongKeyOrCorruption, regexp.MustCompile(`Plugin 'ROCKSDB' (registration as a STORAGE ENGINE failed|init function returned error)|RocksDB: Error opening instance|Corruption:`)},
+		{EventPortInUse, regexp.MustCompile(`Bind on TCP/IP port|Address already in use|running on port: \d+ \?`)},
+		{EventOutOfMemory, regexp.MustCompile(`(?i)out of memory|Cannot allocate memory|bad_alloc|errno:? 12\b`)},
+		{EventDiskFull, regexp.MustCompile(`(?i)no space left on device|disk (is )?full|errno:? 28\b`)},
+		{EventTLSFile, regexp.MustCompile(`SSL error|Failed to setup SSL|Unable to get (certificate|private key)`)},
+	}
+)
+
+// Diagnose parses the lines of MariaDB's and MyRocks' error log into events.
+// Each kind of event except EventError is reported at most once.
+func Diagnose(errorLog string) []Event {
+	var events []Event
+	seen := map[EventKind]int{}
+	add := func(event Event) {
+		if i, ok := seen[event.Kind]; ok && event.Kind != EventError {
+			// merge additional information into the first event of this kind
+			if events[i].Port == 0 {
+				events[i].Port = event.Port
+			}
+			return
+		}
+		seen[event.Kind] = len(events)
+		events = append(events, event)
+	}
+
+	for _, line := range strings.Split(errorLog, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		level, message := "", line
+		if matches := logLinePattern.FindStringSubmatch(line); matches != nil {
+			level, message = strings.ToUpper(matches[1]), matches[2]
+		}
+
+		kind := EventKind("")
+		for _, p := range eventPatterns {
+			if p.pattern.MatchString(message) {
+				kind = p.kind
+				break
+			}
+		}
+		switch {
+		case kind == EventPortInUse:
+			event := Event{Kind: kind, Message: message}
+			if matches := portPattern.FindStringSubmatch(message); matches != nil {
+				event.Port, _ = strconv.Atoi(matches[1])
+			}
+			add(event)
+		case kind != "":
+			add(Event{Kind: kind, Message: message})
+		case level == "ERROR" && message != "Aborting":
+			add(Event{Kind: EventError, Message: message})
+		}
+	}
+	return events
+}
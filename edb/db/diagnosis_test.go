@@ -0,0 +1,89 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	testCases := map[string]struct {
+		errorLog string
+		kind     EventKind
+		port     int
+	}{
+		"wrong key": {
+			errorLog: `2021-05-10 10:00:00 0 [ERROR] RocksDB: Error opening instance, Status Code: 2, Status: Corruption: Bad table magic number
+2021-05-10 10:00:00 0 [ERROR] Plugin 'ROCKSDB' init function returned error.
+2021-05-10 10:00:00 0 [ERROR] Plugin 'ROCKSDB' registration as a STORAGE ENGINE failed.
+2021-05-10 10:00:00 0 [ERROR] Aborting`,
+			kind: EventWrongKeyOrCorruption,
+		},
+		"port in use": {
+			errorLog: `2021-05-10 10:00:00 0 [ERROR] Can't start server: Bind on TCP/IP port. Got error: 98: Address already in use
+2021-05-10 10:00:00 0 [ERROR] Do you already have another mysqld server running on port: 3306 ?
+2021-05-10 10:00:00 0 [ERROR] Aborting`,
+			kind: EventPortInUse,
+			port: 3306,
+		},
+		"out of memory": {
+			errorLog: "2021-05-10 10:00:00 0 [ERROR] mysqld: Out of memory (Needed 16384 bytes)",
+			kind:     EventOutOfMemory,
+		},
+		"disk full": {
+			errorLog: "2021-05-10 10:00:00 0 [ERROR] RocksDB: Error writing to file: IO error: No space left on device",
+			kind:     EventDiskFull,
+		},
+		"tls file": {
+			errorLog: "2021-05-10 10:00:00 0 [Warning] SSL error: Unable to get certificate from '/tmp/edb/cert.pem'",
+			kind:     EventTLSFile,
+		},
+		"other error": {
+			errorLog: "2021-05-10 10:00:00 0 [ERROR] something else\n2021-05-10 10:00:00 0 [Note] fine",
+			kind:     EventError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			events := Diagnose(tc.errorLog)
+			require.Len(events, 1)
+			event := events[0]
+			assert.Equal(tc.kind, event.Kind)
+			assert.Equal(tc.port, event.Port)
+			assert.NotEmpty(event.Description())
+		})
+	}
+}
+
+func TestDiagnoseNoErrors(t *testing.T) {
+	assert.Empty(t, Diagnose("2021-05-10 10:00:00 0 [Note] mysqld: ready for connections.\n"))
+}
+
+func TestDiagnosisError(t *testing.T) {
+	statement := 1
+	err := &DiagnosisError{Events: []Event{
+		{Kind: EventManifestSQL, Code: 1064, Message: "syntax error near 'TABL t' at line 1", Statement: &statement, StatementText: "CREATE TABL t"},
+		{Kind: EventError, Message: "something else"},
+	}}
+	assert.Equal(t, `manifest SQL statement 1 ("CREATE TABL t") failed with error 1064: syntax error near 'TABL t' at line 1; something else`, err.Error())
+}
@@ -0,0 +1,159 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// queryDigestsSize is the maximum number of distinct digests. Statements with further digests are aggregated in a row without digest.
+const queryDigestsSize = 1000
+
+// queryDigestLength is the maximum number of bytes of a normalized statement. Longer statements are truncated.
+const queryDigestLength = 1024
+
+// ErrQueryDigestsNotConfigured is returned if the collection of query digests hasn't been configured when the database has been started.
+var ErrQueryDigestsNotConfigured = errors.New("query digests are collected after edb has been restarted")
+
+// QueryDigest holds the statistics of the statements that only differ in their literals.
+type QueryDigest struct {
+	// Schema is the default database of the statements.
+	Schema string `json:",omitempty"`
+	// Digest is the hash of the normalized statement. It's empty for the statements that exceed queryDigestsSize.
+	Digest string `json:",omitempty"`
+	// Statement is the normalized statement. Literals are replaced by "?".
+	Statement    string `json:",omitempty"`
+	Count        uint64
+	TotalTime    time.Duration
+	MaxTime      time.Duration
+	RowsSent     uint64
+	RowsExamined uint64
+	RowsAffected uint64
+	Errors       uint64
+	FirstSeen    time.Time
+	LastSeen     time.Time
+}
+
+// EnableQueryDigests configures MariaDB to collect query digests. It must be called before the database is started.
+// The digests are only collected if the manifest allows it.
+func (d *Mariadb) EnableQueryDigests() {
+	d.queryDigests = true
+}
+
+// queryDigestOptions returns the options of MariaDB's performance schema for query digests.
+// The digest consumer is disabled until the manifest has been checked. No instrument records the text of a statement.
+// The performance schema allocates its buffers in enclave memory at startup, so all buffers that digests don't need are sized to 0.
+// What remains are the digest table and the per-thread buffers of the statement instruments.
+func queryDigestOptions() string {
+	return `
+performance_schema=ON
+performance_schema_instrument='%=OFF'
+performance_schema_instrument='statement/%=ON'
+performance_schema_consumer_events_statements_current=OFF
+performance_schema_consumer_events_statements_history=OFF
+performance_schema_consumer_events_statements_history_long=OFF
+performance_schema_consumer_statements_digest=OFF
+performance_schema_max_sql_text_length=0
+performance_schema_max_prepared_statements_instances=0
+performance_schema_accounts_size=0
+performance_schema_hosts_size=0
+performance_schema_users_size=0
+performance_schema_events_stages_history_size=0
+performance_schema_events_stages_history_long_size=0
+performance_schema_events_statements_history_size=0
+performance_schema_events_statements_history_long_size=0
+performance_schema_events_transactions_history_size=0
+performance_schema_events_transactions_history_long_size=0
+performance_schema_events_waits_history_size=0
+performance_schema_events_waits_history_long_size=0
+performance_schema_max_cond_classes=0
+performance_schema_max_cond_instances=0
+performance_schema_max_file_classes=0
+performance_schema_max_file_handles=0
+performance_schema_max_file_instances=0
+performance_schema_max_index_stat=0
+performance_schema_max_memory_classes=0
+performance_schema_max_metadata_locks=0
+performance_schema_max_mutex_classes=0
+performance_schema_max_mutex_instances=0
+performance_schema_max_program_instances=0
+performance_schema_max_rwlock_classes=0
+performance_schema_max_rwlock_instances=0
+performance_schema_max_socket_classes=0
+performance_schema_max_socket_instances=0
+performance_schema_max_stage_classes=0
+performance_schema_max_table_handles=0
+performance_schema_max_table_instances=0
+performance_schema_max_table_lock_stat=0
+performance_schema_session_connect_attrs_size=0
+` + fmt.Sprintf("performance_schema_digests_size=%v\n", queryDigestsSize) +
+		fmt.Sprintf("performance_schema_max_digest_length=%v\nmax_digest_length=%v\n", queryDigestLength, queryDigestLength)
+}
+
+// startQueryDigests starts collecting query digests if they have been configured and the manifest allows it.
+func (d *Mariadb) startQueryDigests(man manifest) error {
+	if !d.queryDigests || !man.QueryDigests {
+		return nil
+	}
+	return d.execInternal("UPDATE performance_schema.setup_consumers SET ENABLED = 'YES' WHERE NAME = 'statements_digest'")
+}
+
+// GetQueryDigests returns up to limit query digests, the ones with the highest total time first.
+func (d *Mariadb) GetQueryDigests(limit int) ([]QueryDigest, error) {
+	if !d.queryDigests {
+		return nil, ErrQueryDigestsNotConfigured
+	}
+	digests := []QueryDigest{}
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(context.Background(), fmt.Sprintf(
+			"SELECT SCHEMA_NAME, DIGEST, DIGEST_TEXT, COUNT_STAR, SUM_TIMER_WAIT, MAX_TIMER_WAIT, SUM_ROWS_SENT, SUM_ROWS_EXAMINED, SUM_ROWS_AFFECTED, SUM_ERRORS, "+
+				"UNIX_TIMESTAMP(FIRST_SEEN), UNIX_TIMESTAMP(LAST_SEEN) "+
+				"FROM performance_schema.events_statements_summary_by_digest ORDER BY SUM_TIMER_WAIT DESC LIMIT %d", limit))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var digest QueryDigest
+			var schema, hash, statement sql.NullString
+			var totalTime, maxTime uint64 // in picoseconds
+			var firstSeen, lastSeen float64
+			if err := rows.Scan(&schema, &hash, &statement, &digest.Count, &totalTime, &maxTime, &digest.RowsSent, &digest.RowsExamined, &digest.RowsAffected, &digest.Errors, &firstSeen, &lastSeen); err != nil {
+				return err
+			}
+			digest.Schema, digest.Digest, digest.Statement = schema.String, hash.String, statement.String
+			digest.TotalTime = time.Duration(totalTime / 1000)
+			digest.MaxTime = time.Duration(maxTime / 1000)
+			digest.FirstSeen, digest.LastSeen = unixTime(firstSeen), unixTime(lastSeen)
+			digests = append(digests, digest)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+func unixTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
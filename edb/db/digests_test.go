@@ -0,0 +1,42 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryDigestOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	options := queryDigestOptions()
+	assert.Contains(options, "performance_schema=ON\n")
+	// the manifest must be checked before digests are collected, and statement texts must never be recorded
+	assert.Contains(options, "performance_schema_consumer_statements_digest=OFF\n")
+	assert.Contains(options, "performance_schema_consumer_events_statements_history=OFF\n")
+	assert.Contains(options, "performance_schema_max_sql_text_length=0\n")
+	// buffers in enclave memory are bounded
+	assert.Contains(options, "performance_schema_digests_size=1000\n")
+	assert.Contains(options, "performance_schema_events_statements_history_long_size=0\n")
+	assert.Contains(options, "performance_schema_max_digest_length=1024\n")
+}
+
+func TestUnixTime(t *testing.T) {
+	assert.Equal(t, time.Date(2023, 1, 2, 3, 4, 5, 500000000, time.UTC), unixTime(1672628645.5))
+}
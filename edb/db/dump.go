@@ -0,0 +1,347 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// excludedDatabases aren't dumped. The privileges are dumped separately from the privilege tables of the mysql database.
+var excludedDatabases = map[string]bool{"mysql": true, "information_schema": true, "performance_schema": true, "sys": true, "$edgeless": true}
+
+// privilegeTables hold the users, roles, and their privileges.
+var privilegeTables = []string{"global_priv", "db", "tables_priv", "columns_priv", "procs_priv", "proxies_priv", "roles_mapping"}
+
+// maxInsertSize is the size at which a multi-row INSERT statement of a dump is split.
+const maxInsertSize = 1 << 20
+
+// Dump writes a consistent logical dump of the user databases and the privileges to w.
+// The dump is a stream of JSON-encoded SQL statements. Triggers, stored routines, and events aren't included.
+func (d *Mariadb) Dump(w io.Writer) error {
+	return d.withDumpConn(func(conn *sql.Conn) error {
+		ctx := context.Background()
+		// MyRocks provides a consistent snapshot of all tables in repeatable read transactions.
+		if _, err := conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "ROLLBACK")
+
+		dumper := dumper{ctx: ctx, conn: conn, enc: json.NewEncoder(w)}
+		return dumper.dump()
+	})
+}
+
+// importDump executes a dump that has been created by Dump.
+func importDump(conn *sql.Conn, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		var statement string
+		if err := dec.Decode(&statement); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(context.Background(), statement); err != nil {
+			return fmt.Errorf("statement %v of the dump failed: %w", i, err)
+		}
+	}
+}
+
+func (d *Mariadb) withDumpConn(f func(conn *sql.Conn) error) error {
+	d.dumpConnMutex.Lock()
+	defer d.dumpConnMutex.Unlock()
+	if d.dumpConn == nil {
+		return errors.New("database is not running")
+	}
+	return f(d.dumpConn)
+}
+
+type dumper struct {
+	ctx  context.Context
+	conn *sql.Conn
+	enc  *json.Encoder
+}
+
+type dumpColumn struct {
+	name    string
+	binary  bool // the column has no character set, i.e., values must not be converted
+	bit     bool
+	ignored bool // generated columns can't be inserted
+}
+
+func (d dumper) dump() error {
+	if err := d.write(
+		"SET SESSION FOREIGN_KEY_CHECKS=0",
+		"SET SESSION UNIQUE_CHECKS=0",
+		"SET SESSION SQL_MODE='NO_AUTO_VALUE_ON_ZERO'",
+	); err != nil {
+		return err
+	}
+
+	databases, err := d.queryStrings("SELECT SCHEMA_NAME FROM information_schema.SCHEMATA ORDER BY SCHEMA_NAME")
+	if err != nil {
+		return err
+	}
+
+	// Views may depend on tables of other databases, so create them after all tables.
+	var views [][2]string
+	for _, database := range databases {
+		if excludedDatabases[database] {
+			continue
+		}
+		if err := d.dumpDatabase(database, &views); err != nil {
+			return err
+		}
+	}
+	for _, view := range views {
+		if err := d.dumpView(view[0], view[1]); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range privilegeTables {
+		if err := d.write("DELETE FROM mysql." + quoteIdentifier(table)); err != nil {
+			return err
+		}
+		if err := d.dumpRows("mysql", table); err != nil {
+			return err
+		}
+	}
+
+	return d.write(
+		"FLUSH PRIVILEGES",
+		"SET SESSION FOREIGN_KEY_CHECKS=1",
+		"SET SESSION UNIQUE_CHECKS=1",
+		"SET SESSION SQL_MODE=DEFAULT",
+	)
+}
+
+func (d dumper) dumpDatabase(database string, views *[][2]string) error {
+	var name, createDatabase string
+	if err := d.conn.QueryRowContext(d.ctx, "SHOW CREATE DATABASE IF NOT EXISTS "+quoteIdentifier(database)).Scan(&name, &createDatabase); err != nil {
+		return err
+	}
+	if err := d.write(createDatabase, "USE "+quoteIdentifier(database)); err != nil {
+		return err
+	}
+
+	rows, err := d.conn.QueryContext(d.ctx, "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME", database)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table, tableType string
+		if err := rows.Scan(&table, &tableType); err != nil {
+			rows.Close()
+			return err
+		}
+		if tableType == "VIEW" {
+			*views = append(*views, [2]string{database, table})
+		} else {
+			tables = append(tables, table)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		var name, createTable string
+		if err := d.conn.QueryRowContext(d.ctx, "SHOW CREATE TABLE "+qualifiedName(database, table)).Scan(&name, &createTable); err != nil {
+			return err
+		}
+		if err := d.write("DROP TABLE IF EXISTS "+quoteIdentifier(table), createTable); err != nil {
+			return err
+		}
+		if err := d.dumpRows(database, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d dumper) dumpView(database, view string) error {
+	var name, createView, charset, collation string
+	if err := d.conn.QueryRowContext(d.ctx, "SHOW CREATE VIEW "+qualifiedName(database, view)).Scan(&name, &createView, &charset, &collation); err != nil {
+		return err
+	}
+	return d.write("USE "+quoteIdentifier(database), "DROP VIEW IF EXISTS "+quoteIdentifier(view), createView)
+}
+
+func (d dumper) dumpRows(database, table string) error {
+	columns, err := d.getColumns(database, table)
+	if err != nil {
+		return err
+	}
+
+	var names, selects []string
+	for _, column := range columns {
+		if column.ignored {
+			continue
+		}
+		names = append(names, quoteIdentifier(column.name))
+		if column.bit {
+			selects = append(selects, "BIN("+quoteIdentifier(column.name)+")")
+		} else {
+			selects = append(selects, quoteIdentifier(column.name))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	rows, err := d.conn.QueryContext(d.ctx, "SELECT "+strings.Join(selects, ",")+" FROM "+qualifiedName(database, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	prefix := "INSERT INTO " + qualifiedName(database, table) + " (" + strings.Join(names, ",") + ") VALUES "
+	var insert strings.Builder
+	values := make([]sql.RawBytes, len(names))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if insert.Len() == 0 {
+			insert.WriteString(prefix)
+		} else {
+			insert.WriteByte(',')
+		}
+		insert.WriteByte('(')
+		i := 0
+		for _, column := range columns {
+			if column.ignored {
+				continue
+			}
+			if i > 0 {
+				insert.WriteByte(',')
+			}
+			insert.WriteString(sqlLiteral(values[i], column))
+			i++
+		}
+		insert.WriteByte(')')
+
+		if insert.Len() >= maxInsertSize {
+			if err := d.write(insert.String()); err != nil {
+				return err
+			}
+			insert.Reset()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if insert.Len() > 0 {
+		return d.write(insert.String())
+	}
+	return nil
+}
+
+func (d dumper) getColumns(database, table string) ([]dumpColumn, error) {
+	rows, err := d.conn.QueryContext(d.ctx, "SELECT COLUMN_NAME, DATA_TYPE, CHARACTER_SET_NAME, IS_GENERATED FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", database, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []dumpColumn
+	for rows.Next() {
+		var name, dataType, generated string
+		var charset sql.NullString
+		if err := rows.Scan(&name, &dataType, &charset, &generated); err != nil {
+			return nil, err
+		}
+		columns = append(columns, dumpColumn{
+			name:    name,
+			binary:  !charset.Valid,
+			bit:     strings.EqualFold(dataType, "bit"),
+			ignored: generated == "ALWAYS",
+		})
+	}
+	return columns, rows.Err()
+}
+
+func (d dumper) queryStrings(query string) ([]string, error) {
+	rows, err := d.conn.QueryContext(d.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func (d dumper) write(statements ...string) error {
+	for _, statement := range statements {
+		if err := d.enc.Encode(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlLiteral returns the SQL literal of a value that has been read with the text protocol.
+func sqlLiteral(value []byte, column dumpColumn) string {
+	switch {
+	case value == nil:
+		return "NULL"
+	case column.bit:
+		// selected as BIN()
+		return "b'" + string(value) + "'"
+	case column.binary:
+		return "_binary'" + escapeString(value) + "'"
+	default:
+		return "'" + escapeString(value) + "'"
+	}
+}
+
+var stringEscaper = strings.NewReplacer("\\", "\\\\", "'", "\\'", "\x00", "\\0", "\n", "\\n", "\r", "\\r", "\x1a", "\\Z")
+
+func escapeString(value []byte) string {
+	return stringEscaper.Replace(string(value))
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func qualifiedName(database, table string) string {
+	return quoteIdentifier(database) + "." + quoteIdentifier(table)
+}
@@ -0,0 +1,51 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLLiteral(t *testing.T) {
+	testCases := map[string]struct {
+		value  []byte
+		column dumpColumn
+		want   string
+	}{
+		"null":        {value: nil, want: "NULL"},
+		"empty":       {value: []byte{}, want: "''"},
+		"string":      {value: []byte("it's a\\b\n"), want: `'it\'s a\\b\n'`},
+		"zero byte":   {value: []byte{'a', 0, 0x1a, '\r'}, want: `'a\0\Z\r'`},
+		"binary":      {value: []byte{0xff, '\''}, column: dumpColumn{binary: true}, want: "_binary'\xff\\''"},
+		"bit":         {value: []byte("101"), column: dumpColumn{bit: true}, want: "b'101'"},
+		"binary null": {value: nil, column: dumpColumn{binary: true}, want: "NULL"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, sqlLiteral(tc.value, tc.column))
+		})
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("`t`", quoteIdentifier("t"))
+	assert.Equal("`a``b`", quoteIdentifier("a`b"))
+	assert.Equal("`d`.`t`", qualifiedName("d", "t"))
+}
@@ -15,22 +15,99 @@
 
 package db
 
-import "crypto"
+import (
+	"crypto"
+	"io"
+	"time"
+)
 
 // Database is a secure database that can be initialized by a manifest.
 type Database interface {
 	// GetCertificate gets the database certificate.
 	GetCertificate() ([]byte, crypto.PrivateKey)
-	// Initialize sets up a database according to the jsonManifest.
+	// GetCertificateChain gets the certificates that must be presented along with a certificate issued by the database certificate.
+	GetCertificateChain() [][]byte
+	// RenewCertificate replaces the database certificate and its key by a new self-signed certificate.
+	RenewCertificate(overlap time.Duration) error
+	// CreateCertificateRequest creates a CSR for a new database certificate.
+	CreateCertificateRequest() ([]byte, error)
+	// SetCertificate replaces the database certificate by chain[0], which must have been issued for the key of the last CSR.
+	SetCertificate(chain [][]byte, overlap time.Duration) error
+	// Initialize sets up a database according to the jsonManifest and starts it.
+	// If it fails after the database has been started, it removes the partially initialized database and returns a DiagnosisError.
 	Initialize(jsonManifest []byte) error
+	// Restore sets up a database like Initialize and imports a dump that has been created by Dump.
+	// If the import fails, it removes the partially restored database like a failed initialization.
+	Restore(jsonManifest []byte, dump io.Reader) error
 	// Start starts the database.
 	Start() error
+	// Stop shuts down the database cleanly. It returns an error if the database hasn't exited within the timeout.
+	Stop(timeout time.Duration) error
 	// GetManifestSignature returns the signature of the manifest that has been used to initialize the database.
 	GetManifestSignature() []byte
+	// GetManifest returns the manifest that has been used to initialize the database.
+	GetManifest() []byte
+	// UpdateCAs replaces the CA certificates that have been added at runtime by the JSON-encoded list of CAs, which have the format of the manifest's cas.
+	UpdateCAs(jsonCAs []byte) error
+	// UpdateCRL replaces the certificate revocation list used to verify client certificates.
+	UpdateCRL(crl []byte) error
+	// GetThreadPoolStatus returns the configuration and current usage of the thread pool.
+	GetThreadPoolStatus() (ThreadPoolStatus, error)
+	// Dump writes a consistent logical dump of the database to w.
+	Dump(w io.Writer) error
+	// CreateCheckpoint creates a RocksDB checkpoint of the database in the directory path, which must not exist yet.
+	// If the binary log is enabled, it returns the position in the binary log right after the checkpoint.
+	CreateCheckpoint(path string) (BinlogPosition, error)
+	// GetBinlogTimeline returns the timeline of the binary logs written since the database has been started
+	// and the timeline of the previous run, if any.
+	GetBinlogTimeline() (timeline, previous string)
+	// RotateBinlog closes the current binary log. It returns the paths of the closed binary logs, oldest first, and the name of the new one.
+	RotateBinlog() (closed []string, current string, err error)
+	// PurgeBinlogs deletes the binary logs before the one with the given name.
+	PurgeBinlogs(to string) error
+	// BinlogFiles returns the paths of all binary logs, oldest first. It can be used after the database has been stopped.
+	BinlogFiles() ([]string, error)
+	// ReplayBinlog executes the transactions of a binary log that started at or before until.
+	// It returns the start time of the last executed transaction and whether the whole binary log has been executed.
+	ReplayBinlog(r io.Reader, until time.Time) (last time.Time, complete bool, err error)
+	// EnableReplication lets replicas that authenticate with the password follow the binary log. It must be called before the database is started.
+	EnableReplication(password string)
+	// SetReplicationSource makes the database a read-only replica of the source. It must be called before the database is started.
+	SetReplicationSource(source ReplicationSource)
+	// GetReplicationStatus returns the role of the database and the state of the replication.
+	GetReplicationStatus() (ReplicationStatus, error)
+	// Promote stops the replication and makes the replica a writable primary.
+	Promote() error
+	// SetReadOnly enables or disables the read-only mode. If persist is true, the mode is restored when the database is started again.
+	SetReadOnly(readOnly, persist bool) error
+	// GetReadOnlyStatus returns the read-only mode that has been set by SetReadOnly.
+	GetReadOnlyStatus() ReadOnlyStatus
+	// AppendAuditEntry appends an entry to the audit log. It requires an initialized database.
+	AppendAuditEntry(entry AuditEntry) error
+	// GetAuditEntries returns the entries of the audit log, oldest first.
+	GetAuditEntries() ([]AuditEntry, error)
+	// GetLastAuditEntry returns the newest entry of the audit log. Content is nil if the log is empty.
+	GetLastAuditEntry() (AuditEntry, error)
+	// EnableQueryDigests configures the collection of query digests. It must be called before the database is started.
+	// The digests are only collected if the manifest allows it.
+	EnableQueryDigests()
+	// GetQueryDigests returns up to limit query digests, the ones with the highest total time first.
+	GetQueryDigests(limit int) ([]QueryDigest, error)
+}
+
+// BinlogPosition is a position in the binary log.
+type BinlogPosition struct {
+	// File is the name of the binary log that starts at the position.
+	File string
+	// GTID is the global transaction ID of the last transaction before the position.
+	GTID string
 }
 
 type manifest struct {
-	SQL   []string
-	CA    string
-	Debug bool
+	SQL          []string
+	CA           string
+	CAs          []caBundleEntry
+	CRL          string
+	Debug        bool
+	QueryDigests bool
 }
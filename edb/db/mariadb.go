@@ -18,41 +18,58 @@ package db
 //go:generate sh -c "./mariadb_gen_bootstrap.sh ../../3rdparty/edgeless-mariadb > mariadbbootstrap.go"
 
 import (
+	"context"
 	"crypto"
 	"crypto/sha256"
 	"crypto/x509"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/edgelesssys/edgelessdb/edb/rt"
-	_ "github.com/go-sql-driver/mysql" // import driver used via the database/sql package
+	"github.com/edgelesssys/edgelessdb/edb/util"
+	"github.com/go-sql-driver/mysql"
 )
 
 const edbInternalAddr = "EDB_INTERNAL_ADDR" // must be kept sync with src/mysqld_edb.cc
 
+// stopTimeoutAfterFailedInit is the time MariaDB may take to shut down before the partially initialized database is removed.
+const stopTimeoutAfterFailedInit = 30 * time.Second
+
+// mysqlErrConCount is the error MariaDB returns when max_connections has been reached.
+const mysqlErrConCount = 1040
+
 const (
 	filenameCA           = "ca.pem"
 	filenameCert         = "cert.pem"
 	filenameKey          = "key.pem"
+	filenameCRL          = "ssl-crl"
 	filenameCnf          = "my.cnf"
-	filenameInit         = "init.sql"
-	filenameBootstrapLog = "mariadb-error.log"
 	filenameGeneralLog   = "mariadb.log"
 	filenameSlowQueryLog = "mariadb-slow.log"
 	filenameBinaryLog    = "mariadb-binary.log"
 	FilenameErrorLog     = "mariadb.err" // this one is public as we try to parse it from elsewhere in case MariaDB directly tries to call exit() due to an error
 )
 
+// Loggers of the database component. The phase tells which part of the database's lifecycle an entry belongs to.
+var (
+	log         = rt.Log.With("component", "db")
+	initLog     = log.With("phase", "initialization")
+	startLog    = log.With("phase", "startup")
+	shutdownLog = log.With("phase", "shutdown")
+)
+
 // ErrPreviousInitFailed is thrown when a previous initialization attempt failed, but another init or start is attempted.
 var ErrPreviousInitFailed = errors.New("a previous initialization attempt failed")
 
@@ -64,37 +81,73 @@ type Mariadbd interface {
 	Main(cnfPath string) int
 	WaitUntilStarted()
 	WaitUntilListenInternalReady()
+	SetSSLCRL(path string)
+	// PrepareShutdown makes mariadbd send its exit status on the returned channel instead of terminating the process.
+	PrepareShutdown() <-chan int
 }
 
 // Mariadb is a secure database based on MariaDB.
 type Mariadb struct {
-	internalPath, externalPath       string
-	internalAddress, externalAddress string
-	debug                            bool
-	debugLogDir                      string
-	mariadbd                         Mariadbd
-	maxPoolThreads                   int
-	cert                             []byte
-	key                              crypto.PrivateKey
-	manifestSig                      []byte
-	ca                               string
-	attemptedInit                    bool
+	internalPath, externalPath string
+	internalAddress            string
+	externalAddresses          []string
+	debug                      bool
+	debugLogDir                string
+	mariadbd                   Mariadbd
+	threadPool                 ThreadPoolConfig
+	tlsPolicy                  util.TLSPolicy
+	isMarble                   bool
+	cert                       []byte
+	key                        crypto.PrivateKey
+	chain                      [][]byte
+	crossCert                  []byte
+	crossCertNotAfter          time.Time
+	overlapTimer               *time.Timer // ends the overlap after a renewal
+	certMutex                  sync.RWMutex
+	cas                        caBundle
+	crl                        []byte
+	casMutex                   sync.Mutex    // guards cas and crl
+	casChanged                 chan struct{} // wakes up rotateCAs when CAs have been added at runtime
+	attemptedInit              bool
+	internalConn               *sql.Conn
+	internalConnMutex          sync.Mutex
+	dumpConn                   *sql.Conn
+	dumpConnMutex              sync.Mutex
+	manifest                   []byte
+	manifestSig                []byte
+	manifestMutex              sync.RWMutex // guards manifest and manifestSig, which are set together
+	binlog                     bool
+	binlogTimeline             string
+	binlogPrevious             string
+	replicationPassword        string
+	joinSource                 *ReplicationSource
+	replicaOf                  *ReplicationSource // nil if the database is a primary
+	readOnly                   ReadOnlyStatus
+	readOnlyMutex              sync.Mutex
+	queryDigests               bool // performance schema is configured for query digests
+	mainExited                 chan int
+	stopping                   int32
+	done                       chan struct{} // closed by Stop to end the background tasks of the running database
+	exitHandler                func(status int)
 }
 
 // NewMariadb creates a new Mariadb object.
-func NewMariadb(internalPath, externalPath, internalAddress, externalAddress, certificateDNSName, logDir string, debug bool, isMarble bool, mariadbd Mariadbd, maxPoolThreads int) (*Mariadb, error) {
+func NewMariadb(internalPath, externalPath, internalAddress string, externalAddresses []string, certificateDNSName, logDir string, debug bool, isMarble bool, mariadbd Mariadbd, threadPool ThreadPoolConfig, tlsPolicy util.TLSPolicy) (*Mariadb, error) {
 	if err := os.MkdirAll(externalPath, 0o700); err != nil {
 		return nil, err
 	}
 	d := &Mariadb{
-		internalPath:    internalPath,
-		externalPath:    externalPath,
-		internalAddress: internalAddress,
-		externalAddress: externalAddress,
-		debug:           debug,
-		debugLogDir:     logDir,
-		mariadbd:        mariadbd,
-		maxPoolThreads:  maxPoolThreads,
+		internalPath:      internalPath,
+		externalPath:      externalPath,
+		internalAddress:   internalAddress,
+		externalAddresses: externalAddresses,
+		debug:             debug,
+		debugLogDir:       logDir,
+		mariadbd:          mariadbd,
+		threadPool:        threadPool,
+		tlsPolicy:         tlsPolicy,
+		isMarble:          isMarble,
+		casChanged:        make(chan struct{}, 1),
 	}
 
 	var cert []byte
@@ -102,7 +155,7 @@ func NewMariadb(internalPath, externalPath, internalAddress, externalAddress, ce
 	var err error
 	if isMarble {
 		// When running under Marblerun, expect that it passes edb's root certificate + private key
-		rt.Log.Println("parsing root certificate passed from Marblerun")
+		startLog.Info("parsing root certificate passed from Marblerun")
 		cert, key, err = setupCertificateFromMarblerun()
 	} else {
 		// Otherweise in standalone mode, we generate this here
@@ -117,18 +170,23 @@ func NewMariadb(internalPath, externalPath, internalAddress, externalAddress, ce
 	return d, nil
 }
 
-// GetCertificate gets the database certificate.
-func (d *Mariadb) GetCertificate() ([]byte, crypto.PrivateKey) {
-	return d.cert, d.key
+// Initialize sets up a database according to the jsonManifest and starts it.
+func (d *Mariadb) Initialize(jsonManifest []byte) error {
+	return d.initialize(jsonManifest, nil)
 }
 
-// Initialize sets up a database according to the jsonManifest.
-func (d *Mariadb) Initialize(jsonManifest []byte) error {
-	if d.manifestSig != nil {
+// Restore sets up a database like Initialize and imports a dump that has been created by Dump.
+func (d *Mariadb) Restore(jsonManifest []byte, dump io.Reader) error {
+	return d.initialize(jsonManifest, dump)
+}
+
+// initialize imports the dump, if any, before the database is marked as initialized.
+func (d *Mariadb) initialize(jsonManifest []byte, dump io.Reader) error {
+	if d.GetManifestSignature() != nil {
 		return errors.New("already initialized")
 	}
 	if d.attemptedInit {
-		rt.Log.Println("Cannot initialize the database, a previous attempt failed. The DB is in an inconsistent state. Please provide an empty data directory.")
+		initLog.Error("cannot initialize the database, a previous attempt failed; retry after edb has restarted")
 		return ErrPreviousInitFailed
 	}
 
@@ -141,65 +199,216 @@ func (d *Mariadb) Initialize(jsonManifest []byte) error {
 		return fmt.Errorf("edb was started in debug mode but the manifest does not allow debug mode")
 	}
 
-	if err := d.configureBootstrap(man.SQL, jsonManifest); err != nil {
+	cas := newCABundle(man)
+	if err := cas.validate(); err != nil {
 		return err
 	}
 
-	rt.Log.Println("initializing ...")
+	if man.CRL != "" {
+		crls, err := parseCRLs([]byte(man.CRL), cas.all())
+		if err != nil {
+			return err
+		}
+		if err := checkCRLUpdate(nil, crls); err != nil {
+			return err
+		}
+	}
 
-	// Remove already existing log file, as we do not want replayed logs
-	err := os.Remove(filepath.Join(d.internalPath, filenameBootstrapLog))
-	if err != nil && !os.IsNotExist(err) {
+	// Remember the existing entries of the data directory so that we can clean up after a failed initialization
+	existingEntries, err := readDirNames(d.externalPath)
+	if err != nil {
 		return err
 	}
 
+	initLog.Info("initializing ...")
 	d.attemptedInit = true
+	internalAddr, err := d.launch()
+	if err != nil {
+		return err
+	}
+
+	// errors are unrecoverable until MariaDB has started
+
+	// Like the internal connection, this one is established before ACL is active and will thus keep full privileges.
+	// It runs the bootstrap and the manifest statements in one session.
+	conn, err := connect(internalAddr, "multiStatements=true")
+	if err != nil {
+		initLog.Fatal("connecting to the database failed", "error", err)
+	}
+	defer conn.Close()
+	// Point-in-time recovery starts from a snapshot of an initialized database, so the initialization doesn't need to be logged.
+	if d.binlog {
+		if _, err := conn.ExecContext(context.Background(), "SET SESSION sql_log_bin=0"); err != nil {
+			initLog.Fatal("disabling the binary log failed", "error", err)
+		}
+	}
+	if _, err := conn.ExecContext(context.Background(), "CREATE DATABASE mysql;\nUSE mysql;\n"+mariadbBootstrap); err != nil {
+		initLog.Fatal("bootstrap failed", "error", err)
+	}
+
+	d.setCABundle(cas)
+	if err := d.writeCertificates(); err != nil {
+		panic(err)
+	}
+	if err := d.installCRL([]byte(man.CRL)); err != nil {
+		panic(err)
+	}
+
+	// MariaDB must load the privilege tables that have just been created, which the manifest statements require.
+	// This also opens the external port, so clients are refused until the database has been fully initialized.
+	unblock, err := d.blockConnections(conn, internalAddr)
+	if err != nil {
+		initLog.Fatal("blocking client connections failed", "error", err)
+	}
+	d.completeStartup(internalAddr)
+
+	if err := executeManifest(conn, man.SQL); err != nil {
+		return d.cleanUpFailedInit(err, existingEntries)
+	}
+	// The dump creates users, too, so clients stay blocked until it has been imported and the config has been saved.
+	if dump != nil {
+		if err := importDump(conn, dump); err != nil {
+			return d.cleanUpFailedInit(fmt.Errorf("importing the backup: %w", err), existingEntries)
+		}
+	}
+	if err := d.saveConfig(jsonManifest); err != nil {
+		return d.cleanUpFailedInit(err, existingEntries)
+	}
+	if err := d.startQueryDigests(man); err != nil {
+		return d.cleanUpFailedInit(err, existingEntries)
+	}
+	if err := d.startBinlogTimeline(); err != nil {
+		return d.cleanUpFailedInit(err, existingEntries)
+	}
+	if err := d.startReplication(); err != nil {
+		return d.cleanUpFailedInit(err, existingEntries)
+	}
+	if err := unblock(); err != nil {
+		return d.cleanUpFailedInit(err, existingEntries)
+	}
+
+	d.setManifest(jsonManifest)
+	go d.rotateCAs()
+	initLog.Info("DB is running.")
+	return nil
+}
+
+// executeManifest executes the manifest statements. It reports the failed statement as a DiagnosisError.
+func executeManifest(conn *sql.Conn, statements []string) error {
+	for i, statement := range statements {
+		if _, err := conn.ExecContext(context.Background(), statement); err != nil {
+			return manifestError(err, i, statement)
+		}
+	}
+	return nil
+}
+
+func manifestError(err error, index int, statement string) error {
+	event := Event{Kind: EventManifestSQL, Message: err.Error(), Statement: &index, StatementText: statement}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		event.Code = int(mysqlErr.Number)
+		event.Message = mysqlErr.Message
+	}
+	return &DiagnosisError{Events: []Event{event}}
+}
+
+// saveConfig saves the certificate, its key, and the manifest. Their presence marks the database as initialized.
+func (d *Mariadb) saveConfig(jsonManifest []byte) error {
+	cert, key := d.GetCertificate()
+	keyRaw, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"CREATE DATABASE $edgeless",
+		"CREATE TABLE $edgeless.config (c BLOB, k BLOB, m BLOB)",
+	} {
+		if err := d.execInternal(query); err != nil {
+			return err
+		}
+	}
+	if err := d.createTables(); err != nil {
+		return err
+	}
+	return d.execInternal("INSERT INTO $edgeless.config VALUES (?, ?, ?)", cert, keyRaw, jsonManifest)
+}
 
-	// Launch MariaDB
-	if err := d.mariadbd.Main(filepath.Join(d.internalPath, filenameCnf)); err != 0 {
-		d.printErrorLog(false)
-		rt.Log.Printf("FATAL: bootstrap failed, MariaDB exited with error code: %d\n", err)
-		panic("bootstrap failed")
+// cleanUpFailedInit stops MariaDB and removes the partially initialized database so that a corrected manifest can be used after a restart.
+// It returns a DiagnosisError if the cleanup succeeded.
+func (d *Mariadb) cleanUpFailedInit(err error, existingEntries []string) error {
+	initLog.Error("initialization failed", "error", err)
+	var diagErr *DiagnosisError
+	if !errors.As(err, &diagErr) {
+		err = &DiagnosisError{Events: []Event{{Kind: EventError, Message: err.Error()}}}
+	}
+	if stopErr := d.Stop(stopTimeoutAfterFailedInit); stopErr != nil {
+		initLog.Error("cannot clean up after the failed initialization; the DB is in an inconsistent state, please provide an empty data directory")
+		return fmt.Errorf("%v; stopping the database failed: %v", err, stopErr)
+	}
+	if cleanupErr := d.removeNewEntries(existingEntries); cleanupErr != nil {
+		initLog.Error("cannot clean up after the failed initialization; the DB is in an inconsistent state, please provide an empty data directory")
+		return fmt.Errorf("%v; cleaning up the data directory failed: %v", err, cleanupErr)
 	}
+	return err
+}
 
-	return d.printErrorLog(true)
+// removeNewEntries removes the entries of the data directory that the failed bootstrap created.
+func (d *Mariadb) removeNewEntries(existingEntries []string) error {
+	entries, err := readDirNames(d.externalPath)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, name := range existingEntries {
+		existing[name] = true
+	}
+	for _, name := range entries {
+		if existing[name] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(d.externalPath, name)); err != nil {
+			return err
+		}
+	}
+	initLog.Info("removed the partially initialized database")
+	return nil
+}
+
+func readDirNames(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
 }
 
 // Start starts the database.
 func (d *Mariadb) Start() error {
 	_, err := os.Stat(filepath.Join(d.externalPath, "#rocksdb"))
 	if os.IsNotExist(err) {
-		rt.Log.Println("DB has not been initialized, waiting for manifest.")
+		startLog.Info("DB has not been initialized, waiting for manifest.")
 		return ErrNotInitializedYet
 	}
 	if err != nil {
 		return err
 	}
 
-	if err := d.configureStart(); err != nil {
-		return err
-	}
-
-	// Set internal addr env var so that mariadb will first listen on that addr. SSL and ACL will not be active at this point,
-	// so we can get the cert and key from the db, write it to the memfs, and then let mariadb complete its startup sequence.
-	normalizedInternalAddr := net.JoinHostPort(splitHostPort(d.internalAddress, "3305"))
-	if err := os.Setenv(edbInternalAddr, normalizedInternalAddr); err != nil {
+	startLog.Info("starting up ...")
+	internalAddr, err := d.launch()
+	if err != nil {
 		return err
 	}
 
-	rt.Log.Println("starting up ...")
-	go func() {
-		ret := d.mariadbd.Main(filepath.Join(d.internalPath, filenameCnf))
-		panic(fmt.Errorf("mariadbd.Main returned unexpectedly with %v", ret))
-	}()
-	d.mariadbd.WaitUntilListenInternalReady()
-
 	// errors are unrecoverable from here
 
-	cert, key, jsonManifest, err := getConfigFromSQL(normalizedInternalAddr)
+	cert, key, jsonManifest, err := d.getConfigFromSQL()
 	if err != nil {
-		rt.Log.Println("An initialization attempt failed. The DB is in an inconsistent state. Please provide an empty data directory.")
-		rt.Log.Fatalln(err)
+		startLog.Fatal("an initialization attempt failed; the DB is in an inconsistent state, please provide an empty data directory", "error", err)
 	}
 
 	var man manifest
@@ -211,90 +420,245 @@ func (d *Mariadb) Start() error {
 		panic(fmt.Errorf("edb was started in debug mode but the manifest does not allow debug mode"))
 	}
 
-	d.setManifestSignature(jsonManifest)
-	d.ca = man.CA
-	d.cert = cert
-	d.key = key
+	d.setManifest(jsonManifest)
+
+	if err := d.createTables(); err != nil {
+		panic(err)
+	}
+	addedCAs, err := d.getAddedCAsFromSQL()
+	if err != nil {
+		panic(err)
+	}
+	d.setCABundle(newCABundle(man).withAdded(addedCAs))
+	if err := d.prepareReplication(); err != nil {
+		panic(err)
+	}
+	if err := d.loadReadOnly(); err != nil {
+		panic(err)
+	}
+	if err := d.startQueryDigests(man); err != nil {
+		panic(err)
+	}
+	if err := d.startBinlogTimeline(); err != nil {
+		panic(err)
+	}
+
+	chain, crossCert, crossCertNotAfter, err := d.getCertificateChainFromSQL()
+	if err != nil {
+		panic(err)
+	}
+	d.setCertificate(cert, key, chain, crossCert, crossCertNotAfter)
 
 	if err := d.writeCertificates(); err != nil {
 		panic(err)
 	}
 
+	crl, err := d.getCRLFromSQL()
+	if err != nil {
+		panic(err)
+	}
+	if crl == nil {
+		crl = []byte(man.CRL)
+	}
+	if err := d.installCRL(crl); err != nil {
+		panic(err)
+	}
+
+	d.completeStartup(internalAddr)
+	if err := d.startReplication(); err != nil {
+		panic(err)
+	}
+	if d.replicaOf != nil {
+		go d.applyRelayLog()
+	}
+	go d.rotateCAs()
+	startLog.Info("DB is running.")
+	return nil
+}
+
+// launch starts mariadbd and establishes the internal connection. It returns the internal address, on which mariadb listens until completeStartup is called.
+func (d *Mariadb) launch() (string, error) {
+	if err := d.configureStart(); err != nil {
+		return "", err
+	}
+
+	// Set internal addr env var so that mariadb will first listen on that addr. SSL and ACL will not be active at this point,
+	// so we can get the cert and key from the db, write it to the memfs, and then let mariadb complete its startup sequence.
+	normalizedInternalAddr := net.JoinHostPort(splitHostPort(d.internalAddress, "3305"))
+	if err := os.Setenv(edbInternalAddr, normalizedInternalAddr); err != nil {
+		return "", err
+	}
+
+	d.mainExited = make(chan int, 1)
+	d.done = make(chan struct{})
+	go func() {
+		ret := d.mariadbd.Main(filepath.Join(d.internalPath, filenameCnf))
+		if atomic.LoadInt32(&d.stopping) != 0 {
+			d.mainExited <- ret
+			return
+		}
+		if d.exitHandler != nil {
+			d.exitHandler(ret)
+			return
+		}
+		panic(fmt.Errorf("mariadbd.Main returned unexpectedly with %v", ret))
+	}()
+	d.mariadbd.WaitUntilListenInternalReady()
+
+	// This connection is established before ACL is active and will thus keep full privileges.
+	// EDB uses it for administrative tasks while the database is running.
+	conn, err := connect(normalizedInternalAddr, "")
+	if err != nil {
+		log.Fatal("connecting to the database failed", "error", err)
+	}
+	d.internalConn = conn
+	if d.binlog {
+		// Administrative changes to $edgeless must not be replayed on other instances.
+		if _, err := conn.ExecContext(context.Background(), "SET SESSION sql_log_bin=0"); err != nil {
+			log.Fatal("disabling the binary log failed", "error", err)
+		}
+	}
+
+	// Dumps use their own connection so that they don't block administrative tasks.
+	if d.dumpConn, err = connect(normalizedInternalAddr, ""); err != nil {
+		log.Fatal("connecting to the database failed", "error", err)
+	}
+	return normalizedInternalAddr, nil
+}
+
+// completeStartup lets mariadb complete its startup sequence and waits until it has started.
+func (d *Mariadb) completeStartup(internalAddr string) {
 	// clear env var and connect once more to signal mariadb that we are ready to start
 	if err := os.Setenv(edbInternalAddr, ""); err != nil {
 		panic(err)
 	}
-	c, err := net.Dial("tcp", normalizedInternalAddr)
+	c, err := net.Dial("tcp", internalAddr)
 	if err != nil {
 		panic(err)
 	}
 	c.Close()
 
 	d.mariadbd.WaitUntilStarted()
-	rt.Log.Println("DB is running.")
-	return nil
 }
 
-// GetManifestSignature returns the signature of the manifest that has been used to initialize the database.
-func (d *Mariadb) GetManifestSignature() []byte {
-	return d.manifestSig
+// SetExitHandler sets a function that is called instead of panicking if MariaDB exits unexpectedly.
+// blockConnections makes MariaDB refuse all clients until unblock is called. It lowers max_connections and occupies
+// the remaining connections, including the one reserved for CONNECTION ADMIN, so that new connections are refused
+// before they are authenticated. It must be called while MariaDB still listens on internalAddr.
+func (d *Mariadb) blockConnections(conn *sql.Conn, internalAddr string) (unblock func() error, err error) {
+	var maxConnections int
+	if err := conn.QueryRowContext(context.Background(), "SELECT @@global.max_connections").Scan(&maxConnections); err != nil {
+		return nil, err
+	}
+	// MariaDB raises the value to its minimum.
+	if _, err := conn.ExecContext(context.Background(), "SET GLOBAL max_connections=1"); err != nil {
+		return nil, err
+	}
+
+	var fillers []*sql.DB
+	closeFillers := func() {
+		for _, db := range fillers {
+			db.Close()
+		}
+	}
+	for len(fillers) <= maxConnections {
+		db, err := sqlOpen(internalAddr, "")
+		if err != nil {
+			closeFillers()
+			return nil, err
+		}
+		err = db.Ping()
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrConCount {
+			db.Close()
+			return func() error {
+				defer closeFillers()
+				_, err := conn.ExecContext(context.Background(), fmt.Sprintf("SET GLOBAL max_connections=%d", maxConnections))
+				return err
+			}, nil
+		}
+		if err != nil {
+			db.Close()
+			closeFillers()
+			return nil, err
+		}
+		fillers = append(fillers, db)
+	}
+	closeFillers()
+	return nil, errors.New("MariaDB didn't refuse connections after max_connections had been lowered")
 }
 
-func (d *Mariadb) setManifestSignature(jsonManifest []byte) {
-	sig := sha256.Sum256(jsonManifest)
-	d.manifestSig = sig[:]
+func (d *Mariadb) SetExitHandler(handler func(status int)) {
+	d.exitHandler = handler
 }
 
-// configure MariaDB for bootstrap
-func (d *Mariadb) configureBootstrap(sql []string, jsonManifest []byte) error {
-	var queries string
-	if len(sql) > 0 {
-		queries = strings.Join(sql, ";\n") + ";"
+// Stop shuts down MariaDB cleanly and waits until it has exited. RocksDB flushes its data on shutdown.
+func (d *Mariadb) Stop(timeout time.Duration) error {
+	if d.mainExited == nil {
+		// not running
+		return nil
 	}
-
-	key, err := x509.MarshalPKCS8PrivateKey(d.key)
-	if err != nil {
-		return err
+	if !atomic.CompareAndSwapInt32(&d.stopping, 0, 1) {
+		return errors.New("database is already stopping")
 	}
+	d.stopBackgroundTasks()
 
-	init := fmt.Sprintf(`
-CREATE DATABASE mysql;
-USE mysql;
-%v
-FLUSH PRIVILEGES;
-%v
-CREATE DATABASE $edgeless;
-CREATE TABLE $edgeless.config (c BLOB, k BLOB, m BLOB);
-INSERT INTO $edgeless.config VALUES (%#x, %#x, %#x);
-`, mariadbBootstrap, queries, d.cert, key, jsonManifest)
-
-	cnf := `
-[mysqld]
-datadir=` + d.externalPath + `
-default-storage-engine=ROCKSDB
-enforce-storage-engine=ROCKSDB
-log-error =` + filepath.Join(d.internalPath, filenameBootstrapLog) + `
-bootstrap
-init-file=` + filepath.Join(d.internalPath, filenameInit) + `
-`
-	if len(d.debugLogDir) > 0 {
-		cnf += fmt.Sprintf("%v=%v\n", "rocksdb_db_log_dir", d.debugLogDir)
-	} else {
-		cnf += fmt.Sprintf("%v=%v\n", "rocksdb_db_log_dir", d.internalPath)
+	exited := d.mariadbd.PrepareShutdown()
+	shutdownLog.Info("shutting down ...")
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(context.Background(), "SHUTDOWN")
+		conn.Close()
+		d.internalConn = nil
+		return err
+	})
+	if err != nil && !errors.Is(err, driver.ErrBadConn) {
+		return fmt.Errorf("shutdown: %w", err)
 	}
 
-	if err := d.writeFile(filenameCnf, []byte(cnf)); err != nil {
-		return err
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var status int
+	select {
+	case status = <-exited:
+	case status = <-d.mainExited:
+	case <-timer.C:
+		return errors.New("timed out waiting for MariaDB to shut down")
 	}
-	if err := d.writeFile(filenameInit, []byte(init)); err != nil {
-		return err
+	if status != 0 {
+		return fmt.Errorf("MariaDB exited with %v", status)
 	}
+	shutdownLog.Info("DB has been shut down.")
 	return nil
 }
 
+// GetManifestSignature returns the signature of the manifest that has been used to initialize the database.
+func (d *Mariadb) GetManifestSignature() []byte {
+	d.manifestMutex.RLock()
+	defer d.manifestMutex.RUnlock()
+	return d.manifestSig
+}
+
+// GetManifest returns the manifest that has been used to initialize the database.
+func (d *Mariadb) GetManifest() []byte {
+	d.manifestMutex.RLock()
+	defer d.manifestMutex.RUnlock()
+	return d.manifest
+}
+
+func (d *Mariadb) setManifest(jsonManifest []byte) {
+	sig := sha256.Sum256(jsonManifest)
+	d.manifestMutex.Lock()
+	defer d.manifestMutex.Unlock()
+	d.manifestSig = sig[:]
+	d.manifest = jsonManifest
+}
+
 // configure MariaDB for regular start
 func (d *Mariadb) configureStart() error {
-	host, port := splitHostPort(d.externalAddress, "3306")
+	host, port, err := bindAddress(d.externalAddresses, "3306")
+	if err != nil {
+		return err
+	}
 
 	cnf := `
 [mysqld]
@@ -305,13 +669,18 @@ user=root
 bind-address=` + host + `
 port=` + port + `
 skip-name-resolve
-thread-handling=pool-of-threads
-thread-pool-max-threads=` + strconv.Itoa(d.maxPoolThreads) + `
 require-secure-transport=1
 ssl-ca = "` + filepath.Join(d.internalPath, filenameCA) + `"
 ssl-cert = "` + filepath.Join(d.internalPath, filenameCert) + `"
 ssl-key = "` + filepath.Join(d.internalPath, filenameKey) + `"
-`
+` + d.threadPool.options()
+	if versions := d.tlsPolicy.MariaDBVersions(); versions != "" {
+		cnf += fmt.Sprintf("%v=%v\n", "tls_version", versions)
+	}
+	if ciphers := d.tlsPolicy.MariaDBCiphers(); ciphers != "" {
+		cnf += fmt.Sprintf("%v=%v\n", "ssl_cipher", ciphers)
+	}
+
 	if d.debug {
 		// If nothing is specified ONLY error-log is printed on stderr
 		// Setting any of the logs without a file logs them to default files
@@ -323,6 +692,10 @@ ssl-key = "` + filepath.Join(d.internalPath, filenameKey) + `"
 		// http://myrocks.io/docs/getting-started/
 		if len(d.debugLogDir) > 0 {
 			logFiles := map[string]string{"log_error": FilenameErrorLog, "general_log_file": filenameGeneralLog, "slow_query_log_file": filenameSlowQueryLog, "log_bin": filenameBinaryLog, "rocksdb_db_log_dir": ""}
+			if d.binlog {
+				// binary logs are shipped encrypted instead
+				delete(logFiles, "log_bin")
+			}
 			for logName, logFile := range logFiles {
 				cnf += fmt.Sprintf("%v=%v\n", logName, filepath.Join(d.debugLogDir, logFile))
 			}
@@ -339,95 +712,365 @@ binlog-format=ROW
 		cnf += fmt.Sprintf("%v=%v\n", "log_error", filepath.Join(d.internalPath, FilenameErrorLog))
 		cnf += fmt.Sprintf("%v=%v\n", "rocksdb_db_log_dir", d.internalPath)
 	}
+
+	if d.queryDigests {
+		cnf += queryDigestOptions()
+	} else {
+		// The performance schema is only compiled in for query digests.
+		cnf += "performance_schema=OFF\n"
+	}
+
+	if d.binlog {
+		// Binary logs are kept in enclave memory until they're shipped. Events without checksums are easier to replay.
+		cnf += fmt.Sprintf("%v=%v\n", "log_bin", filepath.Join(d.internalPath, filenameBinlog))
+		cnf += "binlog_format=ROW\nbinlog_checksum=NONE\n"
+		// A promoted replica must have logged the transactions of its former primary.
+		cnf += "log_slave_updates=ON\n"
+	}
+	serverID, err := randomServerID()
+	if err != nil {
+		return err
+	}
+	cnf += fmt.Sprintf("%v=%v\n", "server_id", serverID)
+	// edb starts the replication threads of a replica itself.
+	cnf += "skip-slave-start\n"
 	return d.writeFile(filenameCnf, []byte(cnf))
 }
 
 func (d *Mariadb) writeCertificates() error {
-	cert, key, err := toPEM(d.cert, d.key)
-	if err != nil {
+	if err := d.writeCA(time.Now()); err != nil {
 		return err
 	}
+	return d.writeServerCertificate()
+}
 
-	var ca []byte
-	if d.ca != "" {
-		ca = []byte(d.ca)
-	} else {
+// writeCA writes the CA certificates that are active at the given time.
+func (d *Mariadb) writeCA(now time.Time) error {
+	ca := []byte(d.getCABundle().active(now))
+	if len(ca) <= 0 {
 		// The manifest didn't contain a CA certificate, but we set ssl-ca in configureStart.
 		// Thus, we must provide one or otherwise mariadb will not accept connections.
-		ca, _, err = createCertificate("dummy")
+		cert, _, err := createCertificate("dummy")
 		if err != nil {
 			return err
 		}
-		ca = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca})
+		ca = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
 	}
+	return d.writeFile(filenameCA, ca)
+}
 
-	if err := d.writeFile(filenameCA, ca); err != nil {
-		return err
+// rotateCAs updates the CA certificates and reloads MariaDB's SSL context whenever the set of active CAs changes.
+func (d *Mariadb) rotateCAs() {
+	for {
+		// If the set of active CAs doesn't change anymore, wait until CAs are added.
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if next, err := d.getCABundle().nextChange(time.Now()); err == nil {
+			timer = time.NewTimer(time.Until(next))
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-d.casChanged:
+			// UpdateCAs has already written the CA certificates.
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		case <-d.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+
+		log.Info("updating CA certificates")
+		if err := d.writeCA(time.Now()); err != nil {
+			log.Error("failed to write CA certificates", "error", err)
+			continue
+		}
+		if err := d.execInternal("FLUSH SSL"); err != nil {
+			log.Error("failed to reload SSL context", "error", err)
+		}
 	}
-	if err := d.writeFile(filenameCert, cert); err != nil {
-		return err
+}
+
+// stopBackgroundTasks ends the rotation of the CAs, the locking of privileged accounts on a replica, and the timer that ends the certificate overlap.
+func (d *Mariadb) stopBackgroundTasks() {
+	if d.done != nil {
+		close(d.done)
 	}
-	if err := d.writeFile(filenameKey, key); err != nil {
-		return err
+	d.certMutex.Lock()
+	defer d.certMutex.Unlock()
+	if d.overlapTimer != nil {
+		d.overlapTimer.Stop()
+		d.overlapTimer = nil
 	}
-	return nil
 }
 
 func (d *Mariadb) writeFile(filename string, data []byte) error {
 	return ioutil.WriteFile(filepath.Join(d.internalPath, filename), data, 0o600)
 }
 
-func getConfigFromSQL(address string) (cert []byte, key crypto.PrivateKey, config []byte, err error) {
-	db, err := sqlOpen(address)
+func (d *Mariadb) getCABundle() caBundle {
+	d.casMutex.Lock()
+	defer d.casMutex.Unlock()
+	return d.cas
+}
+
+func (d *Mariadb) setCABundle(cas caBundle) {
+	d.casMutex.Lock()
+	defer d.casMutex.Unlock()
+	d.cas = cas
+}
+
+func (d *Mariadb) getCRL() []byte {
+	d.casMutex.Lock()
+	defer d.casMutex.Unlock()
+	return d.crl
+}
+
+// UpdateCAs replaces the CA certificates that have been added at runtime by the JSON-encoded list of CAs, which have the format of the manifest's cas.
+// The CAs of the manifest can't be replaced.
+func (d *Mariadb) UpdateCAs(jsonCAs []byte) error {
+	var added []caBundleEntry
+	if err := json.Unmarshal(jsonCAs, &added); err != nil {
+		return err
+	}
+	cas := d.getCABundle().withAdded(added)
+	if err := cas.validate(); err != nil {
+		return err
+	}
+	if crl := d.getCRL(); len(crl) > 0 {
+		if _, err := parseCRLs(crl, cas.all()); err != nil {
+			return fmt.Errorf("the current CRL must be signed by one of the CAs: %w", err)
+		}
+	}
+
+	// Store the CAs in the database first so that they're restored after a restart.
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("DELETE FROM $edgeless.added_cas"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO $edgeless.added_cas VALUES (?)", jsonCAs); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}); err != nil {
+		return err
+	}
+
+	d.setCABundle(cas)
+	if err := d.writeCA(time.Now()); err != nil {
+		return err
+	}
+	select {
+	case d.casChanged <- struct{}{}:
+	default:
+	}
+	return d.execInternal("FLUSH SSL")
+}
+
+func (d *Mariadb) getAddedCAsFromSQL() ([]caBundleEntry, error) {
+	var jsonCAs []byte
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT cas FROM $edgeless.added_cas").Scan(&jsonCAs)
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer db.Close()
+	var added []caBundleEntry
+	if err := json.Unmarshal(jsonCAs, &added); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
 
-	var keyRaw []byte
-	if err := db.QueryRow("SELECT * from $edgeless.config").Scan(&cert, &keyRaw, &config); err != nil {
-		return nil, nil, nil, err
+// UpdateCRL replaces the certificate revocation list used to verify client certificates.
+func (d *Mariadb) UpdateCRL(crl []byte) error {
+	cas := d.getCABundle()
+	newCRLs, err := parseCRLs(crl, cas.all())
+	if err != nil {
+		return err
+	}
+	var oldCRLs []*x509.RevocationList
+	if oldCRL := d.getCRL(); len(oldCRL) > 0 {
+		if oldCRLs, err = parseCRLs(oldCRL, cas.all()); err != nil {
+			return err
+		}
+	}
+	if err := checkCRLUpdate(oldCRLs, newCRLs); err != nil {
+		return err
 	}
 
-	if key, err = x509.ParsePKCS8PrivateKey(keyRaw); err != nil {
-		return nil, nil, nil, err
+	// Store the CRL in the database first so that it can't be rolled back by restarting EDB.
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("DELETE FROM $edgeless.crl"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO $edgeless.crl VALUES (?)", crl); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}); err != nil {
+		return err
 	}
 
-	return
+	if err := d.installCRL(crl); err != nil {
+		return err
+	}
+	return d.execInternal("FLUSH SSL")
 }
 
-func sqlOpen(address string) (*sql.DB, error) {
-	return sql.Open("mysql", "root@tcp("+address+")/")
+// CreateCheckpoint creates a RocksDB checkpoint of the database in the directory path, which must not exist yet.
+// The files of the checkpoint are encrypted with the master key like the database itself.
+// If the binary log is enabled, it returns the position in the binary log right after the checkpoint.
+func (d *Mariadb) CreateCheckpoint(path string) (BinlogPosition, error) {
+	createCheckpoint := "SET GLOBAL rocksdb_create_checkpoint = '" + escapeString([]byte(path)) + "'"
+	if !d.binlog {
+		return BinlogPosition{}, d.execInternal(createCheckpoint)
+	}
+
+	var position BinlogPosition
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		ctx := context.Background()
+		// Block commits so that the checkpoint matches the end of a binary log.
+		if _, err := conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "UNLOCK TABLES")
+		if _, err := conn.ExecContext(ctx, "FLUSH BINARY LOGS"); err != nil {
+			return err
+		}
+		names, err := queryFirstColumn(conn, "SHOW MASTER STATUS")
+		if err != nil {
+			return err
+		}
+		if len(names) != 1 {
+			return errors.New("binary log is disabled")
+		}
+		position.File = names[0]
+		if err := conn.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_binlog_pos").Scan(&position.GTID); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, createCheckpoint)
+		return err
+	})
+	return position, err
 }
 
-func (d *Mariadb) printErrorLog(onlyPrintOnError bool) error {
-	// Restore original stdout & stderr from MariaDB's redirection
-	if err := rt.RestoreStdoutAndStderr(); err != nil {
-		panic(err)
+// installCRL writes the CRL to the memfs and lets MariaDB use it from the next (re)initialization of its SSL context on.
+func (d *Mariadb) installCRL(crl []byte) error {
+	if len(crl) <= 0 {
+		return nil
 	}
+	if err := d.writeFile(filenameCRL, crl); err != nil {
+		return err
+	}
+	d.mariadbd.SetSSLCRL(filepath.Join(d.internalPath, filenameCRL))
+	d.casMutex.Lock()
+	d.crl = crl
+	d.casMutex.Unlock()
+	return nil
+}
 
-	// Read error log from internal memfs
-	// This file should always be created when everything is somewhat running okay
-	// Even when silent startup is set and nothing was printed to the error log
-	errorLogBytes, err := ioutil.ReadFile(filepath.Join(d.internalPath, filenameBootstrapLog))
+// connect establishes a connection that is kept open for the lifetime of mariadbd.
+func connect(address, params string) (*sql.Conn, error) {
+	db, err := sqlOpen(address, params)
 	if err != nil {
-		panic("cannot read MariaDB's error log: " + err.Error())
+		return nil, err
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	// Prevent the server from closing the connection when it has been idle for a long time.
+	if _, err := conn.ExecContext(context.Background(), "SET SESSION wait_timeout=31536000"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *Mariadb) withInternalConn(f func(conn *sql.Conn) error) error {
+	d.internalConnMutex.Lock()
+	defer d.internalConnMutex.Unlock()
+	if d.internalConn == nil {
+		return errors.New("database is not running")
 	}
-	errorLog := string(errorLogBytes)
+	return f(d.internalConn)
+}
 
-	// Check if "ERROR" (case insensitive) occurs in MariaDB's error log
-	pattern := regexp.MustCompile(`(?mi)^ERROR.*$`)
-	foundErrors := pattern.FindAllString(errorLog, -1)
+func (d *Mariadb) execInternal(query string, args ...interface{}) error {
+	return d.withInternalConn(func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(context.Background(), query, args...)
+		return err
+	})
+}
 
-	// Print error log if an error was found or we explicitly asked for the log
-	if foundErrors != nil || !onlyPrintOnError {
-		fmt.Print(errorLog)
+// createTables creates the tables that have been added to $edgeless after the initial release.
+func (d *Mariadb) createTables() error {
+	for _, query := range []string{
+		"CREATE TABLE IF NOT EXISTS $edgeless.crl (crl BLOB)",
+		"CREATE TABLE IF NOT EXISTS $edgeless.added_cas (cas BLOB)",
+		"CREATE TABLE IF NOT EXISTS $edgeless.cert_chain (c BLOB)",
+		"CREATE TABLE IF NOT EXISTS $edgeless.cross_cert (c BLOB, not_after BIGINT)",
+		"CREATE TABLE IF NOT EXISTS $edgeless.csr_key (k BLOB)",
+		"CREATE TABLE IF NOT EXISTS $edgeless.binlog (timeline CHAR(16))",
+		"CREATE TABLE IF NOT EXISTS $edgeless.replication (address VARCHAR(255), ca BLOB, password VARCHAR(64))",
+		"CREATE TABLE IF NOT EXISTS $edgeless.read_only (enabled BOOL)",
+		"CREATE TABLE IF NOT EXISTS $edgeless.locked_users (user VARCHAR(128), host VARCHAR(255))",
+		"CREATE TABLE IF NOT EXISTS $edgeless.audit (seq BIGINT AUTO_INCREMENT PRIMARY KEY, content BLOB, signature BLOB)",
+	} {
+		if err := d.execInternal(query); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// And if we found errors, return them to the caller
-	if foundErrors != nil {
-		return errors.New(strings.Join(foundErrors, ""))
+func (d *Mariadb) getCRLFromSQL() (crl []byte, err error) {
+	err = d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT crl FROM $edgeless.crl").Scan(&crl)
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
+	return crl, err
+}
 
-	return nil
+func (d *Mariadb) getConfigFromSQL() (cert []byte, key crypto.PrivateKey, config []byte, err error) {
+	var keyRaw []byte
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT * from $edgeless.config").Scan(&cert, &keyRaw, &config)
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if key, err = x509.ParsePKCS8PrivateKey(keyRaw); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return
+}
+
+func sqlOpen(address, params string) (*sql.DB, error) {
+	dsn := "root@tcp(" + address + ")/"
+	if params != "" {
+		dsn += "?" + params
+	}
+	return sql.Open("mysql", dsn)
 }
@@ -0,0 +1,158 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStop(t *testing.T) {
+	assert := assert.New(t)
+
+	// not started
+	d := &Mariadb{}
+	assert.NoError(d.Stop(time.Second))
+
+	// started, but the internal connection is gone
+	d = &Mariadb{mariadbd: &mariadbdMock{}, mainExited: make(chan int, 1)}
+	assert.Error(d.Stop(time.Second))
+	assert.Error(d.Stop(time.Second), "already stopping")
+}
+
+func TestStopBackgroundTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &Mariadb{mariadbd: &mariadbdMock{}, mainExited: make(chan int, 1), done: make(chan struct{})}
+	rotated := make(chan struct{})
+	go func() {
+		d.rotateCAs()
+		close(rotated)
+	}()
+	d.setCertificate(nil, nil, nil, []byte{2}, time.Now().Add(time.Hour))
+	overlapTimer := d.overlapTimer
+
+	// renewing again replaces the timer
+	d.setCertificate(nil, nil, nil, []byte{3}, time.Now().Add(time.Hour))
+	assert.False(overlapTimer.Stop())
+	overlapTimer = d.overlapTimer
+
+	assert.Error(d.Stop(time.Second)) // the internal connection is gone
+	assert.False(overlapTimer.Stop())
+	assert.Nil(d.overlapTimer)
+	select {
+	case <-rotated:
+	case <-time.After(time.Second):
+		assert.Fail("rotateCAs hasn't returned")
+	}
+}
+
+func TestManifestIsSetWithSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	d := &Mariadb{}
+	set := make(chan struct{})
+	go func() {
+		d.setManifest([]byte(`{"sql":[]}`))
+		close(set)
+	}()
+
+	// the manifest and its signature are never observed as a torn pair
+	for done := false; !done; {
+		select {
+		case <-set:
+			done = true
+		default:
+		}
+		d.manifestMutex.RLock()
+		manifest, sig := d.manifest, d.manifestSig
+		d.manifestMutex.RUnlock()
+		if manifest == nil {
+			assert.Nil(sig)
+			continue
+		}
+		expected := sha256.Sum256(manifest)
+		assert.Equal(expected[:], sig)
+	}
+	assert.NotNil(d.GetManifest())
+	assert.NotNil(d.GetManifestSignature())
+}
+
+func TestManifestError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	err := manifestError(&mysql.MySQLError{Number: 1064, Message: "syntax error near 'TABL t'"}, 1, "CREATE TABL t")
+	var diagErr *DiagnosisError
+	require.ErrorAs(err, &diagErr)
+	require.Len(diagErr.Events, 1)
+	event := diagErr.Events[0]
+	assert.Equal(EventManifestSQL, event.Kind)
+	assert.Equal(1064, event.Code)
+	assert.Equal("syntax error near 'TABL t'", event.Message)
+	require.NotNil(event.Statement)
+	assert.Equal(1, *event.Statement)
+	assert.Equal("CREATE TABL t", event.StatementText)
+
+	// other errors, e.g., a lost connection
+	err = manifestError(errors.New("invalid connection"), 0, "CREATE DATABASE d")
+	require.ErrorAs(err, &diagErr)
+	assert.Zero(diagErr.Events[0].Code)
+	assert.Equal("invalid connection", diagErr.Events[0].Message)
+}
+
+func TestRemoveNewEntries(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	externalPath := t.TempDir()
+	require.NoError(os.Mkdir(filepath.Join(externalPath, "edb-persistence"), 0o700))
+	existingEntries, err := readDirNames(externalPath)
+	require.NoError(err)
+
+	// simulate a partially initialized database
+	require.NoError(os.Mkdir(filepath.Join(externalPath, "#rocksdb"), 0o700))
+	require.NoError(os.WriteFile(filepath.Join(externalPath, "#rocksdb", "CURRENT"), nil, 0o600))
+	require.NoError(os.WriteFile(filepath.Join(externalPath, "aria_log_control"), nil, 0o600))
+
+	d := &Mariadb{externalPath: externalPath}
+	require.NoError(d.removeNewEntries(existingEntries))
+	entries, err := readDirNames(externalPath)
+	require.NoError(err)
+	assert.Equal([]string{"edb-persistence"}, entries)
+}
+
+type mariadbdMock struct {
+	exited chan int
+}
+
+func (*mariadbdMock) Main(cnfPath string) int       { return 0 }
+func (*mariadbdMock) WaitUntilStarted()             {}
+func (*mariadbdMock) WaitUntilListenInternalReady() {}
+func (*mariadbdMock) SetSSLCRL(path string)         {}
+
+func (m *mariadbdMock) PrepareShutdown() <-chan int {
+	m.exited = make(chan int, 1)
+	return m.exited
+}
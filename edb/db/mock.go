@@ -17,31 +17,121 @@ package db
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 // DatabaseMock is a Database mock.
 type DatabaseMock struct {
 	Man manifest
+	CRL []byte
+	// AddedCAs holds the CAs of the last UpdateCAs call.
+	AddedCAs []byte
+	// RenewOverlap holds the overlap of the last certificate renewal.
+	RenewOverlap time.Duration
+	// Chain holds the last certificate chain that has been set.
+	Chain      [][]byte
+	ThreadPool ThreadPoolStatus
+	Stopped    bool
+	// InitErr is returned by Initialize if set.
+	InitErr error
+	// DumpStatements are written by Dump.
+	DumpStatements []string
+	// Imported holds the statements of the last import.
+	Imported []string
+	// CheckpointFiles are written by CreateCheckpoint.
+	CheckpointFiles map[string][]byte
+	// Binlogs are returned by RotateBinlog and BinlogFiles.
+	Binlogs []string
+	// CurrentBinlog is returned by RotateBinlog and CreateCheckpoint.
+	CurrentBinlog string
+	// PurgedTo holds the argument of the last PurgeBinlogs call.
+	PurgedTo       string
+	BinlogTimeline string
+	BinlogPrevious string
+	// Replayed holds the replayed binary logs.
+	Replayed [][]byte
+	// CheckpointGTID is returned by CreateCheckpoint.
+	CheckpointGTID      string
+	ReplicationPassword string
+	// ReplicationSource makes the mock a replica. Promote resets it.
+	ReplicationSource *ReplicationSource
+	// ReadOnly is set by SetReadOnly.
+	ReadOnly ReadOnlyStatus
+	// AuditEntries holds the audit log.
+	AuditEntries []AuditEntry
+	// QueryDigestsEnabled is set by EnableQueryDigests.
+	QueryDigestsEnabled bool
+	// QueryDigests are returned by GetQueryDigests.
+	QueryDigests []QueryDigest
+	manifest     []byte
+	csrKey       *ecdsa.PrivateKey
+	cert         []byte
+	key          crypto.PrivateKey
 }
 
 // GetCertificate gets the database certificate.
 func (d *DatabaseMock) GetCertificate() ([]byte, crypto.PrivateKey) {
-	cert, priv, err := createCertificate("")
+	if d.cert == nil {
+		var err error
+		d.cert, d.key, err = createCertificate("")
 
-	// The standard interface does not return an error as it just gets the certificate from the core.
-	// Since the mock interface dynamically creates one, we assume this has to succeed otherwise we panic here.
-	if err != nil {
-		panic(err)
+		// The standard interface does not return an error as it just gets the certificate from the core.
+		// Since the mock interface dynamically creates one, we assume this has to succeed otherwise we panic here.
+		if err != nil {
+			panic(err)
+		}
 	}
-	return cert, priv
+	return d.cert, d.key
+}
+
+// GetCertificateChain gets the certificates that must be presented along with a certificate issued by the database certificate.
+func (d *DatabaseMock) GetCertificateChain() [][]byte {
+	cert, _ := d.GetCertificate()
+	return [][]byte{cert}
+}
+
+// RenewCertificate replaces the database certificate and its key by a new self-signed certificate.
+func (d *DatabaseMock) RenewCertificate(overlap time.Duration) error {
+	d.RenewOverlap = overlap
+	return nil
+}
+
+// CreateCertificateRequest creates a CSR for a new database certificate.
+func (d *DatabaseMock) CreateCertificateRequest() ([]byte, error) {
+	if d.csrKey == nil {
+		var err error
+		if d.csrKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+	return x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, d.csrKey)
+}
+
+// SetCertificate replaces the database certificate by chain[0], which must have been issued for the key of the last CSR.
+func (d *DatabaseMock) SetCertificate(chain [][]byte, overlap time.Duration) error {
+	d.Chain = chain
+	d.RenewOverlap = overlap
+	return nil
 }
 
 // Initialize sets up a database according to the jsonManifest.
 func (d *DatabaseMock) Initialize(jsonManifest []byte) error {
+	if d.InitErr != nil {
+		return d.InitErr
+	}
 	if err := json.Unmarshal(jsonManifest, &d.Man); err != nil {
 		return err
 	}
+	d.manifest = jsonManifest
 	return nil
 }
 
@@ -50,7 +140,188 @@ func (d *DatabaseMock) Start() error {
 	return nil
 }
 
+// Stop shuts down the database cleanly.
+func (d *DatabaseMock) Stop(timeout time.Duration) error {
+	d.Stopped = true
+	return nil
+}
+
 // GetManifestSignature returns the signature of the manifest that has been used to initialize the database.
 func (d *DatabaseMock) GetManifestSignature() []byte {
 	return nil
 }
+
+// GetManifest returns the manifest that has been used to initialize the database.
+func (d *DatabaseMock) GetManifest() []byte {
+	return d.manifest
+}
+
+// UpdateCAs replaces the CA certificates that have been added at runtime.
+func (d *DatabaseMock) UpdateCAs(jsonCAs []byte) error {
+	d.AddedCAs = jsonCAs
+	return nil
+}
+
+// UpdateCRL replaces the certificate revocation list used to verify client certificates.
+func (d *DatabaseMock) UpdateCRL(crl []byte) error {
+	d.CRL = crl
+	return nil
+}
+
+// GetThreadPoolStatus returns the configuration and current usage of the thread pool.
+func (d *DatabaseMock) GetThreadPoolStatus() (ThreadPoolStatus, error) {
+	return d.ThreadPool, nil
+}
+
+// Dump writes DumpStatements to w.
+func (d *DatabaseMock) Dump(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, statement := range d.DumpStatements {
+		if err := enc.Encode(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore reads the statements of a dump into Imported and sets up a database according to the jsonManifest.
+// Like Mariadb, it leaves the database uninitialized if the import fails.
+func (d *DatabaseMock) Restore(jsonManifest []byte, dump io.Reader) error {
+	d.Imported = nil
+	var imported []string
+	dec := json.NewDecoder(dump)
+	for {
+		var statement string
+		if err := dec.Decode(&statement); err == io.EOF {
+			break
+		} else if err != nil {
+			return &DiagnosisError{Events: []Event{{Kind: EventError, Message: err.Error()}}}
+		}
+		imported = append(imported, statement)
+	}
+	if err := d.Initialize(jsonManifest); err != nil {
+		return err
+	}
+	d.Imported = imported
+	return nil
+}
+
+// CreateCheckpoint writes CheckpointFiles to the directory path.
+func (d *DatabaseMock) CreateCheckpoint(path string) (BinlogPosition, error) {
+	if err := os.Mkdir(path, 0o700); err != nil {
+		return BinlogPosition{}, err
+	}
+	for name, data := range d.CheckpointFiles {
+		if err := os.WriteFile(filepath.Join(path, name), data, 0o600); err != nil {
+			return BinlogPosition{}, err
+		}
+	}
+	return BinlogPosition{File: d.CurrentBinlog, GTID: d.CheckpointGTID}, nil
+}
+
+// GetBinlogTimeline returns BinlogTimeline and BinlogPrevious.
+func (d *DatabaseMock) GetBinlogTimeline() (timeline, previous string) {
+	return d.BinlogTimeline, d.BinlogPrevious
+}
+
+// RotateBinlog returns Binlogs and CurrentBinlog.
+func (d *DatabaseMock) RotateBinlog() (closed []string, current string, err error) {
+	return d.Binlogs, d.CurrentBinlog, nil
+}
+
+// PurgeBinlogs sets PurgedTo.
+func (d *DatabaseMock) PurgeBinlogs(to string) error {
+	d.PurgedTo = to
+	return nil
+}
+
+// BinlogFiles returns Binlogs.
+func (d *DatabaseMock) BinlogFiles() ([]string, error) {
+	return d.Binlogs, nil
+}
+
+// ReplayBinlog appends the binary log to Replayed.
+func (d *DatabaseMock) ReplayBinlog(r io.Reader, until time.Time) (last time.Time, complete bool, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	d.Replayed = append(d.Replayed, data)
+	return until, true, nil
+}
+
+// EnableReplication sets ReplicationPassword.
+func (d *DatabaseMock) EnableReplication(password string) {
+	d.ReplicationPassword = password
+}
+
+// SetReplicationSource sets ReplicationSource.
+func (d *DatabaseMock) SetReplicationSource(source ReplicationSource) {
+	d.ReplicationSource = &source
+}
+
+// GetReplicationStatus returns the role according to ReplicationSource.
+func (d *DatabaseMock) GetReplicationStatus() (ReplicationStatus, error) {
+	if d.ReplicationSource == nil {
+		return ReplicationStatus{Role: RolePrimary}, nil
+	}
+	return ReplicationStatus{Role: RoleReplica, Primary: d.ReplicationSource.Address}, nil
+}
+
+// Promote resets ReplicationSource.
+func (d *DatabaseMock) Promote() error {
+	if d.ReplicationSource == nil {
+		return errors.New("database is not a replica")
+	}
+	d.ReplicationSource = nil
+	return nil
+}
+
+// SetReadOnly sets ReadOnly.
+func (d *DatabaseMock) SetReadOnly(readOnly, persist bool) error {
+	d.ReadOnly = ReadOnlyStatus{Enabled: readOnly, Persistent: readOnly && persist}
+	return nil
+}
+
+// GetReadOnlyStatus returns ReadOnly.
+func (d *DatabaseMock) GetReadOnlyStatus() ReadOnlyStatus {
+	return d.ReadOnly
+}
+
+// AppendAuditEntry appends to AuditEntries.
+func (d *DatabaseMock) AppendAuditEntry(entry AuditEntry) error {
+	if d.manifest == nil {
+		return errors.New("database has not been initialized")
+	}
+	d.AuditEntries = append(d.AuditEntries, entry)
+	return nil
+}
+
+// GetAuditEntries returns AuditEntries.
+func (d *DatabaseMock) GetAuditEntries() ([]AuditEntry, error) {
+	return d.AuditEntries, nil
+}
+
+// GetLastAuditEntry returns the last element of AuditEntries.
+func (d *DatabaseMock) GetLastAuditEntry() (AuditEntry, error) {
+	if len(d.AuditEntries) == 0 {
+		return AuditEntry{}, nil
+	}
+	return d.AuditEntries[len(d.AuditEntries)-1], nil
+}
+
+// EnableQueryDigests sets QueryDigestsEnabled.
+func (d *DatabaseMock) EnableQueryDigests() {
+	d.QueryDigestsEnabled = true
+}
+
+// GetQueryDigests returns up to limit QueryDigests.
+func (d *DatabaseMock) GetQueryDigests(limit int) ([]QueryDigest, error) {
+	if !d.QueryDigestsEnabled {
+		return nil, ErrQueryDigestsNotConfigured
+	}
+	if len(d.QueryDigests) > limit {
+		return d.QueryDigests[:limit], nil
+	}
+	return d.QueryDigests, nil
+}
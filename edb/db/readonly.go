@@ -0,0 +1,90 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ReadOnlyStatus describes the read-only mode that admins can set for maintenance.
+type ReadOnlyStatus struct {
+	Enabled bool
+	// Persistent tells if the mode is restored when the database is started again.
+	Persistent bool `json:",omitempty"`
+}
+
+// SetReadOnly enables or disables the read-only mode. Open connections aren't closed, but clients can't write anymore.
+// If persist is true, the mode is restored when the database is started again.
+func (d *Mariadb) SetReadOnly(readOnly, persist bool) error {
+	d.readOnlyMutex.Lock()
+	defer d.readOnlyMutex.Unlock()
+
+	// Persist first so that a persistent mode can't get lost and a disabled mode can't reappear after a restart.
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("DELETE FROM $edgeless.read_only"); err != nil {
+			return err
+		}
+		if readOnly && persist {
+			if _, err := tx.Exec("INSERT INTO $edgeless.read_only VALUES (TRUE)"); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}); err != nil {
+		return err
+	}
+
+	d.readOnly = ReadOnlyStatus{Enabled: readOnly, Persistent: readOnly && persist}
+	return d.applyReadOnly()
+}
+
+// GetReadOnlyStatus returns the read-only mode that has been set by SetReadOnly.
+func (d *Mariadb) GetReadOnlyStatus() ReadOnlyStatus {
+	d.readOnlyMutex.Lock()
+	defer d.readOnlyMutex.Unlock()
+	return d.readOnly
+}
+
+// loadReadOnly restores the persisted read-only mode. It's called before clients can connect.
+func (d *Mariadb) loadReadOnly() error {
+	var count int
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM $edgeless.read_only").Scan(&count)
+	}); err != nil {
+		return err
+	}
+	d.readOnlyMutex.Lock()
+	defer d.readOnlyMutex.Unlock()
+	d.readOnly = ReadOnlyStatus{Enabled: count > 0, Persistent: count > 0}
+	return d.applyReadOnly()
+}
+
+// applyReadOnly sets read_only for a replica or if the read-only mode is enabled.
+// read_only doesn't restrict the internal connection and replication. On a replica, lockPrivilegedUsers keeps
+// accounts with SUPER or READ_ONLY ADMIN from writing because MariaDB has no super_read_only.
+// The caller must hold readOnlyMutex.
+func (d *Mariadb) applyReadOnly() error {
+	if d.readOnly.Enabled || d.replicaOf != nil {
+		return d.execInternal("SET GLOBAL read_only=1")
+	}
+	return d.execInternal("SET GLOBAL read_only=0")
+}
@@ -0,0 +1,583 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replicationUser is the MariaDB user that replicas authenticate as.
+const replicationUser = "'edgeless_replication'@'%'"
+
+const filenameReplicationCA = "replication-ca.pem"
+
+// relayApplyInterval is how often a replica looks for new transactions in its relay log while the SQL thread is stopped.
+const relayApplyInterval = 200 * time.Millisecond
+
+// relayEventsPageSize is the number of relay log events that are read at once.
+const relayEventsPageSize = 1000
+
+// relayApplyBatch is the maximum number of transactions that the SQL thread applies at once.
+const relayApplyBatch = 1000
+
+// privilegesPattern matches the statements and the tables of row events that may change accounts or privileges.
+// It's deliberately broad because a false positive only costs a check for privileged accounts.
+var privilegesPattern = regexp.MustCompile(`(?i)\b(GRANT|REVOKE|USER|ROLE|PRIVILEGES|mysql)\b`)
+
+// privilegedUsersQuery returns the accounts that can write to a replica despite read_only because they have SUPER or
+// READ_ONLY ADMIN, directly or via a role. Roles have an empty host and can't log in. Locked accounts are skipped.
+const privilegedUsersQuery = `WITH RECURSIVE privileged (user, host) AS (
+	SELECT g.User, g.Host FROM mysql.global_priv g JOIN information_schema.USER_PRIVILEGES p ON p.GRANTEE = CONCAT('''', g.User, '''@''', g.Host, '''')
+		WHERE p.PRIVILEGE_TYPE IN ('SUPER', 'READ_ONLY ADMIN')
+	UNION
+	SELECT m.User, m.Host FROM mysql.roles_mapping m JOIN privileged r ON m.Role = r.user AND r.host = ''
+)
+SELECT p.user, p.host FROM privileged p JOIN mysql.global_priv g ON g.User = p.user AND g.Host = p.host
+	WHERE p.host != '' AND IFNULL(JSON_VALUE(g.Priv, '$.account_locked'), 'false') != 'true'`
+
+// Roles of a database in replication.
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
+// ReplicationSource describes the primary that a replica follows.
+type ReplicationSource struct {
+	// Address is the SQL address of the primary.
+	Address string
+	// CA is the PEM-encoded certificate that the certificate chain of the primary's SQL interface ends with.
+	CA []byte
+	// Password authenticates the replica to the primary.
+	Password string
+	// GTID is the position in the primary's binary log at which the replica starts. It's only used when the replica joins.
+	GTID string
+}
+
+// ReplicationStatus describes the role of the database and the state of the replication.
+type ReplicationStatus struct {
+	Role string
+	// Replicas is the number of replicas that are currently following the binary log of a primary.
+	Replicas int `json:",omitempty"`
+	// Primary is the SQL address of the primary that a replica follows.
+	Primary string `json:",omitempty"`
+	// IORunning and SQLRunning tell if a replica receives and applies the binary log of the primary.
+	IORunning  bool `json:",omitempty"`
+	SQLRunning bool `json:",omitempty"`
+	// SecondsBehindPrimary is nil while the SQL thread is stopped, e.g., between the steps in which the replica applies the binary log.
+	SecondsBehindPrimary *int64 `json:",omitempty"`
+	LastError            string `json:",omitempty"`
+}
+
+// EnableReplication lets replicas that authenticate with the password follow the binary log.
+// It must be called before the database is started. It requires the binary log to be enabled.
+func (d *Mariadb) EnableReplication(password string) {
+	d.replicationPassword = password
+}
+
+// SetReplicationSource makes the database a read-only replica of the source.
+// It must be called before the database is started. Start persists the source and starts replicating.
+func (d *Mariadb) SetReplicationSource(source ReplicationSource) {
+	d.joinSource = &source
+}
+
+// prepareReplication persists the source of a joining replica and loads the source of a replica. It's called before clients can connect.
+func (d *Mariadb) prepareReplication() error {
+	if source := d.joinSource; source != nil {
+		if err := d.withInternalConn(func(conn *sql.Conn) error {
+			tx, err := conn.BeginTx(context.Background(), nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			// The checkpoint contains the primary's state of these tables.
+			for _, query := range []string{"DELETE FROM $edgeless.replication", "DELETE FROM $edgeless.binlog", "DELETE FROM $edgeless.read_only", "DELETE FROM $edgeless.locked_users"} {
+				if _, err := tx.Exec(query); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec("INSERT INTO $edgeless.replication VALUES (?, ?, ?)", source.Address, source.CA, source.Password); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}); err != nil {
+			return err
+		}
+		if err := d.execInternal("SET GLOBAL gtid_slave_pos = ?", source.GTID); err != nil {
+			return err
+		}
+		d.joinSource = nil
+	}
+
+	var source ReplicationSource
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		return conn.QueryRowContext(context.Background(), "SELECT address, ca, password FROM $edgeless.replication").Scan(&source.Address, &source.CA, &source.Password)
+	})
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := d.writeFile(filenameReplicationCA, source.CA); err != nil {
+		return err
+	}
+	d.readOnlyMutex.Lock()
+	defer d.readOnlyMutex.Unlock()
+	d.replicaOf = &source
+	return d.lockPrivilegedUsers()
+}
+
+// startReplication creates the user that replicas authenticate as and lets a replica follow its primary.
+// A replica only starts the IO thread, which receives the binary log. applyRelayLog runs the SQL thread.
+func (d *Mariadb) startReplication() error {
+	if d.replicationPassword != "" {
+		for _, query := range []string{
+			"CREATE OR REPLACE USER " + replicationUser + " IDENTIFIED BY '" + escapeString([]byte(d.replicationPassword)) + "' REQUIRE SSL",
+			"GRANT REPLICATION SLAVE ON *.* TO " + replicationUser,
+		} {
+			if err := d.execInternal(query); err != nil {
+				return err
+			}
+		}
+	}
+	source := d.replicaOf
+	if source == nil {
+		return nil
+	}
+
+	host, portString, err := net.SplitHostPort(source.Address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return err
+	}
+	// The primary's certificate is verified against the CA, which has been obtained from the attested primary.
+	// The password is derived from the master key, so only attested instances of this database know it.
+	changeMaster := fmt.Sprintf("CHANGE MASTER TO MASTER_HOST='%v', MASTER_PORT=%v, MASTER_USER='edgeless_replication', MASTER_PASSWORD='%v', MASTER_SSL=1, MASTER_SSL_CA='%v', MASTER_USE_GTID=slave_pos",
+		escapeString([]byte(host)), port, escapeString([]byte(source.Password)), escapeString([]byte(filepath.Join(d.internalPath, filenameReplicationCA))))
+	if err := d.execInternal(changeMaster); err != nil {
+		return err
+	}
+	if err := d.execInternal("START SLAVE IO_THREAD"); err != nil {
+		return err
+	}
+	log.Info("replicating", "source", source.Address)
+	return nil
+}
+
+// GetReplicationStatus returns the role of the database and the state of the replication.
+func (d *Mariadb) GetReplicationStatus() (ReplicationStatus, error) {
+	source := d.replicaOf
+	if source == nil {
+		status := ReplicationStatus{Role: RolePrimary}
+		err := d.withInternalConn(func(conn *sql.Conn) error {
+			return conn.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM information_schema.PROCESSLIST WHERE COMMAND LIKE 'Binlog Dump%'").Scan(&status.Replicas)
+		})
+		return status, err
+	}
+
+	status := ReplicationStatus{Role: RoleReplica, Primary: source.Address}
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		values, err := queryRowMap(conn, "SHOW SLAVE STATUS")
+		if err != nil {
+			return err
+		}
+		status.IORunning = values["Slave_IO_Running"] == "Yes"
+		// applyRelayLog stops and starts the SQL thread, so it's only not running if it has failed.
+		status.SQLRunning = values["Slave_SQL_Running"] == "Yes" || values["Last_SQL_Errno"] == "0"
+		if behind, err := strconv.ParseInt(values["Seconds_Behind_Master"], 10, 64); err == nil {
+			status.SecondsBehindPrimary = &behind
+		}
+		if status.LastError = values["Last_IO_Error"]; status.LastError == "" {
+			status.LastError = values["Last_SQL_Error"]
+		}
+		return nil
+	})
+	return status, err
+}
+
+// Promote stops the replication and makes the replica a writable primary.
+func (d *Mariadb) Promote() error {
+	// keeps applyRelayLog from starting the SQL thread again
+	d.readOnlyMutex.Lock()
+	defer d.readOnlyMutex.Unlock()
+	source := d.replicaOf
+	if source == nil {
+		return errors.New("database is not a replica")
+	}
+	for _, query := range []string{
+		"STOP SLAVE",
+		"RESET SLAVE ALL",
+		"DELETE FROM $edgeless.replication",
+	} {
+		if err := d.execInternal(query); err != nil {
+			return err
+		}
+	}
+	d.replicaOf = nil
+	// stays read-only if the read-only mode is enabled
+	if err := d.applyReadOnly(); err != nil {
+		return err
+	}
+	if err := d.unlockPrivilegedUsers(); err != nil {
+		return err
+	}
+	log.Info("promoted to primary, stopped replicating", "source", source.Address)
+	return nil
+}
+
+// lockPrivilegedUsers locks the accounts that could write to the replica despite read_only. MariaDB has no super_read_only.
+// The locked accounts are recorded, so that Promote unlocks them again. The internal connection isn't affected
+// because it has been established before ACL is active. The caller must hold readOnlyMutex.
+func (d *Mariadb) lockPrivilegedUsers() error {
+	if d.replicaOf == nil {
+		return nil
+	}
+	return d.withInternalConn(func(conn *sql.Conn) error {
+		accounts, err := queryAccounts(conn, privilegedUsersQuery)
+		if err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			if _, err := conn.ExecContext(context.Background(), "INSERT INTO $edgeless.locked_users VALUES (?, ?)", account.user, account.host); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(context.Background(), "ALTER USER "+account.String()+" ACCOUNT LOCK"); err != nil {
+				return err
+			}
+			log.Info("locked account on replica because it has SUPER or READ_ONLY ADMIN", "account", account.String())
+		}
+		return nil
+	})
+}
+
+// unlockPrivilegedUsers unlocks the accounts that have been locked by lockPrivilegedUsers. The caller must hold readOnlyMutex.
+func (d *Mariadb) unlockPrivilegedUsers() error {
+	return d.withInternalConn(func(conn *sql.Conn) error {
+		accounts, err := queryAccounts(conn, "SELECT user, host FROM $edgeless.locked_users")
+		if err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			// The primary may have dropped the account in the meantime.
+			if _, err := conn.ExecContext(context.Background(), "ALTER USER IF EXISTS "+account.String()+" ACCOUNT UNLOCK"); err != nil {
+				return err
+			}
+		}
+		_, err = conn.ExecContext(context.Background(), "DELETE FROM $edgeless.locked_users")
+		return err
+	})
+}
+
+// applyRelayLog runs the SQL thread of a replica in steps, so that accounts that replication grants SUPER or
+// READ_ONLY ADMIN to are locked before the SQL thread applies anything else. A transaction that may change
+// privileges is applied on its own, and the accounts are locked right after it has been applied.
+func (d *Mariadb) applyRelayLog() {
+	ticker := time.NewTicker(relayApplyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+		if err := d.applyRelayLogStep(); err != nil {
+			log.Error("failed to apply the relay log on replica", "error", err)
+		}
+	}
+}
+
+// applyRelayLogStep starts the SQL thread for the complete transactions in the relay log that follow the last applied
+// one. It does nothing while the SQL thread is running or has failed.
+func (d *Mariadb) applyRelayLogStep() error {
+	d.readOnlyMutex.Lock()
+	defer d.readOnlyMutex.Unlock()
+	if d.replicaOf == nil {
+		return nil
+	}
+
+	var gtid string
+	var privileges bool
+	if err := d.withInternalConn(func(conn *sql.Conn) error {
+		status, err := queryRowMap(conn, "SHOW SLAVE STATUS")
+		if err != nil {
+			return err
+		}
+		if status["Slave_SQL_Running"] != "No" || status["Last_SQL_Errno"] != "0" {
+			return nil
+		}
+		pos, err := strconv.ParseUint(status["Relay_Log_Pos"], 10, 64)
+		if err != nil {
+			return err
+		}
+		transactions, err := readRelayTransactions(conn, status["Relay_Log_File"], pos)
+		if err != nil {
+			return err
+		}
+		if gtid, privileges = untilGTID(transactions); gtid == "" {
+			return nil
+		}
+		_, err = conn.ExecContext(context.Background(), "START SLAVE SQL_THREAD UNTIL master_gtid_pos = '"+escapeString([]byte(gtid))+"'")
+		return err
+	}); err != nil || !privileges {
+		return err
+	}
+
+	// Wait until the transaction has been applied. The SQL thread stops after it, so nothing else is applied until the accounts are locked.
+	for {
+		var reached int
+		if err := d.withInternalConn(func(conn *sql.Conn) error {
+			return conn.QueryRowContext(context.Background(), "SELECT MASTER_GTID_WAIT(?, 1)", gtid).Scan(&reached)
+		}); err != nil {
+			return err
+		}
+		// The SQL thread may also have failed to apply the transaction.
+		var running string
+		if err := d.withInternalConn(func(conn *sql.Conn) error {
+			status, err := queryRowMap(conn, "SHOW SLAVE STATUS")
+			running = status["Slave_SQL_Running"]
+			return err
+		}); err != nil {
+			return err
+		}
+		if reached == 0 || running == "No" {
+			return d.lockPrivilegedUsers()
+		}
+	}
+}
+
+// relayEvent is a row of SHOW RELAYLOG EVENTS.
+type relayEvent struct {
+	eventType string
+	info      string
+}
+
+// relayTransaction is a transaction in the relay log.
+type relayTransaction struct {
+	gtid string
+	// privileges is set if the transaction may change accounts or privileges.
+	privileges bool
+	complete   bool
+	// standalone transactions like DDL consist of a single statement without BEGIN and COMMIT.
+	standalone bool
+}
+
+// readRelayTransactions reads the transactions that start at pos of the relay log file. It stops when reading more
+// events can't change the result of untilGTID or at the end of the relay log.
+func readRelayTransactions(conn *sql.Conn, file string, pos uint64) ([]relayTransaction, error) {
+	var transactions []relayTransaction
+	offset := 0
+	for {
+		events, err := queryRelayEvents(conn, fmt.Sprintf("SHOW RELAYLOG EVENTS IN '%v' FROM %v LIMIT %v, %v", escapeString([]byte(file)), pos, offset, relayEventsPageSize))
+		if err != nil {
+			return nil, err
+		}
+		var next string
+		transactions, next = addRelayEvents(transactions, events, file)
+		if enoughRelayTransactions(transactions) {
+			return transactions, nil
+		}
+		switch {
+		case len(events) == relayEventsPageSize:
+			offset += relayEventsPageSize
+		case next != "":
+			// The relay log continues in the next file.
+			file, pos, offset = next, 4, 0
+		default:
+			return transactions, nil
+		}
+	}
+}
+
+// enoughRelayTransactions tells if reading more events can't change the result of untilGTID.
+func enoughRelayTransactions(transactions []relayTransaction) bool {
+	if len(transactions) > relayApplyBatch {
+		return true
+	}
+	for i, transaction := range transactions {
+		if transaction.privileges && (i > 0 || transaction.complete) {
+			return true
+		}
+	}
+	return false
+}
+
+// addRelayEvents groups the events into transactions and appends them. A transaction that isn't complete yet is
+// continued. next is the relay log file that follows if the events of file end with a rotation to it.
+func addRelayEvents(transactions []relayTransaction, events []relayEvent, file string) (result []relayTransaction, next string) {
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, event := range events {
+		var current *relayTransaction
+		if len(transactions) > 0 && !transactions[len(transactions)-1].complete {
+			current = &transactions[len(transactions)-1]
+		}
+		switch event.eventType {
+		case "Gtid":
+			// "BEGIN GTID 0-1-5" or "GTID 0-1-5", optionally followed by a commit ID
+			fields := strings.Fields(event.info)
+			standalone := len(fields) > 0 && fields[0] != "BEGIN"
+			if !standalone {
+				fields = fields[1:]
+			}
+			if len(fields) < 2 {
+				continue
+			}
+			transactions = append(transactions, relayTransaction{gtid: fields[1], standalone: standalone})
+		case "Query":
+			if current == nil {
+				continue
+			}
+			statement := strings.TrimSpace(event.info)
+			if privilegesPattern.MatchString(statement) {
+				current.privileges = true
+			}
+			if current.standalone || strings.EqualFold(statement, "COMMIT") || strings.EqualFold(statement, "ROLLBACK") {
+				current.complete = true
+			}
+		case "Table_map":
+			// "table_id: 33 (mysql.global_priv)"
+			if current != nil && privilegesPattern.MatchString(event.info) {
+				current.privileges = true
+			}
+		case "Xid":
+			if current != nil {
+				current.complete = true
+			}
+		case "Rotate":
+			// "mariadb-relay-bin.000002;pos=4". Rotations of the primary's binary log name other files.
+			if name, _, _ := strings.Cut(event.info, ";"); strings.TrimSuffix(name, filepath.Ext(name)) == base {
+				next = name
+			}
+		}
+	}
+	return transactions, next
+}
+
+// untilGTID returns the GTID of the last complete transaction that the SQL thread may apply in one step. privileges is
+// set if that transaction may change privileges, which is only applied on its own. gtid is empty if no complete
+// transaction is pending.
+func untilGTID(transactions []relayTransaction) (gtid string, privileges bool) {
+	for i, transaction := range transactions {
+		if !transaction.complete || i == relayApplyBatch {
+			break
+		}
+		if transaction.privileges {
+			if i == 0 {
+				return transaction.gtid, true
+			}
+			break
+		}
+		gtid = transaction.gtid
+	}
+	return gtid, false
+}
+
+// queryRelayEvents returns the events that a SHOW RELAYLOG EVENTS query returns.
+func queryRelayEvents(conn *sql.Conn, query string) ([]relayEvent, error) {
+	rows, err := conn.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []relayEvent
+	for rows.Next() {
+		var logName, pos, eventType, serverID, endLogPos, info sql.NullString
+		if err := rows.Scan(&logName, &pos, &eventType, &serverID, &endLogPos, &info); err != nil {
+			return nil, err
+		}
+		events = append(events, relayEvent{eventType: eventType.String, info: info.String})
+	}
+	return events, rows.Err()
+}
+
+// account is a MariaDB user account.
+type account struct {
+	user, host string
+}
+
+// String returns the quoted account name that statements like ALTER USER expect.
+func (a account) String() string {
+	return "'" + escapeString([]byte(a.user)) + "'@'" + escapeString([]byte(a.host)) + "'"
+}
+
+// queryAccounts returns the accounts that the query returns as user and host columns.
+func queryAccounts(conn *sql.Conn, query string) ([]account, error) {
+	rows, err := conn.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.user, &a.host); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// queryRowMap returns the first row of the query result by column name. NULL values are empty.
+func queryRowMap(conn *sql.Conn, query string) (map[string]string, error) {
+	rows, err := conn.QueryContext(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	if !rows.Next() {
+		return result, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	for i, column := range columns {
+		result[column] = values[i].String
+	}
+	return result, nil
+}
+
+// randomServerID returns a server_id that distinguishes the instances of a replication topology.
+func randomServerID() (uint32, error) {
+	var raw [4]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return 0, err
+	}
+	// 0 isn't a valid ID for replication
+	return binary.LittleEndian.Uint32(raw[:])%(1<<32-1) + 1, nil
+}
@@ -0,0 +1,124 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountString(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("'admin'@'%'", account{user: "admin", host: "%"}.String())
+	assert.Equal(`'a\'b'@'local\\host'`, account{user: "a'b", host: `local\host`}.String())
+}
+
+func TestAddRelayEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	events := []relayEvent{
+		{"Format_desc", "Server ver: 10.5.11-MariaDB-log, Binlog ver: 4"},
+		{"Gtid_list", "[0-1-4]"},
+		{"Gtid", "BEGIN GTID 0-1-5"},
+		{"Annotate_rows", "INSERT INTO t VALUES (1)"},
+		{"Table_map", "table_id: 32 (test.t)"},
+		{"Write_rows_v1", "table_id: 32 flags: STMT_END_F"},
+		{"Xid", "COMMIT /* xid=10 */"},
+		{"Gtid", "GTID 0-1-6"},
+		{"Query", "use `test`; CREATE TABLE u (i INT)"},
+		{"Gtid", "GTID 0-1-7 cid=12"},
+		{"Query", "GRANT SUPER ON *.* TO 'usr'@'%'"},
+		{"Gtid", "BEGIN GTID 0-1-8"},
+		{"Table_map", "table_id: 33 (mysql.global_priv)"},
+		{"Rotate", "mariadb-bin.000002;pos=4"},
+		{"Rotate", "edb-relay-bin.000003;pos=4"},
+	}
+	transactions, next := addRelayEvents(nil, events, "edb-relay-bin.000002")
+	assert.Equal([]relayTransaction{
+		{gtid: "0-1-5", complete: true},
+		{gtid: "0-1-6", complete: true, standalone: true},
+		{gtid: "0-1-7", privileges: true, complete: true, standalone: true},
+		{gtid: "0-1-8", privileges: true},
+	}, transactions)
+	assert.Equal("edb-relay-bin.000003", next)
+
+	// the incomplete transaction is continued
+	transactions, next = addRelayEvents(transactions, []relayEvent{{"Write_rows_v1", "table_id: 33 flags: STMT_END_F"}, {"Xid", "COMMIT /* xid=11 */"}}, "edb-relay-bin.000003")
+	assert.Equal(relayTransaction{gtid: "0-1-8", privileges: true, complete: true}, transactions[3])
+	assert.Empty(next)
+}
+
+func TestUntilGTID(t *testing.T) {
+	testCases := map[string]struct {
+		transactions []relayTransaction
+		gtid         string
+		privileges   bool
+	}{
+		"empty": {},
+		"incomplete": {
+			transactions: []relayTransaction{{gtid: "0-1-5"}},
+		},
+		"complete transactions": {
+			transactions: []relayTransaction{{gtid: "0-1-5", complete: true}, {gtid: "0-1-6", complete: true}, {gtid: "0-1-7"}},
+			gtid:         "0-1-6",
+		},
+		"stops before privileges": {
+			transactions: []relayTransaction{{gtid: "0-1-5", complete: true}, {gtid: "0-1-6", privileges: true, complete: true}, {gtid: "0-1-7", complete: true}},
+			gtid:         "0-1-5",
+		},
+		"privileges on their own": {
+			transactions: []relayTransaction{{gtid: "0-1-6", privileges: true, complete: true}, {gtid: "0-1-7", complete: true}},
+			gtid:         "0-1-6",
+			privileges:   true,
+		},
+		"incomplete privileges": {
+			transactions: []relayTransaction{{gtid: "0-1-6", privileges: true}},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			gtid, privileges := untilGTID(tc.transactions)
+			assert.Equal(tc.gtid, gtid)
+			assert.Equal(tc.privileges, privileges)
+		})
+	}
+}
+
+func TestPrivilegesPattern(t *testing.T) {
+	assert := assert.New(t)
+	for _, statement := range []string{
+		"GRANT SUPER ON *.* TO 'usr'@'%'",
+		"revoke all privileges on *.* from usr",
+		"CREATE USER usr",
+		"use `test`; ALTER USER usr ACCOUNT UNLOCK",
+		"SET DEFAULT ROLE admin FOR usr",
+		"FLUSH PRIVILEGES",
+		"table_id: 33 (mysql.global_priv)",
+		"use `mysql`; TRUNCATE roles_mapping",
+	} {
+		assert.True(privilegesPattern.MatchString(statement), statement)
+	}
+	for _, statement := range []string{
+		"use `test`; CREATE TABLE t (i INT)",
+		"table_id: 32 (test.t)",
+		"COMMIT",
+	} {
+		assert.False(privilegesPattern.MatchString(statement), statement)
+	}
+}
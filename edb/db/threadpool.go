@@ -0,0 +1,85 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// ThreadPoolConfig configures MariaDB's thread pool.
+type ThreadPoolConfig struct {
+	Size       int // thread_pool_size, 0 for MariaDB's default
+	MaxThreads int // thread_pool_max_threads
+	StallLimit int // thread_pool_stall_limit in milliseconds, 0 for MariaDB's default
+}
+
+// ThreadPoolStatus is the configuration and current usage of MariaDB's thread pool.
+type ThreadPoolStatus struct {
+	ThreadPoolConfig
+	Threads          int // threads in the pool
+	IdleThreads      int // idle threads in the pool
+	ConnectedThreads int // open client connections
+}
+
+// options returns the my.cnf options for the thread pool.
+func (c ThreadPoolConfig) options() string {
+	cnf := "thread-handling=pool-of-threads\n"
+	cnf += fmt.Sprintf("thread-pool-max-threads=%v\n", c.MaxThreads)
+	if c.Size > 0 {
+		cnf += fmt.Sprintf("thread-pool-size=%v\n", c.Size)
+	}
+	if c.StallLimit > 0 {
+		cnf += fmt.Sprintf("thread-pool-stall-limit=%v\n", c.StallLimit)
+	}
+	return cnf
+}
+
+// GetThreadPoolStatus returns the configuration and current usage of the thread pool.
+func (d *Mariadb) GetThreadPoolStatus() (ThreadPoolStatus, error) {
+	status := ThreadPoolStatus{ThreadPoolConfig: d.threadPool}
+	err := d.withInternalConn(func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(context.Background(),
+			"SHOW GLOBAL STATUS WHERE Variable_name IN ('Threadpool_threads', 'Threadpool_idle_threads', 'Threads_connected')")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name, value string
+			if err := rows.Scan(&name, &value); err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+			switch name {
+			case "Threadpool_threads":
+				status.Threads = n
+			case "Threadpool_idle_threads":
+				status.IdleThreads = n
+			case "Threads_connected":
+				status.ConnectedThreads = n
+			}
+		}
+		return rows.Err()
+	})
+	return status, err
+}
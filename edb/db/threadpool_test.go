@@ -0,0 +1,37 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadPoolOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("thread-handling=pool-of-threads\nthread-pool-max-threads=32\n", ThreadPoolConfig{MaxThreads: 32}.options())
+	assert.Equal("thread-handling=pool-of-threads\nthread-pool-max-threads=32\nthread-pool-size=4\nthread-pool-stall-limit=100\n",
+		ThreadPoolConfig{Size: 4, MaxThreads: 32, StallLimit: 100}.options())
+}
+
+func TestGetThreadPoolStatusNotRunning(t *testing.T) {
+	d := &Mariadb{threadPool: ThreadPoolConfig{MaxThreads: 32}}
+	status, err := d.GetThreadPoolStatus()
+	assert.Error(t, err)
+	assert.Equal(t, 32, status.MaxThreads)
+}
@@ -24,7 +24,9 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/edgelesssys/edgelessdb/edb/util"
@@ -36,7 +38,8 @@ func splitHostPort(address, defaultPort string) (host, port string) {
 	}
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
-		return address, defaultPort
+		// address is a host without port, e.g., "1.2.3.4", "::1", or "[::1]"
+		return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"), defaultPort
 	}
 	if host == "" {
 		host = "0.0.0.0"
@@ -47,6 +50,49 @@ func splitHostPort(address, defaultPort string) (host, port string) {
 	return
 }
 
+// bindAddress returns the host and port that MariaDB should bind to so that it listens on all of the addresses.
+// MariaDB listens on a single port, so multiple addresses must share it. They're passed as a comma-separated bind-address.
+func bindAddress(addresses []string, defaultPort string) (host, port string, err error) {
+	if len(addresses) <= 1 {
+		var address string
+		if len(addresses) == 1 {
+			address = addresses[0]
+		}
+		host, port = splitHostPort(strings.TrimSpace(address), defaultPort)
+		return host, port, nil
+	}
+
+	var hosts []string
+	wildcards := true
+	for _, address := range addresses {
+		h, p := splitHostPort(strings.TrimSpace(address), defaultPort)
+		if port != "" && p != port {
+			return "", "", fmt.Errorf("all database addresses must use the same port: %v", addresses)
+		}
+		port = p
+		ip := net.ParseIP(h)
+		if ip == nil {
+			return "", "", fmt.Errorf("multiple database addresses must be IP addresses: %v", addresses)
+		}
+		if !ip.IsUnspecified() {
+			wildcards = false
+		}
+		hosts = append(hosts, ip.String())
+	}
+
+	if wildcards {
+		// "*" lets MariaDB listen on all IPv6 and IPv4 addresses
+		return "*", port, nil
+	}
+	// a wildcard would conflict with the concrete addresses of the same IP version
+	for _, h := range hosts {
+		if net.ParseIP(h).IsUnspecified() {
+			return "", "", fmt.Errorf("wildcard addresses can't be combined with other database addresses: %v", addresses)
+		}
+	}
+	return strings.Join(hosts, ","), port, nil
+}
+
 func toPEM(cert []byte, key crypto.PrivateKey) (pemCert, pemKey []byte, err error) {
 	pemCert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
 	if len(pemCert) <= 0 {
@@ -89,3 +135,49 @@ func createCertificate(dnsName string) ([]byte, crypto.PrivateKey, error) {
 	}
 	return cert, priv, nil
 }
+
+// createServerCertificate creates a certificate for dnsName that is signed by the root certificate.
+func createServerCertificate(dnsName string, notAfter time.Time, signerCert []byte, signerKey crypto.PrivateKey) ([]byte, crypto.PrivateKey, error) {
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{Organization: []string{"EDB server"}, CommonName: dnsName},
+		NotAfter:     notAfter,
+		DNSNames:     []string{dnsName},
+	}
+	parsedSignerCert, err := x509.ParseCertificate(signerCert)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, parsedSignerCert, &priv.PublicKey, signerKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, priv, nil
+}
+
+// createCrossCertificate signs the root certificate cert with another root certificate.
+// Clients that trust the signer can then verify certificates that have been issued by cert.
+func createCrossCertificate(cert []byte, key crypto.PrivateKey, notAfter time.Time, signerCert []byte, signerKey crypto.PrivateKey) ([]byte, error) {
+	template, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	template.NotAfter = notAfter
+	parsedSignerCert, err := x509.ParseCertificate(signerCert)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("root key is not a signer")
+	}
+	return x509.CreateCertificate(rand.Reader, template, parsedSignerCert, signer.Public(), signerKey)
+}
@@ -19,8 +19,10 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSplitHostPort(t *testing.T) {
@@ -54,6 +56,63 @@ func TestSplitHostPort(t *testing.T) {
 	h, p = splitHostPort("addr:port", defport)
 	assert.Equal("addr", h)
 	assert.Equal("port", p)
+
+	h, p = splitHostPort("::", defport)
+	assert.Equal("::", h)
+	assert.Equal(defport, p)
+
+	h, p = splitHostPort("[::1]", defport)
+	assert.Equal("::1", h)
+	assert.Equal(defport, p)
+
+	h, p = splitHostPort("[::1]:port", defport)
+	assert.Equal("::1", h)
+	assert.Equal("port", p)
+}
+
+func TestBindAddress(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h, p, err := bindAddress(nil, "3306")
+	require.NoError(err)
+	assert.Equal("0.0.0.0", h)
+	assert.Equal("3306", p)
+
+	h, p, err = bindAddress([]string{"[::]:3307"}, "3306")
+	require.NoError(err)
+	assert.Equal("::", h)
+	assert.Equal("3307", p)
+
+	h, p, err = bindAddress([]string{"0.0.0.0", "::"}, "3306")
+	require.NoError(err)
+	assert.Equal("*", h)
+	assert.Equal("3306", p)
+
+	h, p, err = bindAddress([]string{"0.0.0.0:3307", "[::]:3307"}, "3306")
+	require.NoError(err)
+	assert.Equal("*", h)
+	assert.Equal("3307", p)
+
+	// concrete IPv4 and IPv6 addresses
+	h, p, err = bindAddress([]string{"10.0.0.5", "fd00::5"}, "3306")
+	require.NoError(err)
+	assert.Equal("10.0.0.5,fd00::5", h)
+	assert.Equal("3306", p)
+
+	h, p, err = bindAddress([]string{"10.0.0.5:3307", " [fd00::5]:3307", "[::1]:3307"}, "3306")
+	require.NoError(err)
+	assert.Equal("10.0.0.5,fd00::5,::1", h)
+	assert.Equal("3307", p)
+
+	_, _, err = bindAddress([]string{"0.0.0.0:3307", "[::]:3308"}, "3306")
+	assert.Error(err)
+	_, _, err = bindAddress([]string{"10.0.0.5:3307", "[fd00::5]:3308"}, "3306")
+	assert.Error(err)
+	_, _, err = bindAddress([]string{"1.2.3.4", "::"}, "3306")
+	assert.Error(err)
+	_, _, err = bindAddress([]string{"localhost", "::1"}, "3306")
+	assert.Error(err)
 }
 
 func TestToPEM(t *testing.T) {
@@ -75,3 +134,46 @@ func TestToPEM(t *testing.T) {
 	assert.NotNil(block)
 	assert.Equal(keyBytes, block.Bytes)
 }
+
+func TestCreateCrossCertificate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oldCert, oldKey, err := createCertificate("localhost")
+	require.NoError(err)
+	newCert, newKey, err := createCertificate("localhost")
+	require.NoError(err)
+
+	notAfter := time.Now().Add(time.Hour)
+	crossCert, err := createCrossCertificate(newCert, newKey, notAfter, oldCert, oldKey)
+	require.NoError(err)
+	serverCert, _, err := createServerCertificate("localhost", notAfter, newCert, newKey)
+	require.NoError(err)
+
+	parsedServerCert, err := x509.ParseCertificate(serverCert)
+	require.NoError(err)
+	parsedCrossCert, err := x509.ParseCertificate(crossCert)
+	require.NoError(err)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(parsedCrossCert)
+
+	// the server certificate must be valid for clients that trust either root
+	for _, root := range [][]byte{oldCert, newCert} {
+		parsedRoot, err := x509.ParseCertificate(root)
+		require.NoError(err)
+		roots := x509.NewCertPool()
+		roots.AddCert(parsedRoot)
+		_, err = parsedServerCert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots, Intermediates: intermediates})
+		assert.NoError(err)
+	}
+
+	// but not for others
+	otherCert, _, err := createCertificate("localhost")
+	require.NoError(err)
+	parsedOtherCert, err := x509.ParseCertificate(otherCert)
+	require.NoError(err)
+	roots := x509.NewCertPool()
+	roots.AddCert(parsedOtherCert)
+	_, err = parsedServerCert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots, Intermediates: intermediates})
+	assert.Error(err)
+}
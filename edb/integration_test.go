@@ -223,6 +223,50 @@ func TestPersistence(t *testing.T) {
 	assert.Equal(2., val)
 }
 
+func TestGracefulShutdown(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	caCert, caKey := createCertificate("ca", "", "")
+	usrCert, usrKey := createCertificate("usr", caCert, caKey)
+
+	manifest := createManifest(caCert, []string{
+		"CREATE USER usr REQUIRE ISSUER '/CN=ca' SUBJECT '/CN=usr'",
+		"CREATE DATABASE test",
+		"CREATE TABLE test.data (i INT)",
+		"GRANT ALL ON test.data TO usr",
+	}, false, "")
+
+	setConfig(false, "")
+	defer cleanupConfig()
+
+	process := startEDB("")
+	require.NotNil(process)
+
+	serverCert := getServerCertificate()
+	_, err := postManifest(serverCert, manifest, true)
+	require.NoError(err)
+
+	db := sqlOpen("usr", usrCert, usrKey, serverCert)
+	_, err = db.Exec("INSERT INTO test.data VALUES (2)")
+	db.Close()
+	require.NoError(err)
+
+	// edb must exit with status 0, otherwise startEDB's goroutine panics
+	require.NoError(process.Terminate())
+
+	// Restart
+	process = startEDB("")
+	require.NotNil(process, "restart failed!")
+	defer process.Kill()
+
+	var val float64
+	db = sqlOpen("usr", usrCert, usrKey, serverCert)
+	assert.NoError(db.QueryRow("SELECT i FROM test.data").Scan(&val))
+	db.Close()
+	assert.Equal(2., val)
+}
+
 func TestPersistenceEmptyDatabase(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -365,32 +409,79 @@ func TestMisc(t *testing.T) {
 
 func TestInvalidQueryInManifest(t *testing.T) {
 	assert := assert.New(t)
+	require := require.New(t)
 
 	setConfig(false, "")
 	defer cleanupConfig()
 
 	process := startEDB("")
 	assert.NotNil(process)
+	defer process.Kill()
 
 	serverCert := getServerCertificate()
 
 	_, err := postManifest(serverCert, createManifest("", []string{
+		"CREATE DATABASE test",
 		"CREATE TABL test.data (i INT)",
-	}, false, ""), true)
-	assert.Error(err)
-
-	// DB cannot be initialized after failed attempt
+	}, false, ""), false)
+	require.Error(err)
+
+	// The response names the failed statement
+	var response struct{ Data []db.Event }
+	require.NoError(json.Unmarshal([]byte(err.Error()), &response))
+	require.Len(response.Data, 1)
+	require.NotNil(response.Data[0].Statement)
+	assert.Equal(1, *response.Data[0].Statement)
+	assert.Equal("CREATE TABL test.data (i INT)", response.Data[0].StatementText)
+	assert.Equal(1064, response.Data[0].Code)
+
+	// edb cleans up and restarts, so the corrected manifest can be posted
+	serverCert = waitUntilAvailable()
 	_, err = postManifest(serverCert, createManifest("", []string{
+		"CREATE DATABASE test",
 		"CREATE TABLE test.data (i INT)",
 	}, false, ""), true)
-	assert.Error(err)
+	assert.NoError(err)
+}
 
-	assert.Nil(process.Kill())
+func TestNoConnectionsDuringInitialization(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	caCert, caKey := createCertificate("ca", "", "")
+	usrCert, usrKey := createCertificate("usr", caCert, caKey)
+
+	// The user exists while the remaining statement is still running
+	manifest := createManifest(caCert, []string{
+		"CREATE USER usr REQUIRE ISSUER '/CN=ca' SUBJECT '/CN=usr'",
+		"DO SLEEP(5)",
+	}, false, "")
+
+	setConfig(false, "")
+	defer cleanupConfig()
+	process := startEDB("")
+	require.NotNil(process)
+	defer process.Kill()
 
-	// DB cannot be started after failed attempt
-	log.SetOutput(ioutil.Discard)
-	assert.Error(createEdbCmd("").Run())
-	log.SetOutput(os.Stdout)
+	serverCert := getServerCertificate()
+	initialized := make(chan error)
+	go func() {
+		_, err := postManifest(serverCert, manifest, false)
+		initialized <- err
+	}()
+
+	db := sqlOpen("usr", usrCert, usrKey, serverCert)
+	defer db.Close()
+	for {
+		select {
+		case err := <-initialized:
+			require.NoError(err)
+			assert.NoError(db.Ping())
+			return
+		case <-time.After(100 * time.Millisecond):
+			assert.Error(db.Ping())
+		}
+	}
 }
 
 func TestCurl(t *testing.T) {
@@ -913,6 +1004,23 @@ func getServerCertificate() string {
 	return string(pem.EncodeToMemory(blocks[0]))
 }
 
+// waitUntilAvailable waits until edb restarted after a failed initialization and returns its new certificate.
+func waitUntilAvailable() string {
+	// edb restarts one second after the failed initialization
+	time.Sleep(3 * time.Second)
+	log.Print("waiting for restart ...")
+	for {
+		blocks, err := era.InsecureGetCertificate(addrAPI)
+		if attestationEnabled && *attestationConfig != "" {
+			blocks, _, err = era.GetCertificate(addrAPI, *attestationConfig)
+		}
+		if err == nil {
+			return string(pem.EncodeToMemory(blocks[0]))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
 func createManifest(ca string, sql []string, debug bool, recovery string) []byte {
 	manifest := struct {
 		SQL      []string
@@ -1148,3 +1256,10 @@ func (k killer) Kill() error {
 	k.wg.Wait()
 	return err
 }
+
+// Terminate sends SIGTERM and waits until edb has exited.
+func (k killer) Terminate() error {
+	err := k.proc.Signal(syscall.SIGTERM)
+	k.wg.Wait()
+	return err
+}
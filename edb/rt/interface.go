@@ -23,6 +23,9 @@ type Runtime interface {
 	// GetRemoteReport gets a report signed by the enclave platform for use in remote attestation.
 	GetRemoteReport(reportData []byte) ([]byte, error)
 
+	// VerifyPeerReport verifies that the report has been created by an enclave with the same identity as this one and that it includes reportData.
+	VerifyPeerReport(report, reportData []byte) error
+
 	// GetProductSealKey gets a key derived from the signer and product id of the enclave.
 	GetProductSealKey() ([]byte, error)
 
@@ -0,0 +1,275 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package rt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels in increasing severity
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a log level. The empty string is LevelInfo.
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %q", name)
+}
+
+// Log formats
+const (
+	// FormatText writes an entry per line as logfmt key=value pairs. Levels are colored if the output is a terminal.
+	FormatText = "text"
+	// FormatJSON writes an entry per line as JSON object.
+	FormatJSON = "json"
+)
+
+// ValidateFormat checks that format is a known log format. The empty string is FormatText.
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown log format: %q", format)
+}
+
+var levelColors = []*color.Color{
+	color.New(color.FgHiBlack),
+	color.New(color.FgCyan),
+	color.New(color.FgYellow),
+	color.New(color.FgRed),
+}
+
+func init() {
+	// The logger decides whether to use colors based on its output.
+	for _, c := range levelColors {
+		c.EnableColor()
+	}
+}
+
+// Logger writes leveled, structured log entries. An entry consists of a message and key-value pairs.
+// Loggers derived with With share the output and configuration of their parent.
+type Logger struct {
+	output *logOutput
+	fields []interface{}
+}
+
+type logOutput struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+	color  bool
+	now    func() time.Time
+}
+
+// Log is the logger used by all EDB components.
+var Log = NewLogger(os.Stdout, FormatText, LevelInfo)
+
+// NewLogger creates a logger that writes entries of at least the given level to w.
+func NewLogger(w io.Writer, format string, level Level) *Logger {
+	l := &Logger{output: &logOutput{w: w, now: time.Now}}
+	if err := l.Configure(format, level); err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Configure changes the format and level of the logger and all loggers derived from it.
+func (l *Logger) Configure(format string, level Level) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	if format == "" {
+		format = FormatText
+	}
+	o := l.output
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.format = format
+	o.level = level
+	o.color = format == FormatText && isTerminal(o.w)
+	return nil
+}
+
+// With returns a logger that adds the key-value pairs to each entry.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	return &Logger{output: l.output, fields: append(fields, keyvals...)}
+}
+
+// Enabled tells if entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.output.mutex.Lock()
+	defer l.output.mutex.Unlock()
+	return level >= l.output.level
+}
+
+// Debug logs a message with key-value pairs at LevelDebug.
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info logs a message with key-value pairs at LevelInfo.
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Warn logs a message with key-value pairs at LevelWarn.
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+// Error logs a message with key-value pairs at LevelError.
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Fatal logs a message with key-value pairs at LevelError and exits the process.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	o := l.output
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if level < o.level {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(keyvals))
+	fields = append(fields, "time", o.now().UTC().Format(time.RFC3339Nano), "level", level, "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	var b strings.Builder
+	if o.format == FormatJSON {
+		writeJSONEntry(&b, fields)
+	} else {
+		writeTextEntry(&b, fields, o.color)
+	}
+	b.WriteByte('\n')
+	// There is nowhere to report a failed write to.
+	_, _ = io.WriteString(o.w, b.String())
+}
+
+func writeJSONEntry(b *strings.Builder, fields []interface{}) {
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(jsonValue(fields[i+1]))
+	}
+	b.WriteByte('}')
+}
+
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	return data
+}
+
+func writeTextEntry(b *strings.Builder, fields []interface{}, useColor bool) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+		value := textValue(fields[i+1])
+		if level, ok := fields[i+1].(Level); ok && useColor && level >= LevelDebug && level <= LevelError {
+			value = levelColors[level].Sprint(value)
+		}
+		b.WriteString(value)
+	}
+}
+
+func textValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprintf("%+v", v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\\") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
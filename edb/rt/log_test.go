@@ -0,0 +1,100 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package rt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(format string, level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := NewLogger(&buf, format, level)
+	l.output.now = func() time.Time { return time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestLoggerText(t *testing.T) {
+	assert := assert.New(t)
+
+	l, buf := newTestLogger(FormatText, LevelInfo)
+	l.With("component", "core").Info("snapshot completed", "snapshot", "s1", "error", errors.New("a b"), "n", 2, "empty", "")
+	assert.Equal(`time=2023-01-02T03:04:05Z level=info msg="snapshot completed" component=core snapshot=s1 error="a b" n=2 empty=""`+"\n", buf.String())
+
+	buf.Reset()
+	l.Info("line\nbreak", "key")
+	assert.Equal(`time=2023-01-02T03:04:05Z level=info msg="line\nbreak" key=(missing)`+"\n", buf.String())
+}
+
+func TestLoggerJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	l, buf := newTestLogger(FormatJSON, LevelInfo)
+	l.With("state", LevelWarn).Warn("failed", "error", errors.New("e"), "duration", time.Second, "config", struct{ A int }{1})
+
+	var entry map[string]interface{}
+	require.NoError(json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(map[string]interface{}{
+		"time":     "2023-01-02T03:04:05Z",
+		"level":    "warn",
+		"msg":      "failed",
+		"state":    "warn",
+		"error":    "e",
+		"duration": "1s",
+		"config":   map[string]interface{}{"A": 1.0},
+	}, entry)
+}
+
+func TestLoggerLevel(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	l, buf := newTestLogger(FormatText, LevelWarn)
+	derived := l.With("component", "db")
+	derived.Debug("debug")
+	derived.Info("info")
+	assert.Empty(buf.String())
+	derived.Error("error")
+	assert.Contains(buf.String(), "level=error msg=error component=db")
+
+	// derived loggers follow the configuration of their parent
+	buf.Reset()
+	require.NoError(l.Configure(FormatJSON, LevelDebug))
+	assert.True(derived.Enabled(LevelDebug))
+	derived.Debug("debug")
+	assert.Contains(buf.String(), `"level":"debug"`)
+
+	assert.Error(l.Configure("xml", LevelInfo))
+}
+
+func TestParseLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, want := range map[string]Level{"": LevelInfo, "debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarn, "error": LevelError} {
+		level, err := ParseLevel(name)
+		assert.NoError(err)
+		assert.Equal(want, level)
+	}
+	_, err := ParseLevel("verbose")
+	assert.Error(err)
+}
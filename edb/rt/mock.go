@@ -16,6 +16,7 @@
 package rt
 
 import (
+	"bytes"
 	"errors"
 )
 
@@ -35,6 +36,14 @@ func (r RuntimeMock) GetRemoteReport(reportData []byte) ([]byte, error) {
 	return []byte{2, 3, 4}, nil
 }
 
+// VerifyPeerReport accepts the report returned by GetRemoteReport.
+func (r RuntimeMock) VerifyPeerReport(report, reportData []byte) error {
+	if !bytes.Equal(report, []byte{2, 3, 4}) {
+		return errors.New("invalid report")
+	}
+	return nil
+}
+
 // GetProductSealKey gets a key derived from the signer and product id of the enclave.
 func (r RuntimeMock) GetProductSealKey() ([]byte, error) {
 	return []byte{3, 4, 5}, nil
@@ -0,0 +1,121 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/edgelesssys/edgelessdb/edb/rt"
+)
+
+// requestIDHeader holds the ID of a request. A valid ID sent by the client is used, otherwise the server generates one.
+// The response always contains the ID so that clients can correlate their requests with the log.
+const requestIDHeader = "X-Request-ID"
+
+// serverLog is the logger of the server component.
+var serverLog = rt.Log.With("component", "server")
+
+type requestLogKey struct{}
+
+// logRequests assigns an ID to each request and logs it after it has been handled.
+// Successful reads are only logged at debug level because clients poll, e.g., /status.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		log := serverLog.With("request_id", id)
+		w.Header().Set(requestIDHeader, id)
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		defer func() {
+			p := recover()
+			fields := []interface{}{"method", r.Method, "path", r.URL.Path, "status", sw.status, "duration", time.Since(start), "remote", r.RemoteAddr}
+			switch {
+			case p != nil:
+				// An aborted response has no complete status.
+				log.Warn("request aborted", fields...)
+				panic(p)
+			case sw.status < http.StatusBadRequest && r.Method == http.MethodGet:
+				log.Debug("request", fields...)
+			default:
+				log.Info("request", fields...)
+			}
+		}()
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, log)))
+	})
+}
+
+// requestLog returns the logger for entries that belong to the request.
+func requestLog(r *http.Request) *rt.Logger {
+	if log, ok := r.Context().Value(requestLogKey{}).(*rt.Logger); ok {
+		return log
+	}
+	return serverLog
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// statusWriter records the status of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush supports streamed responses like backups.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
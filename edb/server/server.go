@@ -16,17 +16,24 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/edgelesssys/edgelessdb/edb/core"
-	"github.com/edgelesssys/edgelessdb/edb/rt"
+	"github.com/edgelesssys/edgelessdb/edb/db"
 )
 
 type generalResponse struct {
@@ -35,11 +42,30 @@ type generalResponse struct {
 	Message string      `json:"message,omitempty"` // only used when status = "error"
 }
 
+// defaultCertificateOverlap is the time the previous root certificate stays valid after a renewal if the request doesn't specify one.
+const defaultCertificateOverlap = 30 * 24 * time.Hour
+
+// backupKeyHeader is the request header of /restore that holds the base64-encoded data key of the backup.
+const backupKeyHeader = "EDB-Backup-Key"
+
+// manifestSignatureHeader is the request header of /restore that holds the hex-encoded signature of the manifest that the backup must have.
+const manifestSignatureHeader = "EDB-Manifest-Signature"
+
 type certQuoteResp struct {
 	Cert  string
 	Quote []byte
 }
 
+// pitrResp holds the start time of the last transaction that has been replayed. It's zero if none has been replayed.
+type pitrResp struct {
+	LastTransaction time.Time
+}
+
+type csrQuoteResp struct {
+	CSR   string
+	Quote []byte
+}
+
 // CreateServeMux creates a mux that serves the edb API.
 func CreateServeMux(core *core.Core) *http.ServeMux {
 	mux := http.NewServeMux()
@@ -56,6 +82,12 @@ func CreateServeMux(core *core.Core) *http.ServeMux {
 		}
 		recoveryKey, err := core.Initialize(jsonManifest)
 		if err != nil {
+			// Report the diagnosed MariaDB errors, e.g., the failed manifest statement
+			var diagErr *db.DiagnosisError
+			if errors.As(err, &diagErr) {
+				writeJSONErrorWithData(w, err.Error(), diagErr.Events, http.StatusBadRequest)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -78,6 +110,97 @@ func CreateServeMux(core *core.Core) *http.ServeMux {
 		writeJSON(w, certQuoteResp{cert, report})
 	})
 
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		crl, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := core.UpdateCRL(clientCertificate(r), crl); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	})
+
+	mux.HandleFunc("/cas", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		cas, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := core.UpdateCAs(clientCertificate(r), cas); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	})
+
+	mux.HandleFunc("/renew", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		overlap, err := getOverlap(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := core.RenewCertificate(clientCertificate(r), overlap); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	})
+
+	mux.HandleFunc("/csr", func(w http.ResponseWriter, r *http.Request) {
+		// The first request creates the key of the CSR.
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		csr, report, err := core.CreateCertificateRequest(clientCertificate(r))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isForbidden(err) {
+				status = http.StatusForbidden
+			}
+			writeJSONError(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, csrQuoteResp{csr, report})
+	})
+
+	mux.HandleFunc("/certificate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		overlap, err := getOverlap(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chain, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := core.SetCertificate(clientCertificate(r), chain, overlap); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, core.GetStatus())
+	})
+
 	mux.HandleFunc("/recover", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -88,8 +211,20 @@ func CreateServeMux(core *core.Core) *http.ServeMux {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// Rate limiting is per client IP address.
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
 		var statusMsg string
-		if err := core.Recover(r.Context(), key); err != nil {
+		if err := core.Recover(r.Context(), client, key); err != nil {
+			if status, retryAfter := recoverRejection(err); status != 0 {
+				if retryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				}
+				writeJSONError(w, err.Error(), status)
+				return
+			}
 			statusMsg = fmt.Sprintf("Recovery failed: %v", err.Error())
 		} else {
 			statusMsg = "Recovery successful."
@@ -97,19 +232,340 @@ func CreateServeMux(core *core.Core) *http.ServeMux {
 		writeJSON(w, statusMsg)
 	})
 
+	mux.HandleFunc("/recover/unlock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		signature, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := core.UnlockRecovery(signature); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, nil)
+	})
+
+	mux.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		cw := &countingWriter{w: w}
+		if err := core.Backup(cw, clientCertificate(r)); err != nil {
+			if cw.n > 0 {
+				// The status has already been sent. Abort so that the client doesn't get an incomplete backup.
+				requestLog(r).Error("backup failed", "error", err)
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Del("Content-Type")
+			http.Error(w, err.Error(), errorStatus(err))
+		}
+	})
+
+	mux.HandleFunc("/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := base64.StdEncoding.DecodeString(r.Header.Get(backupKeyHeader))
+		if err != nil {
+			http.Error(w, "invalid "+backupKeyHeader+" header: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifestSignature, err := hex.DecodeString(r.Header.Get(manifestSignatureHeader))
+		if err != nil || len(manifestSignature) == 0 {
+			http.Error(w, "invalid "+manifestSignatureHeader+" header", http.StatusBadRequest)
+			return
+		}
+		recoveryKey, err := core.Restore(r.Body, key, manifestSignature)
+		if err != nil {
+			var diagErr *db.DiagnosisError
+			if errors.As(err, &diagErr) {
+				writeJSONErrorWithData(w, err.Error(), diagErr.Events, http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if recoveryKey != nil {
+			io.WriteString(w, base64.StdEncoding.EncodeToString(recoveryKey))
+		}
+	})
+
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		name, err := core.Snapshot(clientCertificate(r))
+		if err != nil {
+			writeJSONError(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, name)
+	})
+
+	mux.HandleFunc("/replica", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		cw := &countingWriter{w: w}
+		if err := core.AddReplica(cw, r.Body, clientCertificate(r)); err != nil {
+			if cw.n > 0 {
+				// The status has already been sent. Abort so that the replica doesn't get an incomplete checkpoint.
+				requestLog(r).Error("sending checkpoint to replica failed", "error", err)
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Del("Content-Type")
+			http.Error(w, err.Error(), errorStatus(err))
+		}
+	})
+
+	mux.HandleFunc("/promote", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := core.Promote(clientCertificate(r)); err != nil {
+			writeJSONError(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, nil)
+	})
+
+	mux.HandleFunc("/readonly", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		readOnly, err := strconv.ParseBool(r.URL.Query().Get("enable"))
+		if err != nil {
+			writeJSONError(w, "invalid enable: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var persist bool
+		if value := r.URL.Query().Get("persist"); value != "" {
+			if persist, err = strconv.ParseBool(value); err != nil {
+				writeJSONError(w, "invalid persist: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := core.SetReadOnly(clientCertificate(r), readOnly, persist); err != nil {
+			writeJSONError(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, nil)
+	})
+
+	mux.HandleFunc("/audit", func(w http.ResponseWriter, r *http.Request) {
+		auditLog, err := core.GetAuditLog(clientCertificate(r))
+		if err != nil {
+			writeJSONError(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, auditLog)
+	})
+
+	mux.HandleFunc("/digests", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		limit, err := getQueryDigestLimit(r)
+		if err != nil {
+			writeJSONError(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		digests, err := core.GetQueryDigests(clientCertificate(r), limit)
+		if err != nil {
+			writeJSONError(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, digests)
+	})
+
+	mux.HandleFunc("/pitr", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		until, err := time.Parse(time.RFC3339, r.URL.Query().Get("until"))
+		if err != nil {
+			writeJSONError(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		last, err := core.ReplayBinlogs(clientCertificate(r), r.URL.Query().Get("snapshot"), until)
+		if err != nil {
+			writeJSONErrorWithData(w, err.Error(), pitrResp{last}, errorStatus(err))
+			return
+		}
+		writeJSON(w, pitrResp{last})
+	})
+
 	return mux
 }
 
-// RunServer runs a HTTP server serving mux.
-func RunServer(mux *http.ServeMux, address string, tlsConfig *tls.Config) {
-	server := http.Server{
-		Addr:      address,
-		Handler:   mux,
-		TLSConfig: tlsConfig,
+// clientCertificate returns the certificate the client has presented, if any.
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// errorStatus returns the HTTP status for an error of a privileged operation.
+func errorStatus(err error) int {
+	if isForbidden(err) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// recoverRejection returns the HTTP status and the time to wait if a recovery attempt has been rejected before the key has been tried.
+// It returns 0 if the attempt has failed otherwise.
+func recoverRejection(err error) (int, time.Duration) {
+	var rateLimitedErr *core.RecoveryRateLimitedError
+	if errors.As(err, &rateLimitedErr) {
+		return http.StatusTooManyRequests, rateLimitedErr.RetryAfter
+	}
+	if errors.Is(err, core.ErrRecoveryLocked) {
+		return http.StatusLocked, 0
+	}
+	return 0, 0
+}
+
+// isForbidden returns whether the client isn't authorized for a privileged operation.
+func isForbidden(err error) bool {
+	return errors.Is(err, core.ErrNotAdmin) || errors.Is(err, core.ErrNotPermitted) || errors.Is(err, core.ErrNotAttested)
+}
+
+// countingWriter counts the bytes written so that a handler knows whether it can still send an error status.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// getQueryDigestLimit gets the maximum number of query digests to return.
+func getQueryDigestLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return core.DefaultQueryDigestLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 || limit > core.MaxQueryDigestLimit {
+		return 0, fmt.Errorf("must be between 1 and %v", core.MaxQueryDigestLimit)
+	}
+	return limit, nil
+}
+
+// getOverlap gets the time the previous root certificate stays valid after it has been replaced.
+func getOverlap(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("overlap")
+	if value == "" {
+		return defaultCertificateOverlap, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Server is the HTTP REST API server.
+type Server struct {
+	server http.Server
+}
+
+// NewServer creates a server for mux. It logs each request.
+func NewServer(mux *http.ServeMux, tlsConfig *tls.Config) *Server {
+	return &Server{server: http.Server{Handler: logRequests(mux), TLSConfig: tlsConfig}}
+}
+
+// ListenAndServe serves on all addresses. It returns http.ErrServerClosed after Shutdown.
+func (s *Server) ListenAndServe(addresses []string) error {
+	listeners, err := listen(addresses)
+	if err != nil {
+		return err
+	}
+	// ServeTLS doesn't close the listener if it fails before serving
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		serverLog.Info("HTTP REST API listening", "address", listener.Addr())
+		go func(listener net.Listener) {
+			errs <- s.server.ServeTLS(listener, "", "")
+		}(listener)
+	}
+
+	err = <-errs
+	if err != http.ErrServerClosed {
+		s.server.Close()
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits for active requests to complete.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// listen creates a TCP listener for each address.
+func listen(addresses []string) ([]net.Listener, error) {
+	if len(addresses) <= 0 {
+		addresses = []string{":https"}
+	}
+
+	var listeners []net.Listener
+	for _, address := range addresses {
+		listener, err := net.Listen(listenNetwork(address, len(addresses) > 1), address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
 	}
+	return listeners, nil
+}
 
-	rt.Log.Println("HTTP REST API listening on", address)
-	rt.Log.Fatalln(server.ListenAndServeTLS("", ""))
+// listenNetwork returns the network to listen on for the address.
+// A single wildcard address like [::]:8080 listens on both IPv4 and IPv6. If there are multiple addresses,
+// IP literals are bound to their own address family so that, e.g., 0.0.0.0:8080 and [::]:8080 don't conflict.
+func listenNetwork(address string, multiple bool) string {
+	if !multiple {
+		return "tcp"
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
 }
 
 func writeJSON(w http.ResponseWriter, v interface{}) {
@@ -120,7 +576,11 @@ func writeJSON(w http.ResponseWriter, v interface{}) {
 }
 
 func writeJSONError(w http.ResponseWriter, errorString string, httpErrorCode int) {
-	marshalledJSON, err := json.Marshal(generalResponse{Status: "error", Message: errorString})
+	writeJSONErrorWithData(w, errorString, nil, httpErrorCode)
+}
+
+func writeJSONErrorWithData(w http.ResponseWriter, errorString string, data interface{}, httpErrorCode int) {
+	marshalledJSON, err := json.Marshal(generalResponse{Status: "error", Data: data, Message: errorString})
 	// Only fall back to non-JSON error when we cannot even marshal the error (which is pretty bad)
 	if err != nil {
 		http.Error(w, errorString, httpErrorCode)
@@ -16,18 +16,28 @@
 package server
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/edgelesssys/edgelessdb/edb/core"
 	"github.com/edgelesssys/edgelessdb/edb/db"
@@ -62,6 +72,36 @@ func TestManifest(t *testing.T) {
 	assert.Equal(2, len(db.Man.SQL))
 }
 
+func TestManifestFailedStatement(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, mockDB, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	statement := 1
+	mockDB.InitErr = &db.DiagnosisError{Events: []db.Event{{Kind: db.EventManifestSQL, Code: 1064, Message: "syntax error", Statement: &statement, StatementText: "CREATE TABL t"}}}
+
+	req := httptest.NewRequest("POST", "/manifest", strings.NewReader(`{"sql": ["CREATE DATABASE d", "CREATE TABL t"]}`))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	var response struct {
+		Status  string
+		Data    []db.Event
+		Message string
+	}
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Equal("error", response.Status)
+	require.Len(response.Data, 1)
+	assert.Equal(1, *response.Data[0].Statement)
+	assert.Equal("CREATE TABL t", response.Data[0].StatementText)
+	assert.Equal(1064, response.Data[0].Code)
+	assert.Contains(response.Message, "CREATE TABL t")
+}
+
 func TestManifestRecovery(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -95,6 +135,414 @@ func TestManifestRecovery(t *testing.T) {
 	assert.Equal(sealedKey, plaintext)
 }
 
+func TestCRL(t *testing.T) {
+	assert := assert.New(t)
+
+	core, db, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+	adminCert := initializeWithAdmin(t, core)
+
+	req := httptest.NewRequest("GET", "/crl", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	// no client certificate
+	req = httptest.NewRequest("POST", "/crl", strings.NewReader("crl"))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Nil(db.CRL)
+
+	req = withClientCertificate(httptest.NewRequest("POST", "/crl", strings.NewReader("crl")), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal([]byte("crl"), db.CRL)
+}
+
+func TestCAs(t *testing.T) {
+	assert := assert.New(t)
+
+	core, db, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+	adminCert := initializeWithAdmin(t, core)
+
+	req := httptest.NewRequest("GET", "/cas", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	// no client certificate
+	req = httptest.NewRequest("POST", "/cas", strings.NewReader("[]"))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Nil(db.AddedCAs)
+
+	req = withClientCertificate(httptest.NewRequest("POST", "/cas", strings.NewReader("[]")), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal([]byte("[]"), db.AddedCAs)
+}
+
+func TestRecoverUnlock(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("GET", "/recover/unlock", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	// recovery isn't locked
+	req = httptest.NewRequest("POST", "/recover/unlock", strings.NewReader("signature"))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestQueryDigestLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("POST", "/digests", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	for _, limit := range []string{"0", "1001", "foo"} {
+		req := httptest.NewRequest("GET", "/digests?limit="+limit, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusBadRequest, resp.Code, limit)
+	}
+}
+
+func TestAdminOnlyOperations(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	require.NoError(core.StartDatabase())
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(err)
+	jsonManifest, err := json.Marshal(map[string]interface{}{
+		"admins": map[string]interface{}{
+			"alice": map[string]string{"publicKey": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))},
+		},
+	})
+	require.NoError(err)
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(err)
+
+	// the manifest declares admins, so these operations require an admin certificate
+	for _, path := range []string{"/crl", "/cas", "/renew", "/csr", "/certificate"} {
+		req := httptest.NewRequest("POST", path, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusForbidden, resp.Code, path)
+	}
+	for _, path := range []string{"/audit", "/digests"} {
+		req := httptest.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusForbidden, resp.Code, path)
+	}
+}
+
+func TestOperationsWithoutAdmins(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, db, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	paths := []string{"/crl", "/cas", "/renew", "/csr", "/certificate"}
+	request := func(path string, cert *x509.Certificate) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader("data"))
+		if cert != nil {
+			req = withClientCertificate(req, cert)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// not initialized
+	for _, path := range paths {
+		assert.NotEqual(http.StatusOK, request(path, nil), path)
+	}
+
+	// the manifest declares no admins, so no client is authorized, whether it presents a certificate or not
+	require.NoError(core.StartDatabase())
+	_, err := core.Initialize([]byte(`{}`))
+	require.NoError(err)
+	otherCore, _, _, _ := newCoreWithMocks()
+	cert := initializeWithAdmin(t, otherCore)
+	for _, path := range paths {
+		assert.Equal(http.StatusForbidden, request(path, nil), path)
+		assert.Equal(http.StatusForbidden, request(path, cert), path)
+	}
+	assert.Nil(db.CRL)
+	assert.Nil(db.AddedCAs)
+	assert.Zero(db.RenewOverlap)
+	assert.Nil(db.Chain)
+}
+
+func TestBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("POST", "/backup", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	// not initialized
+	req = httptest.NewRequest("GET", "/backup", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	_, err := core.Initialize([]byte(`{"admins":{}}`))
+	assert.NoError(err)
+
+	// no client certificate
+	req = httptest.NewRequest("GET", "/backup", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusForbidden, resp.Code)
+}
+
+func TestSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("GET", "/snapshot", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	// no snapshot dir
+	req = httptest.NewRequest("POST", "/snapshot", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestPITR(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("GET", "/pitr", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	req = httptest.NewRequest("POST", "/pitr?snapshot=20210101-000000&until=yesterday", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Contains(resp.Body.String(), "until")
+
+	// no binlog dir
+	req = httptest.NewRequest("POST", "/pitr?snapshot=20210101-000000&until=2021-01-01T00:00:00Z", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestReplication(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	for _, path := range []string{"/replica", "/promote"} {
+		req := httptest.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+	}
+
+	// replication disabled
+	req := httptest.NewRequest("POST", "/replica", strings.NewReader("{}"))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	// not initialized
+	req = httptest.NewRequest("POST", "/promote", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("GET", "/readonly?enable=true", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	for _, query := range []string{"", "?enable=maybe", "?enable=true&persist=maybe"} {
+		req := httptest.NewRequest("POST", "/readonly"+query, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusBadRequest, resp.Code, query)
+		assert.Contains(resp.Body.String(), "invalid", query)
+	}
+
+	// not initialized
+	req = httptest.NewRequest("POST", "/readonly?enable=true", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.False(core.GetStatus().ReadOnly.Enabled)
+}
+
+func TestRestoreInvalidHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	core, _, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+
+	req := httptest.NewRequest("POST", "/restore", strings.NewReader("backup"))
+	req.Header.Set(backupKeyHeader, "not base64")
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Contains(resp.Body.String(), backupKeyHeader)
+
+	// the operator must provide the expected manifest signature
+	for _, signature := range []string{"", "not hex"} {
+		req = httptest.NewRequest("POST", "/restore", strings.NewReader("backup"))
+		req.Header.Set(backupKeyHeader, "AAAA")
+		req.Header.Set(manifestSignatureHeader, signature)
+		resp = httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusBadRequest, resp.Code)
+		assert.Contains(resp.Body.String(), manifestSignatureHeader)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	assert := assert.New(t)
+
+	core, db, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+	adminCert := initializeWithAdmin(t, core)
+
+	// no client certificate
+	req := httptest.NewRequest("POST", "/renew", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Zero(db.RenewOverlap)
+
+	req = withClientCertificate(httptest.NewRequest("POST", "/renew", nil), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(defaultCertificateOverlap, db.RenewOverlap)
+
+	req = withClientCertificate(httptest.NewRequest("POST", "/renew?overlap=1h", nil), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(time.Hour, db.RenewOverlap)
+
+	req = withClientCertificate(httptest.NewRequest("POST", "/renew?overlap=-1h", nil), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestCertificateRequest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	core, db, _, _ := newCoreWithMocks()
+	defer os.Unsetenv("EROCKSDB_MASTERKEY")
+	mux := CreateServeMux(core)
+	adminCert := initializeWithAdmin(t, core)
+
+	// a GET request must not create the key of a CSR
+	req := withClientCertificate(httptest.NewRequest("GET", "/csr", nil), adminCert)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+
+	// no client certificate
+	for _, path := range []string{"/csr", "/certificate"} {
+		req := httptest.NewRequest("POST", path, nil)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		assert.Equal(http.StatusForbidden, resp.Code, path)
+	}
+
+	req = withClientCertificate(httptest.NewRequest("POST", "/csr", nil), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	var csrResp struct{ Data csrQuoteResp }
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &csrResp))
+	block, _ := pem.Decode([]byte(csrResp.Data.CSR))
+	require.NotNil(block)
+	_, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.NoError(err)
+	assert.NotEmpty(csrResp.Data.Quote)
+
+	cert, _, err := createMockRecoveryKey()
+	require.NoError(err)
+	req = withClientCertificate(httptest.NewRequest("POST", "/certificate?overlap=0s", strings.NewReader(cert)), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code) // not a certificate
+
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{2}}))
+	req = withClientCertificate(httptest.NewRequest("POST", "/certificate?overlap=0s", strings.NewReader(certPEM+certPEM)), adminCert)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal([][]byte{{2}, {2}}, db.Chain)
+	assert.Zero(db.RenewOverlap)
+}
+
 func createMockRecoveryKey() (string, *rsa.PrivateKey, error) {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -108,6 +556,32 @@ func createMockRecoveryKey() (string, *rsa.PrivateKey, error) {
 	return string(pemKey), priv, nil
 }
 
+// initializeWithAdmin initializes the database with a manifest that declares an admin and returns the admin's client certificate.
+func initializeWithAdmin(t *testing.T, core *core.Core) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "alice"}, NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+
+	jsonManifest, err := json.Marshal(map[string]interface{}{
+		"admins": map[string]string{"alice": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))},
+	})
+	require.NoError(t, err)
+	require.NoError(t, core.StartDatabase())
+	_, err = core.Initialize(jsonManifest)
+	require.NoError(t, err)
+	return cert
+}
+
+// withClientCertificate lets the request appear to be sent with the client certificate.
+func withClientCertificate(req *http.Request, cert *x509.Certificate) *http.Request {
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	return req
+}
+
 func newCoreWithMocks() (*core.Core, *db.DatabaseMock, afero.Afero, string) {
 	rt := rt.RuntimeMock{}
 	db := db.DatabaseMock{}
@@ -119,3 +593,114 @@ func newCoreWithMocks() (*core.Core, *db.DatabaseMock, afero.Afero, string) {
 	cfg := core.Config{DataPath: tempPath}
 	return core.NewCore(cfg, &rt, &db, fs, false), &db, fs, tempPath
 }
+
+func TestListen(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listeners, err := listen([]string{"127.0.0.1:0", "localhost:0"})
+	require.NoError(err)
+	assert.Len(listeners, 2)
+	for _, listener := range listeners {
+		listener.Close()
+	}
+
+	// the second listener can't bind to the same address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+	_, err = listen([]string{"127.0.0.1:0", listener.Addr().String()})
+	assert.Error(err)
+}
+
+func TestListenNetwork(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("tcp", listenNetwork("[::]:8080", false))
+	assert.Equal("tcp", listenNetwork("0.0.0.0:8080", false))
+	assert.Equal("tcp6", listenNetwork("[::]:8080", true))
+	assert.Equal("tcp4", listenNetwork("0.0.0.0:8080", true))
+	assert.Equal("tcp", listenNetwork("localhost:8080", true))
+	assert.Equal("tcp", listenNetwork(":8080", true))
+}
+
+func TestServerShutdown(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	tlsConfig := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, errors.New("no certificate")
+	}}
+	srv := NewServer(http.NewServeMux(), tlsConfig)
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe([]string{address}) }()
+
+	// wait until the server is listening
+	require.Eventually(func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(srv.Shutdown(ctx))
+	assert.Equal(http.ErrServerClosed, <-errs)
+
+	_, err = net.Dial("tcp", address)
+	assert.Error(err)
+}
+
+func TestServerClosesListenersOnError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	// without certificates, ServeTLS fails before serving
+	srv := NewServer(http.NewServeMux(), &tls.Config{})
+	assert.Error(srv.ListenAndServe([]string{address}))
+
+	_, err = net.Dial("tcp", address)
+	assert.Error(err)
+}
+
+func TestRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	var handlerLog *rt.Logger
+	handler := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerLog = requestLog(r)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	// a valid ID of the client is used
+	req := httptest.NewRequest("POST", "/status", nil)
+	req.Header.Set(requestIDHeader, "client-1")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(http.StatusTeapot, resp.Code)
+	assert.Equal("client-1", resp.Header().Get(requestIDHeader))
+	assert.NotSame(serverLog, handlerLog)
+
+	// otherwise the server generates one
+	for _, id := range []string{"", "a b", strings.Repeat("a", 65)} {
+		req := httptest.NewRequest("GET", "/status", nil)
+		req.Header.Set(requestIDHeader, id)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.Len(resp.Header().Get(requestIDHeader), 16)
+	}
+
+	assert.Same(serverLog, requestLog(httptest.NewRequest("GET", "/status", nil)))
+}
@@ -0,0 +1,122 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package util
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// openSSLCipherNames maps the TLS 1.2 cipher suites that may be used in a TLS policy to their OpenSSL names as used by MariaDB.
+// The certificates of edb have ECDSA keys, so suites that require an RSA certificate could never be negotiated.
+var openSSLCipherNames = map[string]string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       "ECDHE-ECDSA-AES128-GCM-SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       "ECDHE-ECDSA-AES256-GCM-SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": "ECDHE-ECDSA-CHACHA20-POLY1305",
+}
+
+// TLSPolicy restricts the TLS versions and cipher suites accepted by the SQL and REST endpoints.
+// The zero value does not restrict anything and leaves the defaults of Go and MariaDB in place.
+type TLSPolicy struct {
+	MinVersion string `json:",omitempty"`
+	// MaxVersion is set if cipher suites are configured, because TLS 1.3 would negotiate suites that aren't in the list.
+	MaxVersion   string   `json:",omitempty"`
+	CipherSuites []string `json:",omitempty"`
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+}
+
+// ParseTLSPolicy creates a TLSPolicy from a minimum version ("1.2" or "1.3") and a list of TLS 1.2 cipher suite names.
+// Cipher suites use the IANA names, e.g., TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. They restrict the policy to TLS 1.2.
+func ParseTLSPolicy(minVersion string, cipherSuites []string) (TLSPolicy, error) {
+	var policy TLSPolicy
+
+	switch minVersion {
+	case "":
+		if len(cipherSuites) > 0 {
+			// cipher suites can only be configured for TLS 1.2
+			minVersion = "1.2"
+			policy.minVersion = tls.VersionTLS12
+		}
+	case "1.2":
+		policy.minVersion = tls.VersionTLS12
+	case "1.3":
+		if len(cipherSuites) > 0 {
+			return TLSPolicy{}, errors.New("cipher suites cannot be configured for TLS 1.3")
+		}
+		policy.minVersion = tls.VersionTLS13
+	default:
+		return TLSPolicy{}, fmt.Errorf("unsupported TLS version: %v", minVersion)
+	}
+	policy.MinVersion = minVersion
+
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	for _, name := range cipherSuites {
+		name = strings.TrimSpace(name)
+		if _, ok := openSSLCipherNames[name]; !ok {
+			if strings.HasPrefix(name, "TLS_ECDHE_RSA_") {
+				return TLSPolicy{}, fmt.Errorf("unsupported cipher suite: %v requires an RSA certificate, but edb's certificates use ECDSA", name)
+			}
+			return TLSPolicy{}, fmt.Errorf("unsupported cipher suite: %v", name)
+		}
+		id := ids[name]
+		policy.CipherSuites = append(policy.CipherSuites, name)
+		policy.cipherSuites = append(policy.cipherSuites, id)
+	}
+	if len(policy.cipherSuites) > 0 {
+		// The TLS 1.3 suites can't be restricted in Go.
+		policy.MaxVersion = "1.2"
+		policy.maxVersion = tls.VersionTLS12
+	}
+
+	return policy, nil
+}
+
+// Apply restricts the TLS config according to the policy.
+func (p TLSPolicy) Apply(config *tls.Config) {
+	config.MinVersion = p.minVersion
+	config.MaxVersion = p.maxVersion
+	config.CipherSuites = p.cipherSuites
+}
+
+// MariaDBVersions returns the value for MariaDB's tls_version setting or an empty string if MariaDB's default should be used.
+func (p TLSPolicy) MariaDBVersions() string {
+	switch p.minVersion {
+	case tls.VersionTLS12:
+		if p.maxVersion == tls.VersionTLS12 {
+			return "TLSv1.2"
+		}
+		return "TLSv1.2,TLSv1.3"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return ""
+}
+
+// MariaDBCiphers returns the value for MariaDB's ssl_cipher setting or an empty string if MariaDB's default should be used.
+func (p TLSPolicy) MariaDBCiphers() string {
+	names := make([]string, len(p.CipherSuites))
+	for i, name := range p.CipherSuites {
+		names[i] = openSSLCipherNames[name]
+	}
+	return strings.Join(names, ":")
+}
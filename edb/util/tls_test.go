@@ -0,0 +1,84 @@
+/* Copyright (c) Edgeless Systems GmbH
+
+   This program is free software; you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation; version 2 of the License.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program; if not, write to the Free Software
+   Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1335  USA */
+
+package util
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTLSPolicy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Default
+	policy, err := ParseTLSPolicy("", nil)
+	require.NoError(err)
+	assert.Empty(policy.MariaDBVersions())
+	assert.Empty(policy.MariaDBCiphers())
+	var config tls.Config
+	policy.Apply(&config)
+	assert.Zero(config.MinVersion)
+	assert.Zero(config.MaxVersion)
+	assert.Nil(config.CipherSuites)
+
+	// TLS 1.3 only
+	policy, err = ParseTLSPolicy("1.3", nil)
+	require.NoError(err)
+	assert.Equal("TLSv1.3", policy.MariaDBVersions())
+	policy.Apply(&config)
+	assert.EqualValues(tls.VersionTLS13, config.MinVersion)
+	assert.Zero(config.MaxVersion)
+
+	// TLS 1.2 or later
+	policy, err = ParseTLSPolicy("1.2", nil)
+	require.NoError(err)
+	assert.Equal("TLSv1.2,TLSv1.3", policy.MariaDBVersions())
+	policy.Apply(&config)
+	assert.EqualValues(tls.VersionTLS12, config.MinVersion)
+	assert.Zero(config.MaxVersion)
+
+	// TLS 1.2 with fixed cipher list
+	policy, err = ParseTLSPolicy("1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", " TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	require.NoError(err)
+	assert.Equal("1.2", policy.MaxVersion)
+	assert.Equal("TLSv1.2", policy.MariaDBVersions()) // TLS 1.3 would negotiate other suites
+	assert.Equal("ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES128-GCM-SHA256", policy.MariaDBCiphers())
+	policy.Apply(&config)
+	assert.EqualValues(tls.VersionTLS12, config.MinVersion)
+	assert.EqualValues(tls.VersionTLS12, config.MaxVersion)
+	assert.Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, config.CipherSuites)
+
+	// Cipher suites imply TLS 1.2
+	policy, err = ParseTLSPolicy("", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	require.NoError(err)
+	assert.Equal("1.2", policy.MinVersion)
+	assert.Equal("TLSv1.2", policy.MariaDBVersions())
+
+	// Invalid
+	_, err = ParseTLSPolicy("1.1", nil)
+	assert.Error(err)
+	_, err = ParseTLSPolicy("1.3", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	assert.Error(err)
+	_, err = ParseTLSPolicy("1.2", []string{"TLS_RSA_WITH_AES_128_CBC_SHA"})
+	assert.Error(err)
+	// edb's certificates use ECDSA, so RSA suites could never be negotiated
+	_, err = ParseTLSPolicy("1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	assert.Error(err)
+}
@@ -8,9 +8,11 @@ require (
 	github.com/edgelesssys/marblerun v1.0.0
 	github.com/fatih/color v1.15.0
 	github.com/go-sql-driver/mysql v1.7.0
+	github.com/mattn/go-isatty v0.0.17
 	github.com/spf13/afero v1.9.5
 	github.com/stretchr/testify v1.8.2
 	google.golang.org/grpc v1.53.0
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
@@ -23,7 +25,6 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -80,3 +80,12 @@ void edgeless_listen_internal() {
   if (mysql_socket_close(listen_sock) != 0)
     AbortPerror("close");
 }
+
+extern char* opt_ssl_crl;
+
+// Sets the CRL file that is used when MariaDB (re)initializes its SSL context, i.e., at startup or on FLUSH SSL.
+extern "C" void edgeless_set_ssl_crl(const char* path) {
+  static std::string crl;
+  crl = path;
+  opt_ssl_crl = crl.empty() ? nullptr : crl.data();
+}